@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sqlc-dev/pqtype"
+	"okta-scim/db"
+)
+
+// Audited operations
+const (
+	auditCreate       = "create"
+	auditUpdate       = "update"
+	auditDeactivate   = "deactivate"
+	auditDelete       = "delete"
+	auditMemberAdd    = "member_add"
+	auditMemberRemove = "member_remove"
+	auditPurge        = "purge"
+	auditAnonymize    = "anonymize"
+)
+
+// auditEntry describes a single provisioning change. Before and After hold
+// the audited attributes of the resource and are nil when the resource did
+// not exist before or after the change.
+type auditEntry struct {
+	Operation    string
+	ResourceType string
+	ResourceID   string
+	Before       map[string]interface{}
+	After        map[string]interface{}
+}
+
+// auditUser returns the audited attributes of a user.
+func auditUser(e db.Employee) map[string]interface{} {
+	return map[string]interface{}{
+		"externalId": e.OktaID,
+		"userName":   e.Email,
+		"name":       e.Name,
+		"active":     e.Active,
+	}
+}
+
+// auditGroup returns the audited attributes of a group.
+func auditGroup(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"displayName": name,
+	}
+}
+
+// auditMember returns the audited attributes of a group membership.
+func auditMember(memberType, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  memberType,
+		"value": value,
+	}
+}
+
+// auditPersonalAttributes are the audited attributes of users holding
+// personal data. The audit log is append-only, so it records which of them
+// changed but not their values, which purging the user could not erase.
+var auditPersonalAttributes = []string{"userName", "name"}
+
+// auditRedactedValue replaces the values of personal attributes in the diff
+// of audit log entries.
+const auditRedactedValue = "[redacted]"
+
+// redactAuditEntry returns the before, after and diff of entry as recorded in
+// the audit log, without the values of the personal attributes of users.
+func redactAuditEntry(entry auditEntry) (before, after, diff map[string]interface{}) {
+	before, after = entry.Before, entry.After
+	diff = auditDiff(before, after)
+	if entry.ResourceType != "User" {
+		return before, after, diff
+	}
+
+	redact := func(attributes map[string]interface{}) map[string]interface{} {
+		if attributes == nil {
+			return nil
+		}
+		redacted := maps.Clone(attributes)
+		for _, name := range auditPersonalAttributes {
+			delete(redacted, name)
+		}
+		return redacted
+	}
+	for _, name := range auditPersonalAttributes {
+		change, ok := diff[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for side, value := range change {
+			if value != nil {
+				change[side] = auditRedactedValue
+			}
+		}
+	}
+	return redact(before), redact(after), diff
+}
+
+// auditDiff returns the attributes that changed between before and after.
+func auditDiff(before, after map[string]interface{}) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for key, value := range after {
+		if previous, ok := before[key]; !ok || !reflect.DeepEqual(previous, value) {
+			diff[key] = map[string]interface{}{"before": before[key], "after": value}
+		}
+	}
+	for key, previous := range before {
+		if _, ok := after[key]; !ok {
+			diff[key] = map[string]interface{}{"before": previous, "after": nil}
+		}
+	}
+	return diff
+}
+
+func nullJSON(v map[string]interface{}) (pqtype.NullRawMessage, error) {
+	if v == nil {
+		return pqtype.NullRawMessage{}, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return pqtype.NullRawMessage{}, err
+	}
+	return pqtype.NullRawMessage{RawMessage: raw, Valid: true}, nil
+}
+
+// recordAudit appends entry to the audit log of the tenant in ctx through q,
+// attributing it to the credential and request in ctx, and writes the event of
+// the change to the outbox. The personal attributes of users are left out of
+// the audit log, but not of the event.
+func recordAudit(ctx context.Context, q *db.Queries, entry auditEntry) error {
+	redactedBefore, redactedAfter, redactedDiff := redactAuditEntry(entry)
+	before, err := nullJSON(redactedBefore)
+	if err != nil {
+		return err
+	}
+	after, err := nullJSON(redactedAfter)
+	if err != nil {
+		return err
+	}
+	diff, err := nullJSON(redactedDiff)
+	if err != nil {
+		return err
+	}
+
+	if err := q.CreateAuditLogEntry(ctx, db.CreateAuditLogEntryParams{
+		TenantID:     tenantIDFromContext(ctx),
+		Actor:        credentialFromContext(ctx),
+		Operation:    entry.Operation,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		RequestID:    requestIDFromContext(ctx),
+		Before:       before,
+		After:        after,
+		Diff:         diff,
+	}); err != nil {
+		return err
+	}
+	return writeOutboxEvent(ctx, q, entry)
+}
+
+// AuditLogEntry is the representation of an audit log entry returned by the
+// admin API.
+type AuditLogEntry struct {
+	ID           int64           `json:"id"`
+	OccurredAt   time.Time       `json:"occurredAt"`
+	Actor        string          `json:"actor"`
+	Operation    string          `json:"operation"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   string          `json:"resourceId"`
+	RequestID    string          `json:"requestId"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	Diff         json.RawMessage `json:"diff,omitempty"`
+}
+
+// ListAuditLog serves the read-only audit log of the tenant. Entries can be
+// filtered with the resourceType, resourceId, actor, since and until query
+// parameters, where since and until are RFC 3339 timestamps.
+func (h *handler) ListAuditLog() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query := r.URL.Query()
+		startIndex, count := parsePagination(r)
+
+		params := db.ListAuditLogEntriesParams{
+			TenantID:     tenantIDFromContext(r.Context()),
+			Limit:        int32(count),
+			Offset:       int32(startIndex - 1),
+			ResourceType: nullString(query.Get("resourceType")),
+			ResourceID:   nullString(query.Get("resourceId")),
+			Actor:        nullString(query.Get("actor")),
+		}
+
+		for name, target := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+			value := query.Get(name)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" timestamp", http.StatusBadRequest)
+				return
+			}
+			*target = sql.NullTime{Time: t, Valid: true}
+		}
+
+		rows, err := h.db.ListAuditLogEntries(r.Context(), params)
+		if err != nil {
+			http.Error(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]AuditLogEntry, len(rows))
+		for i, row := range rows {
+			entries[i] = AuditLogEntry{
+				ID:           row.ID,
+				OccurredAt:   row.OccurredAt,
+				Actor:        row.Actor,
+				Operation:    row.Operation,
+				ResourceType: row.ResourceType,
+				ResourceID:   row.ResourceID,
+				RequestID:    row.RequestID,
+				Before:       row.Before.RawMessage,
+				After:        row.After.RawMessage,
+				Diff:         row.Diff.RawMessage,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			StartIndex   int             `json:"startIndex"`
+			ItemsPerPage int             `json:"itemsPerPage"`
+			Entries      []AuditLogEntry `json:"entries"`
+		}{
+			StartIndex:   startIndex,
+			ItemsPerPage: len(entries),
+			Entries:      entries,
+		})
+	})
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"okta-scim/db"
+)
+
+// newRootCommand returns the okta-scim command. Without a subcommand it
+// serves SCIM, like the serve subcommand.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:          "okta-scim",
+		Short:        "Okta SCIM v2.0 service and administration commands",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         serveCommand().RunE,
+	}
+	addConfigFlags(root.PersistentFlags())
+	root.PersistentFlags().String("tenant", defaultTenantName, "tenant whose users, groups and credentials are managed")
+
+	root.AddCommand(
+		serveCommand(),
+		migrateCommand(),
+		usersCommand(),
+		groupsCommand(),
+		importCommand(),
+		reconcileCommand(),
+		exportCommand(),
+		credentialsCommand(),
+		webhooksCommand(),
+		outboxCommand(),
+		scimTargetsCommand(),
+		ldapTargetsCommand(),
+		configCommand(),
+	)
+	return root
+}
+
+// commandConfig loads and validates the configuration from the flags of cmd,
+// the environment and the configuration file.
+func commandConfig(cmd *cobra.Command) (*config, error) {
+	cfg, err := loadConfig(cmd.Flags())
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// cliStore is the configuration and database shared by the administration
+// commands, set up the same way as for the server.
+type cliStore struct {
+	cfg     *config
+	dbConn  *sql.DB
+	queries *db.Queries
+	logger  *slog.Logger
+	tenant  db.Tenant
+	mapping *attributeMapping
+}
+
+func openStore(cmd *cobra.Command) (*cliStore, error) {
+	cfg, err := commandConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := newAttributeMapping(cfg.AttributeMapping)
+	if err != nil {
+		return nil, err
+	}
+	dbConn, err := openDatabase(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	return &cliStore{
+		cfg:     cfg,
+		dbConn:  dbConn,
+		queries: db.New(instrumentDB(dbConn)),
+		logger:  slog.New(slog.NewTextHandler(cmd.ErrOrStderr(), nil)),
+		mapping: mapping,
+	}, nil
+}
+
+// openTenantStore opens the store like openStore and looks up the tenant
+// selected with --tenant, which exists once the database is migrated.
+func openTenantStore(cmd *cobra.Command) (*cliStore, error) {
+	store, err := openStore(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := cmd.Flags().GetString("tenant")
+	store.tenant, err = store.queries.GetTenantByName(cmd.Context(), name)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("tenant %s not found", name)
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *cliStore) Close() error {
+	return s.dbConn.Close()
+}
+
+// withTx runs fn with queries bound to a transaction and commits it, so that
+// the changes made by a command are written with their audit log entries and
+// change events, or not at all.
+func (s *cliStore) withTx(ctx context.Context, fn func(q *db.Queries) error) error {
+	tx, err := s.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(db.New(instrumentDB(tx))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// context returns ctx scoped to the tenant of the store.
+func (s *cliStore) context(ctx context.Context) context.Context {
+	return withTenant(ctx, s.tenant, "")
+}
+
+// cliContext returns a context attributing the changes made by a command in
+// the audit log to the operating system user running it.
+func cliContext(ctx context.Context) context.Context {
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	ctx = context.WithValue(ctx, credentialKey, actor)
+	return context.WithValue(ctx, requestIDKey, uuid.New().String())
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// newTable returns a writer aligning tab separated columns.
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+func serveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the SCIM API",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := commandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return runServe(cfg)
+		},
+	}
+}
+
+func migrateCommand() *cobra.Command {
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+	}
+
+	withMigrator := func(run func(cmd *cobra.Command, m *migrator, args []string) error) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			m, err := newMigrator(store.dbConn)
+			if err != nil {
+				return err
+			}
+			return run(cmd, m, args)
+		}
+	}
+
+	printMigrations := func(w io.Writer, verb string, migrations []migration) {
+		for _, migration := range migrations {
+			fmt.Fprintf(w, "%s %03d_%s\n", verb, migration.Version, migration.Name)
+		}
+	}
+
+	var target int
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Apply the pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: withMigrator(func(cmd *cobra.Command, m *migrator, _ []string) error {
+			applied, err := m.Up(cmd.Context(), target)
+			printMigrations(cmd.OutOrStdout(), "Applied", applied)
+			return err
+		}),
+	}
+	up.Flags().IntVar(&target, "target", 0, "last migration to apply, all of them when 0")
+
+	var steps int
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Revert the most recent migrations",
+		Args:  cobra.NoArgs,
+		RunE: withMigrator(func(cmd *cobra.Command, m *migrator, _ []string) error {
+			reverted, err := m.Down(cmd.Context(), steps)
+			printMigrations(cmd.OutOrStdout(), "Reverted", reverted)
+			return err
+		}),
+	}
+	down.Flags().IntVar(&steps, "steps", 1, "number of migrations to revert")
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "List the migrations and whether they are applied",
+		Args:  cobra.NoArgs,
+		RunE: withMigrator(func(cmd *cobra.Command, m *migrator, _ []string) error {
+			status, err := m.Status(cmd.Context())
+			if err != nil {
+				return err
+			}
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED")
+			for _, s := range status {
+				fmt.Fprintf(table, "%03d\t%s\t%t\n", s.Version, s.Name, s.Applied)
+			}
+			return table.Flush()
+		}),
+	}
+
+	baseline := &cobra.Command{
+		Use:   "baseline VERSION",
+		Short: "Record the migrations up to VERSION as applied, for databases migrated before versions were tracked",
+		Args:  cobra.ExactArgs(1),
+		RunE: withMigrator(func(cmd *cobra.Command, m *migrator, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil || version < 1 {
+				return fmt.Errorf("invalid version %q", args[0])
+			}
+			return m.Baseline(cmd.Context(), version)
+		}),
+	}
+
+	migrate.AddCommand(up, down, status, baseline)
+	return migrate
+}
+
+func configCommand() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	validate := &cobra.Command{
+		Use:   "validate",
+		Short: "Report every invalid setting",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if _, err := commandConfig(cmd); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+			return nil
+		},
+	}
+
+	var showSecrets, redact bool
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration as YAML",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if !showSecrets {
+				c = c.redacted()
+			}
+
+			out, err := yaml.Marshal(c)
+			if err != nil {
+				return err
+			}
+			cmd.OutOrStdout().Write(out)
+
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("invalid configuration:\n%w", err)
+			}
+			return nil
+		},
+	}
+	printCmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "print passwords and tokens instead of replacing them")
+	printCmd.Flags().BoolVar(&redact, "redacted", true, "replace passwords and tokens")
+	printCmd.Flags().MarkDeprecated("redacted", "passwords and tokens are replaced unless --show-secrets is set")
+
+	configCmd.AddCommand(validate, printCmd)
+	return configCmd
+}
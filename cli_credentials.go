@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+func credentialsCommand() *cobra.Command {
+	credentials := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the basic authentication credentials of SCIM clients of the tenant",
+		Long: "Manage the basic authentication credentials of SCIM clients of the tenant. " +
+			"Secrets are printed once and only their hash is stored.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the active credentials and their scopes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			stored, err := store.queries.ListActiveCredentials(cmd.Context(), store.tenant.ID)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "NAME\tSCOPES\tCREATED")
+			for _, c := range stored {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", c.Name, strings.Join(c.Scopes, ","), c.CreatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	var scopes []string
+	create := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a credential and print its secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parsed, err := parseScopes(scopes)
+			if err != nil {
+				return err
+			}
+
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			secret, err := generateSecret()
+			if err != nil {
+				return err
+			}
+			if _, err := store.queries.CreateCredential(cmd.Context(), db.CreateCredentialParams{
+				TenantID:   store.tenant.ID,
+				Name:       args[0],
+				SecretHash: hashSecret(secret),
+				Scopes:     parsed,
+			}); err != nil {
+				return fmt.Errorf("failed to create credential %s: %w", args[0], err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), secret)
+			return nil
+		},
+	}
+
+	create.Flags().StringSliceVar(&scopes, "scopes", defaultCredentialScopes, "scopes granted to the credential: "+strings.Join(allScopes, ", "))
+
+	setScopes := &cobra.Command{
+		Use:   "set-scopes NAME SCOPE...",
+		Short: "Replace the scopes of a credential",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parsed, err := parseScopes(args[1:])
+			if err != nil {
+				return err
+			}
+
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			updated, err := store.queries.SetCredentialScopes(cmd.Context(), db.SetCredentialScopesParams{TenantID: store.tenant.ID, Name: args[0], Scopes: parsed})
+			if err != nil {
+				return err
+			}
+			if updated == 0 {
+				return fmt.Errorf("credential %s not found", args[0])
+			}
+			return nil
+		},
+	}
+
+	rotate := &cobra.Command{
+		Use:   "rotate NAME",
+		Short: "Replace the secret of a credential and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			secret, err := generateSecret()
+			if err != nil {
+				return err
+			}
+			rotated, err := store.queries.RotateCredential(cmd.Context(), db.RotateCredentialParams{TenantID: store.tenant.ID, Name: args[0], SecretHash: hashSecret(secret)})
+			if err != nil {
+				return err
+			}
+			if rotated == 0 {
+				return fmt.Errorf("credential %s not found", args[0])
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), secret)
+			return nil
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke NAME",
+		Short: "Revoke a credential",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			revoked, err := store.queries.RevokeCredential(cmd.Context(), db.RevokeCredentialParams{TenantID: store.tenant.ID, Name: args[0]})
+			if err != nil {
+				return err
+			}
+			if revoked == 0 {
+				return fmt.Errorf("credential %s not found", args[0])
+			}
+			return nil
+		},
+	}
+
+	credentials.AddCommand(list, create, setScopes, rotate, revoke)
+	return credentials
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+func ldapTargetsCommand() *cobra.Command {
+	targets := &cobra.Command{
+		Use:   "ldap-targets",
+		Short: "Inspect and resynchronize the LDAP directories users and groups are mirrored to",
+		Long: "Inspect and resynchronize the LDAP directories users and groups are mirrored to. " +
+			"Targets are configured in ldapTargets and receive the changes of their tenant through an ldap:<target> outbox sink.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the configured LDAP targets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := commandConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "NAME\tURL\tBASE DN\tTENANT")
+			for _, t := range cfg.LDAPTargets {
+				t = t.withDefaults()
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", t.Name, t.URL, t.BaseDN, t.Tenant)
+			}
+			return table.Flush()
+		},
+	}
+
+	sync := &cobra.Command{
+		Use:   "sync NAME",
+		Short: "Mirror every user and group of the tenant of a target to it",
+		Long: "Mirror every user and group of the tenant of a target to it, adding the missing entries, " +
+			"replacing the attributes of the others and deleting the entries of users and groups that no longer exist " +
+			"or were deactivated.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			config, err := findLDAPTarget(store.cfg.LDAPTargets, args[0])
+			if err != nil {
+				return err
+			}
+			target, err := newLDAPTarget(config, store.mapping, store.logger)
+			if err != nil {
+				return err
+			}
+			users, groups, deleted, err := target.Resync(cmd.Context(), store.queries)
+			fmt.Fprintf(cmd.OutOrStdout(), "Mirrored %d users and %d groups to %s, deleted %d entries\n", users, groups, config.Name, deleted)
+			return err
+		},
+	}
+
+	entries := &cobra.Command{
+		Use:   "entries NAME",
+		Short: "List the DNs of the entries of the users and groups of the tenant of a target",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			config, err := findLDAPTarget(store.cfg.LDAPTargets, args[0])
+			if err != nil {
+				return err
+			}
+			config = config.withDefaults()
+			tenant, err := store.queries.GetTenantByName(cmd.Context(), config.Tenant)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", config.Tenant, err)
+			}
+			rows, err := store.queries.ListLdapTargetEntries(cmd.Context(), db.ListLdapTargetEntriesParams{
+				Target:   config.Name,
+				TenantID: tenant.ID,
+			})
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "TYPE\tID\tDN\tUPDATED")
+			for _, e := range rows {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", e.ResourceType, e.LocalID, e.Dn, e.UpdatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	targets.AddCommand(list, sync, entries)
+	return targets
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+// runOktaSync reads the Okta org and applies it to the tenant selected with
+// --tenant with opts in a single transaction, printing every change.
+func runOktaSync(cmd *cobra.Command, opts syncOptions) error {
+	store, err := openTenantStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	client, err := newLazyOktaClient(store.cfg.Okta, store.logger).Client()
+	if err != nil {
+		return err
+	}
+	snapshot, err := client.Snapshot(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var changes []syncChange
+	ctx := cliContext(store.context(cmd.Context()))
+	if err := store.withTx(ctx, func(q *db.Queries) error {
+		changes, err = syncFromOkta(ctx, q, snapshot, opts)
+		return err
+	}); err != nil {
+		return fmt.Errorf("no changes were applied: %w", err)
+	}
+
+	table := newTable(cmd.OutOrStdout())
+	for _, change := range changes {
+		fmt.Fprintln(table, change)
+	}
+	table.Flush()
+
+	verb := "Applied"
+	if opts.DryRun {
+		verb = "Planned"
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s %d changes\n", verb, len(changes))
+	return nil
+}
+
+func importCommand() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import users and groups",
+	}
+
+	var dryRun bool
+	okta := &cobra.Command{
+		Use:   "okta",
+		Short: "Create and update the users, groups and memberships of the Okta org",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runOktaSync(cmd, syncOptions{DryRun: dryRun})
+		},
+	}
+	okta.Flags().BoolVar(&dryRun, "dry-run", false, "print the changes without making them")
+
+	importCmd.AddCommand(okta)
+	return importCmd
+}
+
+func reconcileCommand() *cobra.Command {
+	var apply bool
+	reconcile := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Compare the store with Okta, deactivating users and removing memberships Okta does not have",
+		Long: "Compare the users, groups and memberships in the store with the Okta org. " +
+			"The differences are printed, and made only with --apply.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runOktaSync(cmd, syncOptions{DryRun: !apply, Prune: true})
+		},
+	}
+	reconcile.Flags().BoolVar(&apply, "apply", false, "make the changes")
+	return reconcile
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+func outboxCommand() *cobra.Command {
+	outbox := &cobra.Command{
+		Use:   "outbox",
+		Short: "Inspect and rewind the positions of the sinks in the outbox of change events",
+	}
+
+	consumers := &cobra.Command{
+		Use:   "consumers",
+		Short: "List the sinks with the last event they published and the number of events pending",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			rows, err := store.queries.ListOutboxConsumers(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "NAME\tLAST EVENT\tPENDING\tUPDATED")
+			for _, c := range rows {
+				fmt.Fprintf(table, "%s\t%d\t%d\t%s\n", c.Name, c.LastID, c.Pending, c.UpdatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	var fromID int64
+	rewind := &cobra.Command{
+		Use:   "rewind NAME",
+		Short: "Publish events to a sink again",
+		Long: "Publish events to a sink again, starting with the event with the id given with --from-id, " +
+			"or with the oldest event kept in the outbox. Events are kept for outbox.retention once every sink published them.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			// Events follow the position of the sink in the order of their
+			// transaction and id, so the position preceding an event is just
+			// before its id in its transaction
+			position := db.SetOutboxConsumerPositionParams{Name: args[0]}
+			if fromID > 0 {
+				event, err := store.queries.GetOutboxEvent(cmd.Context(), fromID)
+				if err == sql.ErrNoRows {
+					return fmt.Errorf("event %d not found in the outbox", fromID)
+				}
+				if err != nil {
+					return err
+				}
+				position.LastTxid, position.LastID = event.Txid, event.ID-1
+			}
+
+			return store.withTx(cmd.Context(), func(q *db.Queries) error {
+				updated, err := q.SetOutboxConsumerPosition(cmd.Context(), position)
+				if err != nil {
+					return err
+				}
+				if updated == 0 {
+					return fmt.Errorf("sink %s not found", args[0])
+				}
+				return q.ResetOutboxDelivered(cmd.Context(), db.ResetOutboxDeliveredParams{
+					AfterTxid: position.LastTxid,
+					AfterID:   position.LastID,
+				})
+			})
+		},
+	}
+	rewind.Flags().Int64Var(&fromID, "from-id", 0, "id of the first event to publish again")
+
+	outbox.AddCommand(consumers, rewind)
+	return outbox
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+func usersCommand() *cobra.Command {
+	users := &cobra.Command{
+		Use:   "users",
+		Short: "Inspect and manage users",
+	}
+
+	var includeInactive bool
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			employees, err := listAllUsers(cmd.Context(), store.queries, store.tenant.ID)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "ID\tUSERNAME\tNAME\tACTIVE\tLAST MODIFIED")
+			for _, e := range employees {
+				if !e.Active && !includeInactive {
+					continue
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\t%t\t%s\n", e.OktaID, e.Email, e.Name, e.Active, e.UpdatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+	list.Flags().BoolVar(&includeInactive, "all", false, "include deactivated users")
+
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Print a user as a SCIM resource",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			employee, err := store.queries.GetUserByOktaID(cmd.Context(), db.GetUserByOktaIDParams{TenantID: store.tenant.ID, OktaID: args[0]})
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			users, err := usersWithGroups(cmd.Context(), store.queries, store.tenant.ID, employee)
+			if err != nil {
+				return err
+			}
+			scimUser, err := store.mapping.convertToSCIMUser(users[0])
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), scimUser)
+		},
+	}
+
+	deactivate := &cobra.Command{
+		Use:   "deactivate ID",
+		Short: "Deactivate a user, as Okta does when it is unassigned",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx := cliContext(store.context(cmd.Context()))
+			return store.withTx(ctx, func(q *db.Queries) error {
+				user, err := q.GetUserByOktaIDForUpdate(ctx, db.GetUserByOktaIDForUpdateParams{TenantID: store.tenant.ID, OktaID: args[0]})
+				if err == sql.ErrNoRows {
+					return fmt.Errorf("user %s not found", args[0])
+				}
+				if err != nil {
+					return err
+				}
+				deactivated, err := q.DeactivateUser(ctx, db.DeactivateUserParams{TenantID: store.tenant.ID, OktaID: args[0]})
+				if err != nil {
+					return err
+				}
+				return recordAudit(ctx, q, auditEntry{
+					Operation:    auditDeactivate,
+					ResourceType: "User",
+					ResourceID:   args[0],
+					Before:       auditUser(user),
+					After:        auditUser(deactivated),
+				})
+			})
+		},
+	}
+
+	var olderThan time.Duration
+	var anonymize bool
+	purge := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete or anonymize users deactivated for longer than the retention period",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			policy := store.cfg.Users
+			if cmd.Flags().Changed("older-than") {
+				policy.Retention = olderThan
+			}
+			if cmd.Flags().Changed("anonymize") {
+				policy.Anonymize = anonymize
+			}
+			if policy.Retention <= 0 {
+				return errors.New("no retention period: set users.retention or --older-than")
+			}
+
+			purged, err := newUserPurger(policy, store.queries, store.dbConn, store.logger).PurgeTenant(cliContext(cmd.Context()), store.tenant)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Purged %d users\n", purged)
+			return nil
+		},
+	}
+	purge.Flags().DurationVar(&olderThan, "older-than", 0, "retention period, overrides users.retention")
+	purge.Flags().BoolVar(&anonymize, "anonymize", false, "anonymize instead of deleting, overrides users.anonymize")
+
+	users.AddCommand(list, get, deactivate, purge)
+	return users
+}
+
+func groupsCommand() *cobra.Command {
+	groups := &cobra.Command{
+		Use:   "groups",
+		Short: "Inspect groups",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List groups",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			stored, err := listAllGroups(cmd.Context(), store.queries, store.tenant.ID)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "ID\tNAME\tLAST MODIFIED")
+			for _, g := range stored {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", g.OktaID.String, g.Name, g.UpdatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Print a group as a SCIM resource",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			row, err := store.queries.GetGroupByID(cmd.Context(), db.GetGroupByIDParams{
+				TenantID: store.tenant.ID,
+				OktaID:   sql.NullString{String: args[0], Valid: true},
+			})
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("group %s not found", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			group, err := groupFromRow(row.GroupName, row.GroupOktaID, row.CreatedAt, row.UpdatedAt, row.Members, row.MemberGroups)
+			if err != nil {
+				return err
+			}
+			scimGroup, err := store.mapping.convertToSCIMGroup(group)
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), scimGroup)
+		},
+	}
+
+	members := &cobra.Command{
+		Use:   "members ID",
+		Short: "List the direct members of a group",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			group, err := store.queries.GetGroupByOktaID(cmd.Context(), db.GetGroupByOktaIDParams{
+				TenantID: store.tenant.ID,
+				OktaID:   sql.NullString{String: args[0], Valid: true},
+			})
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("group %s not found", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			users, err := store.queries.GetGroupMembers(cmd.Context(), db.GetGroupMembersParams{TenantID: store.tenant.ID, OktaGroupID: group.ID})
+			if err != nil {
+				return err
+			}
+			memberGroups, err := store.queries.GetGroupMemberGroups(cmd.Context(), db.GetGroupMemberGroupsParams{TenantID: store.tenant.ID, GroupID: group.ID})
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "TYPE\tID\tNAME")
+			for _, u := range users {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", memberTypeUser, u.OktaID, u.Email)
+			}
+			for _, g := range memberGroups {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", memberTypeGroup, g.OktaID.String, g.Name)
+			}
+			return table.Flush()
+		},
+	}
+
+	groups.AddCommand(list, get, members)
+	return groups
+}
+
+func exportCommand() *cobra.Command {
+	var output string
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Export every user and group of the tenant as SCIM resources, one JSON object per line",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			out := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			w := bufio.NewWriter(out)
+			encoder := json.NewEncoder(w)
+
+			employees, err := listAllUsers(cmd.Context(), store.queries, store.tenant.ID)
+			if err != nil {
+				return err
+			}
+			users, err := usersWithGroups(cmd.Context(), store.queries, store.tenant.ID, employees...)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				scimUser, err := store.mapping.convertToSCIMUser(user)
+				if err != nil {
+					return err
+				}
+				if err := encoder.Encode(scimUser); err != nil {
+					return err
+				}
+			}
+
+			for offset := int32(0); ; offset += maxPageSize {
+				rows, err := store.queries.ListGroups(cmd.Context(), db.ListGroupsParams{TenantID: store.tenant.ID, Limit: maxPageSize, Offset: offset})
+				if err != nil {
+					return err
+				}
+				for _, row := range rows {
+					group, err := groupFromRow(row.GroupName, row.GroupOktaID, row.CreatedAt, row.UpdatedAt, row.Members, row.MemberGroups)
+					if err != nil {
+						return err
+					}
+					scimGroup, err := store.mapping.convertToSCIMGroup(group)
+					if err != nil {
+						return err
+					}
+					if err := encoder.Encode(scimGroup); err != nil {
+						return err
+					}
+				}
+				if len(rows) < maxPageSize {
+					break
+				}
+			}
+			return w.Flush()
+		},
+	}
+	export.Flags().StringVarP(&output, "output", "o", "", "file to write, standard output when empty")
+	return export
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+func scimTargetsCommand() *cobra.Command {
+	targets := &cobra.Command{
+		Use:   "scim-targets",
+		Short: "Inspect and resynchronize the SCIM targets users and groups are provisioned to",
+		Long: "Inspect and resynchronize the SCIM targets users and groups are provisioned to. " +
+			"Targets are configured in scimTargets and receive the changes of their tenant through a scim:<target> outbox sink.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the configured SCIM targets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := commandConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "NAME\tURL\tTENANT")
+			for _, t := range cfg.SCIMTargets {
+				tenant := t.Tenant
+				if tenant == "" {
+					tenant = defaultTenantName
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\n", t.Name, t.URL, tenant)
+			}
+			return table.Flush()
+		},
+	}
+
+	sync := &cobra.Command{
+		Use:   "sync NAME",
+		Short: "Provision every user and group of the tenant of a target to it",
+		Long: "Provision every user and group of the tenant of a target to it, creating those the target is missing " +
+			"and replacing the others with their local state. Resources are matched by their mapped id, " +
+			"or by userName and displayName when they are not mapped yet.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			config, err := findSCIMTarget(store.cfg.SCIMTargets, args[0])
+			if err != nil {
+				return err
+			}
+			target := newSCIMTarget(config, store.mapping, store.logger)
+			users, groups, err := target.Resync(cmd.Context(), store.queries)
+			fmt.Fprintf(cmd.OutOrStdout(), "Provisioned %d users and %d groups to %s\n", users, groups, config.Name)
+			return err
+		},
+	}
+
+	mappings := &cobra.Command{
+		Use:   "mappings NAME",
+		Short: "List the ids of the users and groups of the tenant of a target at the target",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			config, err := findSCIMTarget(store.cfg.SCIMTargets, args[0])
+			if err != nil {
+				return err
+			}
+			target := newSCIMTarget(config, store.mapping, store.logger)
+			tenant, err := store.queries.GetTenantByName(cmd.Context(), target.config.Tenant)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", target.config.Tenant, err)
+			}
+			resources, err := store.queries.ListScimTargetResources(cmd.Context(), db.ListScimTargetResourcesParams{
+				Target:   config.Name,
+				TenantID: tenant.ID,
+			})
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "TYPE\tID\tTARGET ID\tUPDATED")
+			for _, r := range resources {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", r.ResourceType, r.LocalID, r.RemoteID, r.UpdatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	targets.AddCommand(list, sync, mappings)
+	return targets
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"okta-scim/db"
+)
+
+// parseWebhookEvents checks that every event type is known.
+func parseWebhookEvents(events []string) ([]string, error) {
+	for _, event := range events {
+		if !slices.Contains(changeEventTypes, event) {
+			return nil, fmt.Errorf("unknown event type %q, expected one of %s", event, strings.Join(changeEventTypes, ", "))
+		}
+	}
+	return append([]string{}, events...), nil
+}
+
+func webhooksCommand() *cobra.Command {
+	webhooks := &cobra.Command{
+		Use:   "webhooks",
+		Short: "Manage the endpoints notified of provisioning events of the tenant",
+		Long: "Manage the endpoints notified of provisioning events of the tenant. " +
+			"Events are signed with the secret of the endpoint, which is printed when the endpoint is created or its secret rotated.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the webhook endpoints and the events they receive",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			endpoints, err := store.queries.ListWebhookEndpoints(cmd.Context(), store.tenant.ID)
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "NAME\tURL\tEVENTS\tCREATED")
+			for _, e := range endpoints {
+				events := strings.Join(e.Events, ",")
+				if events == "" {
+					events = "all"
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", e.Name, e.Url, events, e.CreatedAt.Format(time.RFC3339))
+			}
+			return table.Flush()
+		},
+	}
+
+	var events []string
+	create := &cobra.Command{
+		Use:   "create NAME URL",
+		Short: "Create a webhook endpoint and print its signing secret",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parsed, err := parseWebhookEvents(events)
+			if err != nil {
+				return err
+			}
+			if u, err := url.Parse(args[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid webhook URL %q", args[1])
+			}
+
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			secret, err := generateSecret()
+			if err != nil {
+				return err
+			}
+			if _, err := store.queries.CreateWebhookEndpoint(cmd.Context(), db.CreateWebhookEndpointParams{
+				TenantID: store.tenant.ID,
+				Name:     args[0],
+				Url:      args[1],
+				Secret:   secret,
+				Events:   parsed,
+			}); err != nil {
+				return fmt.Errorf("failed to create webhook endpoint %s: %w", args[0], err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), secret)
+			if !store.cfg.Outbox.publishesWebhooks() {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Warning: no webhook outbox sink is configured, add webhook to outbox.sinks to deliver events to the endpoint")
+			}
+			return nil
+		},
+	}
+	create.Flags().StringSliceVar(&events, "events", nil, "event types delivered to the endpoint, all of them when empty: "+strings.Join(changeEventTypes, ", "))
+
+	rotate := &cobra.Command{
+		Use:   "rotate-secret NAME",
+		Short: "Replace the signing secret of a webhook endpoint and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			secret, err := generateSecret()
+			if err != nil {
+				return err
+			}
+			rotated, err := store.queries.RotateWebhookEndpointSecret(cmd.Context(), db.RotateWebhookEndpointSecretParams{TenantID: store.tenant.ID, Name: args[0], Secret: secret})
+			if err != nil {
+				return err
+			}
+			if rotated == 0 {
+				return fmt.Errorf("webhook endpoint %s not found", args[0])
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), secret)
+			return nil
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "delete NAME",
+		Short: "Delete a webhook endpoint and its queued deliveries",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			deleted, err := store.queries.DeleteWebhookEndpoint(cmd.Context(), db.DeleteWebhookEndpointParams{TenantID: store.tenant.ID, Name: args[0]})
+			if err != nil {
+				return err
+			}
+			if deleted == 0 {
+				return fmt.Errorf("webhook endpoint %s not found", args[0])
+			}
+			return nil
+		},
+	}
+
+	var endpoint string
+	failed := &cobra.Command{
+		Use:   "failed",
+		Short: "List the dead letters, deliveries that exhausted their attempts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			deliveries, err := store.queries.ListFailedWebhookDeliveries(cmd.Context(), db.ListFailedWebhookDeliveriesParams{
+				TenantID: store.tenant.ID,
+				Endpoint: nullString(endpoint),
+			})
+			if err != nil {
+				return err
+			}
+
+			table := newTable(cmd.OutOrStdout())
+			fmt.Fprintln(table, "ID\tENDPOINT\tEVENT\tEVENT ID\tATTEMPTS\tFAILED\tERROR")
+			for _, d := range deliveries {
+				fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n", d.ID, d.Endpoint, d.EventType, d.EventID, d.Attempts, d.FailedAt.Time.Format(time.RFC3339), d.LastError.String)
+			}
+			return table.Flush()
+		},
+	}
+	failed.Flags().StringVar(&endpoint, "endpoint", "", "only list the dead letters of this endpoint")
+
+	var ids []int64
+	replay := &cobra.Command{
+		Use:   "replay",
+		Short: "Queue dead letters for delivery again",
+		Long: "Queue dead letters for delivery again, with a fresh set of attempts. " +
+			"Every dead letter of the tenant is replayed unless --endpoint or --id select some of them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openTenantStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			replayed, err := store.queries.ReplayWebhookDeliveries(cmd.Context(), db.ReplayWebhookDeliveriesParams{
+				TenantID: store.tenant.ID,
+				Endpoint: nullString(endpoint),
+				Ids:      append([]int64{}, ids...),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Replayed %d deliveries\n", replayed)
+			return nil
+		},
+	}
+	replay.Flags().StringVar(&endpoint, "endpoint", "", "only replay the dead letters of this endpoint")
+	replay.Flags().Int64SliceVar(&ids, "id", nil, "ids of the dead letters to replay, as listed by webhooks failed")
+
+	webhooks.AddCommand(list, create, rotate, remove, failed, replay)
+	return webhooks
+}
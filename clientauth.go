@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Client certificate attributes that can be mapped to a credential
+const (
+	certMatchCN    = "cn"
+	certMatchDNS   = "dns"
+	certMatchURI   = "uri"
+	certMatchEmail = "email"
+)
+
+// certMapping maps client certificates with a given subject common name or
+// subject alternative name to a named credential.
+type certMapping struct {
+	Match      string
+	Value      string
+	Credential string
+}
+
+// certCredentials resolves verified client certificates to credentials.
+type certCredentials struct {
+	mappings []certMapping
+}
+
+// parseCertCredentials parses <match>:<value>=<credential> rules where match
+// is one of cn, dns, uri or email, e.g.
+//
+//	cn:okta-connector=okta
+//	uri:spiffe://corp/reporting=reporting
+//
+// It returns nil when there are no rules.
+func parseCertCredentials(rules []string) (*certCredentials, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var credentials certCredentials
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		// The credential follows the last '=', URIs may contain '=' themselves
+		var match, attribute, credential string
+		if i := strings.LastIndex(rule, "="); i >= 0 {
+			match, attribute, _ = strings.Cut(rule[:i], ":")
+			credential = rule[i+1:]
+		}
+		if attribute == "" || credential == "" {
+			return nil, fmt.Errorf("invalid client certificate credential rule %q: must be <match>:<value>=<credential>", rule)
+		}
+
+		switch match = strings.ToLower(match); match {
+		case certMatchCN, certMatchDNS, certMatchURI, certMatchEmail:
+		default:
+			return nil, fmt.Errorf("invalid client certificate credential rule %q: match must be cn, dns, uri or email", rule)
+		}
+
+		credentials.mappings = append(credentials.mappings, certMapping{
+			Match:      match,
+			Value:      attribute,
+			Credential: credential,
+		})
+	}
+	return &credentials, nil
+}
+
+// credential returns the credential the first matching rule maps cert to.
+func (c *certCredentials) credential(cert *x509.Certificate) (string, bool) {
+	for _, mapping := range c.mappings {
+		if mapping.matches(cert) {
+			return mapping.Credential, true
+		}
+	}
+	return "", false
+}
+
+func (m certMapping) matches(cert *x509.Certificate) bool {
+	switch m.Match {
+	case certMatchCN:
+		return cert.Subject.CommonName == m.Value
+	case certMatchDNS:
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, m.Value) {
+				return true
+			}
+		}
+	case certMatchURI:
+		for _, uri := range cert.URIs {
+			if uri.String() == m.Value {
+				return true
+			}
+		}
+	case certMatchEmail:
+		for _, address := range cert.EmailAddresses {
+			if strings.EqualFold(address, m.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
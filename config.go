@@ -0,0 +1,467 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the complete configuration of the service. It is assembled from
+// defaults, an optional YAML file, environment variables and command-line
+// flags, each overriding the previous ones.
+type config struct {
+	Server      serverConfig        `yaml:"server"`
+	Database    databaseConfig      `yaml:"database"`
+	Auth        authConfig          `yaml:"auth"`
+	Okta        oktaConfig          `yaml:"okta"`
+	Users       userLifecyclePolicy `yaml:"users"`
+	AccessLog   accessLogConfig     `yaml:"accessLog"`
+	Limits      limitsConfig        `yaml:"limits"`
+	Idempotency idempotencyConfig   `yaml:"idempotency"`
+	Outbox      outboxConfig        `yaml:"outbox"`
+	Webhooks    webhookConfig       `yaml:"webhooks"`
+	SCIMTargets []scimTargetConfig  `yaml:"scimTargets"`
+	LDAPTargets []ldapTargetConfig  `yaml:"ldapTargets"`
+	Features    featureConfig       `yaml:"features"`
+
+	AttributeMapping attributeMappingConfig `yaml:"attributeMapping"`
+}
+
+// databaseConfig configures the Postgres connection pool.
+type databaseConfig struct {
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+}
+
+// authConfig configures how clients authenticate.
+type authConfig struct {
+	// Username and Password enable basic authentication.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// ClientCertCredentials maps verified client certificates to credentials,
+	// see parseCertCredentials.
+	ClientCertCredentials []string `yaml:"clientCertCredentials"`
+	// CredentialScopes restricts the scopes of the configured and client
+	// certificate credentials, see parseCredentialScopes.
+	CredentialScopes []string `yaml:"credentialScopes"`
+}
+
+// oktaConfig configures the Okta API client. Without a domain and API token
+// the service runs in SCIM-only mode with the Okta features disabled.
+type oktaConfig struct {
+	Domain   string `yaml:"domain"`
+	APIToken string `yaml:"apiToken"`
+	// ReadinessCheck fails readiness while Okta cannot be reached. Results are
+	// reused for ReadinessCacheTTL.
+	ReadinessCheck    bool          `yaml:"readinessCheck"`
+	ReadinessCacheTTL time.Duration `yaml:"readinessCacheTTL"`
+}
+
+// limitsConfig bounds the resources a single request may use, the requests
+// served concurrently and the request rate of each credential.
+type limitsConfig struct {
+	MaxRequestBodyBytes int64 `yaml:"maxRequestBodyBytes"`
+	// MaxInFlight caps the SCIM and admin requests served concurrently, 0
+	// leaves them uncapped.
+	MaxInFlight int       `yaml:"maxInFlight"`
+	Reads       rateLimit `yaml:"reads"`
+	Writes      rateLimit `yaml:"writes"`
+	Bulk        rateLimit `yaml:"bulk"`
+}
+
+// featureConfig turns optional endpoints on and off.
+type featureConfig struct {
+	Metrics  bool `yaml:"metrics"`
+	AuditAPI bool `yaml:"auditAPI"`
+}
+
+// redactedSecret replaces secrets in printed configurations.
+const redactedSecret = "REDACTED"
+
+func defaultConfig() *config {
+	return &config{
+		Server: serverConfig{
+			Addr:              ":8080",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			TLSReloadInterval: time.Minute,
+		},
+		Database: databaseConfig{
+			DSN:             "user=user password=password dbname=scim sslmode=disable",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Okta: oktaConfig{
+			ReadinessCacheTTL: 30 * time.Second,
+		},
+		Users: userLifecyclePolicy{
+			PurgeInterval: time.Hour,
+		},
+		AccessLog: accessLogConfig{
+			Mode:         accessLogMetadata,
+			MaxBodyBytes: defaultMaxLoggedBodyBytes,
+		},
+		Limits: limitsConfig{
+			MaxRequestBodyBytes: defaultMaxRequestBodyBytes,
+		},
+		Idempotency: idempotencyConfig{
+			KeyTTL: 24 * time.Hour,
+		},
+		Outbox: outboxConfig{
+			PollInterval: time.Second,
+			Retention:    7 * 24 * time.Hour,
+			Sinks:        []string{sinkWebhook},
+		},
+		Webhooks: webhookConfig{
+			PollInterval: time.Second,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  15,
+			MinBackoff:   10 * time.Second,
+			MaxBackoff:   time.Hour,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Features: featureConfig{
+			Metrics:  true,
+			AuditAPI: true,
+		},
+	}
+}
+
+// envOverride sets a configuration value from an environment variable.
+type envOverride struct {
+	name  string
+	apply func(value string) error
+}
+
+// envOverrides lists the environment variables that override the file.
+func (c *config) envOverrides() []envOverride {
+	return []envOverride{
+		{"LISTEN_ADDR", stringValue(&c.Server.Addr)},
+		{"HTTP_READ_TIMEOUT", durationValue(&c.Server.ReadTimeout)},
+		{"HTTP_READ_HEADER_TIMEOUT", durationValue(&c.Server.ReadHeaderTimeout)},
+		{"HTTP_WRITE_TIMEOUT", durationValue(&c.Server.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", durationValue(&c.Server.IdleTimeout)},
+		{"SHUTDOWN_DRAIN_DELAY", durationValue(&c.Server.DrainDelay)},
+		{"SHUTDOWN_TIMEOUT", durationValue(&c.Server.ShutdownTimeout)},
+		{"TLS_CERT_FILE", stringValue(&c.Server.TLSCertFile)},
+		{"TLS_KEY_FILE", stringValue(&c.Server.TLSKeyFile)},
+		{"TLS_RELOAD_INTERVAL", durationValue(&c.Server.TLSReloadInterval)},
+		{"TLS_CLIENT_CA_FILE", stringValue(&c.Server.ClientCAFile)},
+		{"TLS_CLIENT_AUTH", choiceValue(&c.Server.ClientCertRequired, "optional", "require")},
+		{"H2C", boolValue(&c.Server.H2C)},
+		{"SCIM_BASE_URL", stringValue(&c.Server.BaseURL)},
+		{"TRUSTED_PROXIES", listValue(&c.Server.TrustedProxies)},
+
+		{"DATABASE_URL", stringValue(&c.Database.DSN)},
+		{"DB_MAX_OPEN_CONNS", intValue(&c.Database.MaxOpenConns)},
+		{"DB_MAX_IDLE_CONNS", intValue(&c.Database.MaxIdleConns)},
+		{"DB_CONN_MAX_LIFETIME", durationValue(&c.Database.ConnMaxLifetime)},
+		{"DB_CONN_MAX_IDLE_TIME", durationValue(&c.Database.ConnMaxIdleTime)},
+
+		{"SCIM_USER", stringValue(&c.Auth.Username)},
+		{"SCIM_PASSWORD", stringValue(&c.Auth.Password)},
+		{"CLIENT_CERT_CREDENTIALS", listValue(&c.Auth.ClientCertCredentials)},
+		{"CREDENTIAL_SCOPES", listValue(&c.Auth.CredentialScopes)},
+
+		{"OKTA_DOMAIN", stringValue(&c.Okta.Domain)},
+		{"OKTA_API_TOKEN", stringValue(&c.Okta.APIToken)},
+		{"READINESS_CHECK_OKTA", boolValue(&c.Okta.ReadinessCheck)},
+		{"READINESS_OKTA_CACHE_TTL", durationValue(&c.Okta.ReadinessCacheTTL)},
+
+		{"USER_DELETE_MODE", choiceValue(&c.Users.HardDelete, "soft", "hard")},
+		{"USER_RETENTION_PERIOD", durationValue(&c.Users.Retention)},
+		{"USER_PURGE_ACTION", choiceValue(&c.Users.Anonymize, "delete", "anonymize")},
+		{"USER_PURGE_INTERVAL", durationValue(&c.Users.PurgeInterval)},
+
+		{"ACCESS_LOG_MODE", stringValue((*string)(&c.AccessLog.Mode))},
+		{"LOG_REDACT_ATTRIBUTES", listValue(&c.AccessLog.RedactAttributes)},
+		{"LOG_MAX_BODY_BYTES", intValue(&c.AccessLog.MaxBodyBytes)},
+
+		{"MAX_REQUEST_BODY_BYTES", int64Value(&c.Limits.MaxRequestBodyBytes)},
+		{"MAX_IN_FLIGHT_REQUESTS", intValue(&c.Limits.MaxInFlight)},
+		{"RATE_LIMIT_READS", floatValue(&c.Limits.Reads.Rate)},
+		{"RATE_LIMIT_READS_BURST", intValue(&c.Limits.Reads.Burst)},
+		{"RATE_LIMIT_WRITES", floatValue(&c.Limits.Writes.Rate)},
+		{"RATE_LIMIT_WRITES_BURST", intValue(&c.Limits.Writes.Burst)},
+		{"RATE_LIMIT_BULK", floatValue(&c.Limits.Bulk.Rate)},
+		{"RATE_LIMIT_BULK_BURST", intValue(&c.Limits.Bulk.Burst)},
+
+		{"IDEMPOTENCY_KEY_TTL", durationValue(&c.Idempotency.KeyTTL)},
+
+		{"OUTBOX_POLL_INTERVAL", durationValue(&c.Outbox.PollInterval)},
+		{"OUTBOX_RETENTION", durationValue(&c.Outbox.Retention)},
+		{"OUTBOX_SINKS", listValue(&c.Outbox.Sinks)},
+
+		{"WEBHOOK_POLL_INTERVAL", durationValue(&c.Webhooks.PollInterval)},
+		{"WEBHOOK_TIMEOUT", durationValue(&c.Webhooks.Timeout)},
+		{"WEBHOOK_MAX_ATTEMPTS", intValue(&c.Webhooks.MaxAttempts)},
+		{"WEBHOOK_MIN_BACKOFF", durationValue(&c.Webhooks.MinBackoff)},
+		{"WEBHOOK_MAX_BACKOFF", durationValue(&c.Webhooks.MaxBackoff)},
+		{"WEBHOOK_RETENTION", durationValue(&c.Webhooks.Retention)},
+
+		{"FEATURE_METRICS", boolValue(&c.Features.Metrics)},
+		{"FEATURE_AUDIT_API", boolValue(&c.Features.AuditAPI)},
+	}
+}
+
+func stringValue(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func listValue(target *[]string) func(string) error {
+	return func(value string) error {
+		*target = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+		return nil
+	}
+}
+
+func intValue(target *int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		*target = n
+		return nil
+	}
+}
+
+func int64Value(target *int64) func(string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		*target = n
+		return nil
+	}
+}
+
+func floatValue(target *float64) func(string) error {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		*target = f
+		return nil
+	}
+}
+
+func boolValue(target *bool) func(string) error {
+	return func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		*target = b
+		return nil
+	}
+}
+
+func durationValue(target *time.Duration) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration such as 30s or 1h")
+		}
+		*target = d
+		return nil
+	}
+}
+
+// choiceValue sets target to false for the first choice and true for the second.
+func choiceValue(target *bool, off, on string) func(string) error {
+	return func(value string) error {
+		switch value {
+		case off:
+			*target = false
+		case on:
+			*target = true
+		default:
+			return fmt.Errorf("must be %s or %s", off, on)
+		}
+		return nil
+	}
+}
+
+// addConfigFlags registers the flags read by loadConfig on fs.
+func addConfigFlags(fs *pflag.FlagSet) {
+	fs.String("config", os.Getenv("SCIM_CONFIG_FILE"), "path to a YAML configuration file")
+	fs.String("listen-addr", "", "address to listen on, overrides server.addr")
+	fs.String("database-url", "", "Postgres connection string, overrides database.dsn")
+	fs.String("access-log-mode", "", "off, metadata or redacted-body, overrides accessLog.mode")
+}
+
+// loadConfig assembles the configuration from the defaults, the file named
+// by --config or SCIM_CONFIG_FILE, the environment and the flags registered
+// on fs by addConfigFlags. The result is not validated.
+func loadConfig(fs *pflag.FlagSet) (*config, error) {
+	c := defaultConfig()
+
+	if configFile, _ := fs.GetString("config"); configFile != "" {
+		f, err := os.Open(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("invalid config file %s: %w", configFile, err)
+		}
+	}
+
+	var errs []error
+	for _, override := range c.envOverrides() {
+		if value, ok := os.LookupEnv(override.name); ok && value != "" {
+			if err := override.apply(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q: %w", override.name, value, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if fs.Changed("listen-addr") {
+		c.Server.Addr, _ = fs.GetString("listen-addr")
+	}
+	if fs.Changed("database-url") {
+		c.Database.DSN, _ = fs.GetString("database-url")
+	}
+	if fs.Changed("access-log-mode") {
+		mode, _ := fs.GetString("access-log-mode")
+		c.AccessLog.Mode = accessLogMode(mode)
+	}
+
+	return c, nil
+}
+
+// validate reports every invalid setting at once.
+func (c *config) validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(c.Server.validate())
+	if _, err := newResourceLocator(c.Server.BaseURL, c.Server.TrustedProxies); err != nil {
+		check(err)
+	}
+
+	if c.Database.DSN == "" {
+		check(errors.New("database.dsn is required"))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		check(errors.New("database.maxOpenConns and database.maxIdleConns must not be negative"))
+	}
+
+	certCredentials, err := parseCertCredentials(c.Auth.ClientCertCredentials)
+	check(err)
+	if (c.Auth.Username == "") != (c.Auth.Password == "") {
+		check(errors.New("auth.username and auth.password must be set together"))
+	}
+	if certCredentials != nil && c.Server.ClientCAFile == "" {
+		check(errors.New("auth.clientCertCredentials requires server.clientCAFile"))
+	}
+	_, err = parseCredentialScopes(c.Auth.CredentialScopes)
+	check(err)
+
+	if (c.Okta.Domain == "") != (c.Okta.APIToken == "") {
+		check(errors.New("okta.domain and okta.apiToken must be set together"))
+	}
+	if c.Okta.ReadinessCheck && c.Okta.Domain == "" {
+		check(errors.New("okta.readinessCheck requires okta.domain and okta.apiToken"))
+	}
+	if c.Okta.ReadinessCacheTTL < 0 {
+		check(errors.New("okta.readinessCacheTTL must not be negative"))
+	}
+
+	check(c.Users.validate())
+	check(c.AccessLog.validate())
+
+	if c.Limits.MaxRequestBodyBytes <= 0 {
+		check(errors.New("limits.maxRequestBodyBytes must be positive"))
+	}
+	check(c.Limits.validateLimits())
+	check(c.Idempotency.validate())
+	check(c.Outbox.validate(c.SCIMTargets, c.LDAPTargets))
+	check(c.Webhooks.validate())
+	check(validateSCIMTargets(c.SCIMTargets))
+	check(validateLDAPTargets(c.LDAPTargets))
+	check(c.AttributeMapping.validate())
+
+	return errors.Join(errs...)
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redacted returns a copy of the configuration with its secrets replaced.
+func (c *config) redacted() *config {
+	r := *c
+	if r.Auth.Password != "" {
+		r.Auth.Password = redactedSecret
+	}
+	if r.Okta.APIToken != "" {
+		r.Okta.APIToken = redactedSecret
+	}
+	r.SCIMTargets = slices.Clone(c.SCIMTargets)
+	for i := range r.SCIMTargets {
+		if r.SCIMTargets[i].Password != "" {
+			r.SCIMTargets[i].Password = redactedSecret
+		}
+		if r.SCIMTargets[i].BearerToken != "" {
+			r.SCIMTargets[i].BearerToken = redactedSecret
+		}
+	}
+	r.LDAPTargets = slices.Clone(c.LDAPTargets)
+	for i := range r.LDAPTargets {
+		if r.LDAPTargets[i].BindPassword != "" {
+			r.LDAPTargets[i].BindPassword = redactedSecret
+		}
+	}
+
+	if u, err := url.Parse(r.Database.DSN); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedSecret)
+		}
+		if query := u.Query(); query.Has("password") {
+			query.Set("password", redactedSecret)
+			u.RawQuery = query.Encode()
+		}
+		r.Database.DSN = u.String()
+	} else {
+		r.Database.DSN = dsnPassword.ReplaceAllString(r.Database.DSN, "${1}"+redactedSecret)
+	}
+	return &r
+}
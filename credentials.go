@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+
+	"okta-scim/db"
+)
+
+// credentialSecretBytes is the entropy of generated credential secrets.
+const credentialSecretBytes = 32
+
+// generateSecret returns a random credential secret.
+func generateSecret() (string, error) {
+	b := make([]byte, credentialSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret hashes a credential secret for storage. Secrets are random and
+// long, so a fast hash is sufficient.
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// storedCredential reports whether name and secret match an active
+// credential of the tenant in ctx, and returns its scopes when they do.
+func (h *handler) storedCredential(ctx context.Context, name, secret string) ([]string, bool, error) {
+	credential, err := h.db.GetActiveCredentialByName(ctx, db.GetActiveCredentialByNameParams{TenantID: tenantIDFromContext(ctx), Name: name})
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if subtle.ConstantTimeCompare(credential.SecretHash, hashSecret(secret)) != 1 {
+		return nil, false, nil
+	}
+	return credential.Scopes, true, nil
+}
@@ -1,19 +1,34 @@
 package main
 
 import (
-	"strings"
+	"context"
+	"database/sql"
+	"encoding/json"
 	"time"
+
+	"okta-scim/db"
 )
 
 type SCIMUser struct {
-	Schemas  []string    `json:"schemas"`
-	ID       string      `json:"id"`
-	UserName string      `json:"userName"`
-	Name     SCIMName    `json:"name"`
-	Active   bool        `json:"active"`
-	Emails   []SCIMEmail `json:"emails"`
-	Groups   []string    `json:"groups"`
-	Meta     SCIMMeta    `json:"meta"`
+	Schemas  []string        `json:"schemas"`
+	ID       string          `json:"id"`
+	UserName string          `json:"userName"`
+	Name     SCIMName        `json:"name"`
+	Active   bool            `json:"active"`
+	Emails   []SCIMEmail     `json:"emails"`
+	Groups   []SCIMUserGroup `json:"groups"`
+	Meta     SCIMMeta        `json:"meta"`
+	// Extensions holds the attributes of schema extensions by extension URN.
+	Extensions map[string]json.RawMessage `json:"-"`
+}
+
+// SCIMUserGroup is a group the user belongs to, either directly or indirectly
+// through nested groups.
+type SCIMUserGroup struct {
+	Value   string `json:"value"`          // The identifier of the group
+	Ref     string `json:"$ref,omitempty"` // The URI of the group
+	Display string `json:"display"`        // The displayName of the group
+	Type    string `json:"type"`           // "direct" or "indirect"
 }
 
 type SCIMName struct {
@@ -29,17 +44,20 @@ type SCIMEmail struct {
 	Display string `json:"display"`
 }
 
+// SCIMMeta contains metadata about a SCIM resource, shared by users and groups.
 type SCIMMeta struct {
-	ResourceType string    `json:"resourceType"`
-	Created      time.Time `json:"created,omitempty"`
-	LastModified time.Time `json:"lastModified,omitempty"`
-	Version      string    `json:"version,omitempty"`
+	ResourceType string     `json:"resourceType"`           // The name of the resource type of the resource
+	Created      *time.Time `json:"created,omitempty"`      // The DateTime the resource was added to the service provider
+	LastModified *time.Time `json:"lastModified,omitempty"` // The most recent DateTime the details of this resource were updated
+	Location     string     `json:"location,omitempty"`     // The URI of the resource being returned
+	Version      string     `json:"version,omitempty"`      // The version of the resource being returned
 }
 
 type SCIMError struct {
-	Schemas []string `json:"schemas"`
-	Detail  string   `json:"detail"`
-	Status  string   `json:"status"`
+	Schemas  []string `json:"schemas"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+	Status   string   `json:"status"`
 }
 
 type SCIMUserCreateRequest struct {
@@ -84,12 +102,9 @@ type SCIMUserUpdate struct {
 }
 
 type SCIMGroupCreateRequest struct {
-	Schemas     []string `json:"schemas"`
-	DisplayName string   `json:"displayName"`
-	Members     []struct {
-		Value   string `json:"value"`
-		Display string `json:"display"`
-	} `json:"members"`
+	Schemas     []string          `json:"schemas"`
+	DisplayName string            `json:"displayName"`
+	Members     []SCIMGroupMember `json:"members"`
 }
 
 // SCIMGroup represents a SCIM Group object following the SCIM 2.0 specification.
@@ -98,24 +113,21 @@ type SCIMGroup struct {
 	ID          string            `json:"id"`          // The unique identifier for the SCIM resource as defined by the Service Provider
 	DisplayName string            `json:"displayName"` // A human-readable name for the Group
 	Members     []SCIMGroupMember `json:"members"`     // A list of members in the Group
-	Meta        SCIMGroupMeta     `json:"meta"`        // A complex attribute containing resource metadata
+	Meta        SCIMMeta          `json:"meta"`        // A complex attribute containing resource metadata
 }
 
 // SCIMGroupMember represents a member of the SCIM Group.
 type SCIMGroupMember struct {
-	Value   string `json:"value"`   // The identifier of the member in this Group
-	Display string `json:"display"` // A human-readable name for the member, primarily used for display purposes
+	Value   string `json:"value"`          // The identifier of the member in this Group
+	Ref     string `json:"$ref,omitempty"` // The URI of the member resource
+	Display string `json:"display"`        // A human-readable name for the member, primarily used for display purposes
+	Type    string `json:"type,omitempty"` // The type of the member, "User" or "Group"
 }
 
-// SCIMGroupMeta contains metadata about the SCIM Group resource.
-type SCIMGroupMeta struct {
-	ResourceType string `json:"resourceType"`           // The name of the resource type of the resource
-	Created      string `json:"created,omitempty"`      // The DateTime the resource was added to the service provider
-	LastModified string `json:"lastModified,omitempty"` // The most recent DateTime the details of this resource were updated
-	Location     string `json:"location,omitempty"`     // The URI of the resource being returned
-	Version      string `json:"version,omitempty"`      // The version of the resource being returned
-	// Additional metadata fields can be included as per requirement
-}
+const (
+	memberTypeUser  = "User"
+	memberTypeGroup = "Group"
+)
 
 type SCIMGroupUpdateRequest struct {
 	Schemas     []string          `json:"schemas"`
@@ -125,53 +137,183 @@ type SCIMGroupUpdateRequest struct {
 }
 
 type User struct {
-	ID     int32
-	Name   string
-	Email  string
+	ID        int32
+	Name      string
+	Email     string
+	OktaID    string
+	Active    bool
+	Groups    []UserGroup
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Extensions holds the stored schema extension attributes by URN.
+	Extensions map[string]json.RawMessage
+}
+
+// UserGroup is a group a user belongs to. Direct is false when the membership
+// is only inherited through nested groups.
+type UserGroup struct {
 	OktaID string
+	Name   string
+	Direct bool
 }
 
 type Group struct {
-	ID      string
-	Name    string
-	OktaID  string
-	Members []User
-}
-
-func convertToSCIMUser(dbUser *User) SCIMUser {
-	names := strings.Fields(dbUser.Name)
-	givenName := names[0]
-	familyName := ""
-	if len(names) > 1 {
-		familyName = strings.Join(names[1:], " ")
+	ID           string
+	Name         string
+	OktaID       string
+	Members      []User
+	MemberGroups []Group
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// usersWithGroups converts employees to users, resolving the direct and
+// indirect groups of each user.
+func usersWithGroups(ctx context.Context, q *db.Queries, tenantID int32, employees ...db.Employee) ([]*User, error) {
+	employeeIDs := make([]int32, len(employees))
+	for i, employee := range employees {
+		employeeIDs[i] = employee.ID
+	}
+
+	rows, err := q.GetUsersGroups(ctx, db.GetUsersGroupsParams{TenantID: tenantID, EmployeeIds: employeeIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	userGroups := make(map[int32][]UserGroup)
+	for _, row := range rows {
+		userGroups[row.EmployeeID] = append(userGroups[row.EmployeeID], UserGroup{
+			OktaID: row.OktaID.String,
+			Name:   row.Name,
+			Direct: row.Direct,
+		})
+	}
+
+	users := make([]*User, len(employees))
+	for i, employee := range employees {
+		users[i] = userFromEmployee(employee)
+		users[i].Groups = userGroups[employee.ID]
+	}
+	return users, nil
+}
+
+func userFromEmployee(e db.Employee) *User {
+	user := &User{
+		ID:        e.ID,
+		Name:      e.Name,
+		Email:     e.Email,
+		OktaID:    e.OktaID,
+		Active:    e.Active,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+	// The column is a JSONB object, so it always decodes
+	json.Unmarshal(e.Extensions, &user.Extensions)
+	return user
+}
+
+// groupFromRow builds a Group from the columns shared by the group queries,
+// decoding the aggregated user and group members.
+func groupFromRow(name string, oktaID sql.NullString, createdAt, updatedAt time.Time, members, memberGroups json.RawMessage) (*Group, error) {
+	group := &Group{
+		Name:      name,
+		OktaID:    oktaID.String,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+	}
+
+	if members != nil {
+		var users []User
+		if err := json.Unmarshal(members, &users); err != nil {
+			return nil, err
+		}
+		// Groups without users aggregate a single all-null row
+		for _, user := range users {
+			if user.OktaID != "" {
+				group.Members = append(group.Members, user)
+			}
+		}
+	}
+
+	if memberGroups != nil {
+		if err := json.Unmarshal(memberGroups, &group.MemberGroups); err != nil {
+			return nil, err
+		}
+	}
+
+	return group, nil
+}
+
+// optionalTime returns nil for the zero time so that unset timestamps are
+// omitted from the SCIM meta attribute.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// convertToSCIMUser converts a user to its SCIM representation, with the
+// userName, name and email attributes mapped from the stored columns.
+func (m *attributeMapping) convertToSCIMUser(dbUser *User) (SCIMUser, error) {
+	attributes, err := m.userSCIM(dbUser)
+	if err != nil {
+		return SCIMUser{}, err
 	}
 
 	return SCIMUser{
 		Schemas:  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
 		ID:       dbUser.OktaID,
-		UserName: dbUser.Email,
+		UserName: attributes["userName"],
 		Name: SCIMName{
-			GivenName:  givenName,
-			MiddleName: "",
-			FamilyName: familyName,
+			GivenName:  attributes["name.givenName"],
+			MiddleName: attributes["name.middleName"],
+			FamilyName: attributes["name.familyName"],
 		},
-		Active: true,
+		Active: dbUser.Active,
 		Emails: []SCIMEmail{
 			{
 				Primary: true,
-				Value:   dbUser.Email,
+				Value:   attributes["emails.value"],
 				Type:    "work",
-				Display: dbUser.Email,
+				Display: attributes["emails.value"],
 			},
 		},
-		Groups: []string{},
+		Groups:     convertToSCIMUserGroups(dbUser.Groups),
+		Extensions: dbUser.Extensions,
 		Meta: SCIMMeta{
 			ResourceType: "User",
+			Created:      optionalTime(dbUser.CreatedAt),
+			LastModified: optionalTime(dbUser.UpdatedAt),
 		},
+	}, nil
+}
+
+func convertToSCIMUserGroups(groups []UserGroup) []SCIMUserGroup {
+	scimGroups := make([]SCIMUserGroup, 0, len(groups))
+	for _, group := range groups {
+		membershipType := "indirect"
+		if group.Direct {
+			membershipType = "direct"
+		}
+		scimGroups = append(scimGroups, SCIMUserGroup{
+			Value:   group.OktaID,
+			Display: group.Name,
+			Type:    membershipType,
+		})
 	}
+	return scimGroups
 }
 
-func convertToSCIMGroup(group *Group) SCIMGroup {
+// convertToSCIMGroup converts a group to its SCIM representation, with the
+// displayName attribute mapped from the stored name.
+func (m *attributeMapping) convertToSCIMGroup(group *Group) (SCIMGroup, error) {
+	attributes, err := m.groupSCIM(group.OktaID, group.Name)
+	if err != nil {
+		return SCIMGroup{}, err
+	}
+
 	// Initialize an empty slice for SCIM members
 	var members []SCIMGroupMember
 
@@ -179,20 +321,31 @@ func convertToSCIMGroup(group *Group) SCIMGroup {
 		scimMember := SCIMGroupMember{
 			Value:   member.OktaID,
 			Display: member.Email,
+			Type:    memberTypeUser,
 		}
 		members = append(members, scimMember)
 	}
 
+	for _, memberGroup := range group.MemberGroups {
+		members = append(members, SCIMGroupMember{
+			Value:   memberGroup.OktaID,
+			Display: memberGroup.Name,
+			Type:    memberTypeGroup,
+		})
+	}
+
 	// Construct the SCIM group
 	scimGroup := SCIMGroup{
 		Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:Group"},
 		ID:          group.OktaID,
-		DisplayName: group.Name,
+		DisplayName: attributes["displayName"],
 		Members:     members,
-		Meta: SCIMGroupMeta{
+		Meta: SCIMMeta{
 			ResourceType: "Group",
+			Created:      optionalTime(group.CreatedAt),
+			LastModified: optionalTime(group.UpdatedAt),
 		},
 	}
 
-	return scimGroup
+	return scimGroup, nil
 }
@@ -0,0 +1,121 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: audit.sql
+
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/sqlc-dev/pqtype"
+)
+
+const createAuditLogEntry = `-- name: CreateAuditLogEntry :exec
+INSERT INTO AuditLog (tenant_id,
+                      actor,
+                      operation,
+                      resource_type,
+                      resource_id,
+                      request_id,
+                      before,
+                      after,
+                      diff)
+VALUES ($9, $1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type CreateAuditLogEntryParams struct {
+	Actor        string                `json:"actor"`
+	Operation    string                `json:"operation"`
+	ResourceType string                `json:"resource_type"`
+	ResourceID   string                `json:"resource_id"`
+	RequestID    string                `json:"request_id"`
+	Before       pqtype.NullRawMessage `json:"before"`
+	After        pqtype.NullRawMessage `json:"after"`
+	Diff         pqtype.NullRawMessage `json:"diff"`
+	TenantID     int32                 `json:"tenant_id"`
+}
+
+func (q *Queries) CreateAuditLogEntry(ctx context.Context, arg CreateAuditLogEntryParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditLogEntry,
+		arg.Actor,
+		arg.Operation,
+		arg.ResourceType,
+		arg.ResourceID,
+		arg.RequestID,
+		arg.Before,
+		arg.After,
+		arg.Diff,
+		arg.TenantID,
+	)
+	return err
+}
+
+const listAuditLogEntries = `-- name: ListAuditLogEntries :many
+SELECT id, occurred_at, actor, operation, resource_type, resource_id, request_id, before, after, diff, tenant_id
+FROM AuditLog
+WHERE tenant_id = $3
+  AND ($4::varchar IS NULL OR resource_type = $4)
+  AND ($5::varchar IS NULL OR resource_id = $5)
+  AND ($6::varchar IS NULL OR actor = $6)
+  AND ($7::timestamptz IS NULL OR occurred_at >= $7)
+  AND ($8::timestamptz IS NULL OR occurred_at < $8)
+ORDER BY occurred_at DESC, id DESC
+LIMIT $1 OFFSET $2
+`
+
+type ListAuditLogEntriesParams struct {
+	Limit        int32          `json:"limit"`
+	Offset       int32          `json:"offset"`
+	TenantID     int32          `json:"tenant_id"`
+	ResourceType sql.NullString `json:"resource_type"`
+	ResourceID   sql.NullString `json:"resource_id"`
+	Actor        sql.NullString `json:"actor"`
+	Since        sql.NullTime   `json:"since"`
+	Until        sql.NullTime   `json:"until"`
+}
+
+func (q *Queries) ListAuditLogEntries(ctx context.Context, arg ListAuditLogEntriesParams) ([]Auditlog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditLogEntries,
+		arg.Limit,
+		arg.Offset,
+		arg.TenantID,
+		arg.ResourceType,
+		arg.ResourceID,
+		arg.Actor,
+		arg.Since,
+		arg.Until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Auditlog
+	for rows.Next() {
+		var i Auditlog
+		if err := rows.Scan(
+			&i.ID,
+			&i.OccurredAt,
+			&i.Actor,
+			&i.Operation,
+			&i.ResourceType,
+			&i.ResourceID,
+			&i.RequestID,
+			&i.Before,
+			&i.After,
+			&i.Diff,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
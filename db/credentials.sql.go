@@ -0,0 +1,181 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: credentials.sql
+
+package db
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+const createCredential = `-- name: CreateCredential :one
+INSERT INTO Credential (tenant_id, name, secret_hash, scopes)
+VALUES ($4, $1, $2, $3)
+RETURNING id, name, secret_hash, created_at, rotated_at, revoked_at, tenant_id, scopes
+`
+
+type CreateCredentialParams struct {
+	Name       string   `json:"name"`
+	SecretHash []byte   `json:"secret_hash"`
+	Scopes     []string `json:"scopes"`
+	TenantID   int32    `json:"tenant_id"`
+}
+
+func (q *Queries) CreateCredential(ctx context.Context, arg CreateCredentialParams) (Credential, error) {
+	row := q.db.QueryRowContext(ctx, createCredential,
+		arg.Name,
+		arg.SecretHash,
+		pq.Array(arg.Scopes),
+		arg.TenantID,
+	)
+	var i Credential
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.RotatedAt,
+		&i.RevokedAt,
+		&i.TenantID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getActiveCredentialByName = `-- name: GetActiveCredentialByName :one
+SELECT id, name, secret_hash, created_at, rotated_at, revoked_at, tenant_id, scopes
+FROM Credential
+WHERE tenant_id = $2
+  AND name = $1
+  AND revoked_at IS NULL
+`
+
+type GetActiveCredentialByNameParams struct {
+	Name     string `json:"name"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) GetActiveCredentialByName(ctx context.Context, arg GetActiveCredentialByNameParams) (Credential, error) {
+	row := q.db.QueryRowContext(ctx, getActiveCredentialByName, arg.Name, arg.TenantID)
+	var i Credential
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.RotatedAt,
+		&i.RevokedAt,
+		&i.TenantID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const listActiveCredentials = `-- name: ListActiveCredentials :many
+SELECT id, name, secret_hash, created_at, rotated_at, revoked_at, tenant_id, scopes
+FROM Credential
+WHERE tenant_id = $1
+  AND revoked_at IS NULL
+ORDER BY name
+`
+
+func (q *Queries) ListActiveCredentials(ctx context.Context, tenantID int32) ([]Credential, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveCredentials, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Credential
+	for rows.Next() {
+		var i Credential
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.SecretHash,
+			&i.CreatedAt,
+			&i.RotatedAt,
+			&i.RevokedAt,
+			&i.TenantID,
+			pq.Array(&i.Scopes),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeCredential = `-- name: RevokeCredential :execrows
+UPDATE Credential
+SET revoked_at = now()
+WHERE tenant_id = $2
+  AND name = $1
+  AND revoked_at IS NULL
+`
+
+type RevokeCredentialParams struct {
+	Name     string `json:"name"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) RevokeCredential(ctx context.Context, arg RevokeCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeCredential, arg.Name, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateCredential = `-- name: RotateCredential :execrows
+UPDATE Credential
+SET secret_hash = $2,
+    rotated_at  = now()
+WHERE tenant_id = $3
+  AND name = $1
+  AND revoked_at IS NULL
+`
+
+type RotateCredentialParams struct {
+	Name       string `json:"name"`
+	SecretHash []byte `json:"secret_hash"`
+	TenantID   int32  `json:"tenant_id"`
+}
+
+func (q *Queries) RotateCredential(ctx context.Context, arg RotateCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateCredential, arg.Name, arg.SecretHash, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setCredentialScopes = `-- name: SetCredentialScopes :execrows
+UPDATE Credential
+SET scopes = $2
+WHERE tenant_id = $3
+  AND name = $1
+  AND revoked_at IS NULL
+`
+
+type SetCredentialScopesParams struct {
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	TenantID int32    `json:"tenant_id"`
+}
+
+func (q *Queries) SetCredentialScopes(ctx context.Context, arg SetCredentialScopesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setCredentialScopes, arg.Name, pq.Array(arg.Scopes), arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,148 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: idempotency.sql
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :execrows
+INSERT INTO IdempotencyKey (tenant_id, credential, key, request_hash)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (tenant_id, credential, key) DO UPDATE
+    SET request_hash = EXCLUDED.request_hash,
+        status       = NULL,
+        content_type = '',
+        location     = '',
+        body         = NULL,
+        created_at   = now()
+WHERE IdempotencyKey.created_at < $5
+`
+
+type ClaimIdempotencyKeyParams struct {
+	TenantID      int32     `json:"tenant_id"`
+	Credential    string    `json:"credential"`
+	Key           string    `json:"key"`
+	RequestHash   []byte    `json:"request_hash"`
+	ExpiredBefore time.Time `json:"expired_before"`
+}
+
+// Claims a key for a request, taking over keys that expired. No row is
+// claimed while the key is in use.
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimIdempotencyKey,
+		arg.TenantID,
+		arg.Credential,
+		arg.Key,
+		arg.RequestHash,
+		arg.ExpiredBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE IdempotencyKey
+SET status       = $1,
+    content_type = $2,
+    location     = $3,
+    body         = $4
+WHERE tenant_id = $5
+  AND credential = $6
+  AND key = $7
+`
+
+type CompleteIdempotencyKeyParams struct {
+	Status      sql.NullInt32 `json:"status"`
+	ContentType string        `json:"content_type"`
+	Location    string        `json:"location"`
+	Body        []byte        `json:"body"`
+	TenantID    int32         `json:"tenant_id"`
+	Credential  string        `json:"credential"`
+	Key         string        `json:"key"`
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, completeIdempotencyKey,
+		arg.Status,
+		arg.ContentType,
+		arg.Location,
+		arg.Body,
+		arg.TenantID,
+		arg.Credential,
+		arg.Key,
+	)
+	return err
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE
+FROM IdempotencyKey
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT tenant_id, credential, key, request_hash, status, content_type, location, body, created_at
+FROM IdempotencyKey
+WHERE tenant_id = $1
+  AND credential = $2
+  AND key = $3
+`
+
+type GetIdempotencyKeyParams struct {
+	TenantID   int32  `json:"tenant_id"`
+	Credential string `json:"credential"`
+	Key        string `json:"key"`
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (Idempotencykey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.TenantID, arg.Credential, arg.Key)
+	var i Idempotencykey
+	err := row.Scan(
+		&i.TenantID,
+		&i.Credential,
+		&i.Key,
+		&i.RequestHash,
+		&i.Status,
+		&i.ContentType,
+		&i.Location,
+		&i.Body,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const releaseIdempotencyKey = `-- name: ReleaseIdempotencyKey :exec
+DELETE
+FROM IdempotencyKey
+WHERE tenant_id = $1
+  AND credential = $2
+  AND key = $3
+`
+
+type ReleaseIdempotencyKeyParams struct {
+	TenantID   int32  `json:"tenant_id"`
+	Credential string `json:"credential"`
+	Key        string `json:"key"`
+}
+
+// Releases a key whose request failed, so that it can be retried
+func (q *Queries) ReleaseIdempotencyKey(ctx context.Context, arg ReleaseIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, releaseIdempotencyKey, arg.TenantID, arg.Credential, arg.Key)
+	return err
+}
@@ -0,0 +1,134 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: ldaptargets.sql
+
+package db
+
+import (
+	"context"
+)
+
+const deleteLdapTargetEntry = `-- name: DeleteLdapTargetEntry :exec
+DELETE
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = $4
+  AND resource_type = $2
+  AND local_id = $3
+`
+
+type DeleteLdapTargetEntryParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteLdapTargetEntry(ctx context.Context, arg DeleteLdapTargetEntryParams) error {
+	_, err := q.db.ExecContext(ctx, deleteLdapTargetEntry,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.TenantID,
+	)
+	return err
+}
+
+const getLdapTargetEntry = `-- name: GetLdapTargetEntry :one
+SELECT dn
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = $4
+  AND resource_type = $2
+  AND local_id = $3
+`
+
+type GetLdapTargetEntryParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) GetLdapTargetEntry(ctx context.Context, arg GetLdapTargetEntryParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, getLdapTargetEntry,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.TenantID,
+	)
+	var dn string
+	err := row.Scan(&dn)
+	return dn, err
+}
+
+const listLdapTargetEntries = `-- name: ListLdapTargetEntries :many
+SELECT target, tenant_id, resource_type, local_id, dn, updated_at
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = $2
+ORDER BY resource_type, local_id
+`
+
+type ListLdapTargetEntriesParams struct {
+	Target   string `json:"target"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) ListLdapTargetEntries(ctx context.Context, arg ListLdapTargetEntriesParams) ([]Ldaptargetentry, error) {
+	rows, err := q.db.QueryContext(ctx, listLdapTargetEntries, arg.Target, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ldaptargetentry
+	for rows.Next() {
+		var i Ldaptargetentry
+		if err := rows.Scan(
+			&i.Target,
+			&i.TenantID,
+			&i.ResourceType,
+			&i.LocalID,
+			&i.Dn,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setLdapTargetEntry = `-- name: SetLdapTargetEntry :exec
+INSERT INTO LdapTargetEntry (target, tenant_id, resource_type, local_id, dn)
+VALUES ($1, $5, $2, $3, $4)
+ON CONFLICT (target, tenant_id, resource_type, local_id) DO UPDATE
+    SET dn         = EXCLUDED.dn,
+        updated_at = now()
+`
+
+type SetLdapTargetEntryParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	Dn           string `json:"dn"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) SetLdapTargetEntry(ctx context.Context, arg SetLdapTargetEntryParams) error {
+	_, err := q.db.ExecContext(ctx, setLdapTargetEntry,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.Dn,
+		arg.TenantID,
+	)
+	return err
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Migrations holds the schema migrations, named NNN_description.up.sql and
+// NNN_description.down.sql.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// LatestSchemaVersion returns the version of the newest migration.
+func LatestSchemaVersion() int {
+	entries, err := fs.ReadDir(Migrations, "migrations")
+	if err != nil {
+		return 0
+	}
+
+	latest := 0
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		if version, err := strconv.Atoi(prefix); err == nil && version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
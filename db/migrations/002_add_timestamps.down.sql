@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS oktagroup_updated_at_idx;
+DROP INDEX IF EXISTS employee_updated_at_idx;
+
+ALTER TABLE OktaGroup
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at;
+
+ALTER TABLE Employee
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at;
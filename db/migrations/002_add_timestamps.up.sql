@@ -0,0 +1,12 @@
+-- Track when users and groups are created and last modified
+ALTER TABLE Employee
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE OktaGroup
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Support meta.lastModified filters used for incremental sync
+CREATE INDEX IF NOT EXISTS employee_updated_at_idx ON Employee (updated_at);
+CREATE INDEX IF NOT EXISTS oktagroup_updated_at_idx ON OktaGroup (updated_at);
@@ -0,0 +1,2 @@
+-- Drop the OktaGroupMemberGroup table
+DROP TABLE IF EXISTS OktaGroupMemberGroup;
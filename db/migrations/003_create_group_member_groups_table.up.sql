@@ -0,0 +1,13 @@
+-- Create the table linking groups to the groups they contain
+CREATE TABLE IF NOT EXISTS OktaGroupMemberGroup
+(
+    group_id        INTEGER NOT NULL,
+    member_group_id INTEGER NOT NULL,
+    PRIMARY KEY (group_id, member_group_id),
+    FOREIGN KEY (group_id) REFERENCES OktaGroup (id) ON DELETE CASCADE,
+    FOREIGN KEY (member_group_id) REFERENCES OktaGroup (id) ON DELETE CASCADE,
+    CHECK (group_id <> member_group_id)
+);
+
+-- Support resolving the groups a group belongs to
+CREATE INDEX IF NOT EXISTS oktagroupmembergroup_member_group_id_idx ON OktaGroupMemberGroup (member_group_id);
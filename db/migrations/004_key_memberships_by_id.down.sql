@@ -0,0 +1,28 @@
+DROP INDEX IF EXISTS employeeoktagroup_okta_group_id_idx;
+
+ALTER TABLE EmployeeOktaGroup
+    ADD COLUMN employee_okta_id VARCHAR(255) REFERENCES Employee (okta_id) ON DELETE CASCADE,
+    ADD COLUMN okta_group_name  VARCHAR(255) REFERENCES OktaGroup (name) ON DELETE CASCADE;
+
+UPDATE EmployeeOktaGroup eog
+SET employee_okta_id = e.okta_id
+FROM Employee e
+WHERE e.id = eog.employee_id;
+
+UPDATE EmployeeOktaGroup eog
+SET okta_group_name = g.name
+FROM OktaGroup g
+WHERE g.id = eog.okta_group_id;
+
+ALTER TABLE EmployeeOktaGroup
+    DROP CONSTRAINT IF EXISTS employeeoktagroup_pkey,
+    DROP COLUMN employee_id,
+    DROP COLUMN okta_group_id,
+    ALTER COLUMN employee_okta_id SET NOT NULL,
+    ALTER COLUMN okta_group_name SET NOT NULL;
+
+ALTER TABLE EmployeeOktaGroup
+    RENAME COLUMN employee_okta_id TO employee_id;
+
+ALTER TABLE EmployeeOktaGroup
+    ADD PRIMARY KEY (employee_id, okta_group_name);
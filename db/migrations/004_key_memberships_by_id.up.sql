@@ -0,0 +1,34 @@
+-- Reference employees and groups by their primary keys instead of okta_id and
+-- name, so that renaming a group or changing a user keeps memberships intact
+ALTER TABLE EmployeeOktaGroup
+    RENAME COLUMN employee_id TO employee_okta_id;
+
+ALTER TABLE EmployeeOktaGroup
+    ADD COLUMN employee_id   INTEGER REFERENCES Employee (id) ON DELETE CASCADE,
+    ADD COLUMN okta_group_id INTEGER REFERENCES OktaGroup (id) ON DELETE CASCADE;
+
+UPDATE EmployeeOktaGroup eog
+SET employee_id = e.id
+FROM Employee e
+WHERE e.okta_id = eog.employee_okta_id;
+
+UPDATE EmployeeOktaGroup eog
+SET okta_group_id = g.id
+FROM OktaGroup g
+WHERE g.name = eog.okta_group_name;
+
+DELETE
+FROM EmployeeOktaGroup
+WHERE employee_id IS NULL
+   OR okta_group_id IS NULL;
+
+ALTER TABLE EmployeeOktaGroup
+    DROP CONSTRAINT IF EXISTS employeeoktagroup_pkey,
+    DROP COLUMN employee_okta_id,
+    DROP COLUMN okta_group_name,
+    ALTER COLUMN employee_id SET NOT NULL,
+    ALTER COLUMN okta_group_id SET NOT NULL,
+    ADD PRIMARY KEY (employee_id, okta_group_id);
+
+-- Support listing the members of a group
+CREATE INDEX IF NOT EXISTS employeeoktagroup_okta_group_id_idx ON EmployeeOktaGroup (okta_group_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS employee_deactivated_at_idx;
+
+ALTER TABLE Employee
+    DROP COLUMN IF EXISTS purged_at,
+    DROP COLUMN IF EXISTS deactivated_at;
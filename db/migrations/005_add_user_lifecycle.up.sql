@@ -0,0 +1,12 @@
+-- Track when users were deactivated and when their personal data was purged
+ALTER TABLE Employee
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS purged_at      TIMESTAMPTZ;
+
+UPDATE Employee
+SET deactivated_at = updated_at
+WHERE active = false
+  AND deactivated_at IS NULL;
+
+-- Support finding deactivated users past their retention period
+CREATE INDEX IF NOT EXISTS employee_deactivated_at_idx ON Employee (deactivated_at) WHERE active = false;
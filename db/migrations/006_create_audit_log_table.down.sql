@@ -0,0 +1,4 @@
+-- Drop the AuditLog table
+DROP TABLE IF EXISTS AuditLog;
+
+DROP FUNCTION IF EXISTS auditlog_append_only();
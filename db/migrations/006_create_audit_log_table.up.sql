@@ -0,0 +1,32 @@
+-- Create the append-only audit log of provisioning changes
+CREATE TABLE IF NOT EXISTS AuditLog
+(
+    id            BIGSERIAL PRIMARY KEY,
+    occurred_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    actor         VARCHAR(255) NOT NULL,
+    operation     VARCHAR(64)  NOT NULL,
+    resource_type VARCHAR(64)  NOT NULL,
+    resource_id   VARCHAR(255) NOT NULL,
+    request_id    VARCHAR(255) NOT NULL,
+    before        JSONB,
+    after         JSONB,
+    diff          JSONB
+);
+
+CREATE INDEX IF NOT EXISTS auditlog_occurred_at_idx ON AuditLog (occurred_at);
+CREATE INDEX IF NOT EXISTS auditlog_resource_idx ON AuditLog (resource_type, resource_id, occurred_at);
+CREATE INDEX IF NOT EXISTS auditlog_actor_idx ON AuditLog (actor, occurred_at);
+
+-- Reject any attempt to rewrite history
+CREATE OR REPLACE FUNCTION auditlog_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'AuditLog is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auditlog_append_only
+    BEFORE UPDATE OR DELETE
+    ON AuditLog
+    FOR EACH ROW
+EXECUTE FUNCTION auditlog_append_only();
@@ -0,0 +1,2 @@
+-- Drop the SchemaMigrations table
+DROP TABLE IF EXISTS SchemaMigrations;
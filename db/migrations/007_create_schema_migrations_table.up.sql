@@ -0,0 +1,11 @@
+-- Record the applied schema version. Every migration from this one onwards
+-- inserts its own version, earlier migrations are recorded here.
+CREATE TABLE IF NOT EXISTS SchemaMigrations
+(
+    version    INT PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO SchemaMigrations (version)
+SELECT generate_series(1, 7)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- Drop the Credential table
+DROP TABLE IF EXISTS Credential;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 8;
@@ -0,0 +1,14 @@
+-- Create the table of API credentials managed with the credentials command
+CREATE TABLE IF NOT EXISTS Credential
+(
+    id          SERIAL PRIMARY KEY,
+    name        VARCHAR(255) NOT NULL UNIQUE,
+    secret_hash BYTEA        NOT NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    rotated_at  TIMESTAMPTZ,
+    revoked_at  TIMESTAMPTZ
+);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (8)
+ON CONFLICT DO NOTHING;
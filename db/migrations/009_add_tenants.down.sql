@@ -0,0 +1,43 @@
+-- Only the data of the default tenant can be kept without tenants
+DELETE
+FROM Tenant
+WHERE id <> 1;
+
+DROP INDEX IF EXISTS auditlog_tenant_id_occurred_at_idx;
+
+ALTER TABLE AuditLog
+    DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE Credential
+    DROP CONSTRAINT IF EXISTS credential_tenant_id_name_key,
+    DROP COLUMN IF EXISTS tenant_id,
+    ADD CONSTRAINT credential_name_key UNIQUE (name);
+
+ALTER TABLE OktaGroupMemberGroup
+    DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE EmployeeOktaGroup
+    DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE OktaGroup
+    DROP CONSTRAINT IF EXISTS oktagroup_tenant_id_name_key,
+    DROP CONSTRAINT IF EXISTS oktagroup_tenant_id_okta_id_key,
+    DROP CONSTRAINT IF EXISTS oktagroup_tenant_id_id_key,
+    DROP COLUMN IF EXISTS tenant_id,
+    ADD CONSTRAINT oktagroup_name_key UNIQUE (name),
+    ADD CONSTRAINT oktagroup_okta_id_key UNIQUE (okta_id);
+
+ALTER TABLE Employee
+    DROP CONSTRAINT IF EXISTS employee_tenant_id_email_key,
+    DROP CONSTRAINT IF EXISTS employee_tenant_id_okta_id_key,
+    DROP CONSTRAINT IF EXISTS employee_tenant_id_id_key,
+    DROP COLUMN IF EXISTS extensions,
+    DROP COLUMN IF EXISTS tenant_id,
+    ADD CONSTRAINT employee_email_key UNIQUE (email),
+    ADD CONSTRAINT employee_okta_id_key UNIQUE (okta_id);
+
+DROP TABLE IF EXISTS Tenant;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 9;
@@ -0,0 +1,85 @@
+-- Create the Tenant table. Each tenant is served under /t/{name}/scim/v2, or
+-- under /scim/v2 on its host, with its own users, groups and credentials
+CREATE TABLE IF NOT EXISTS Tenant
+(
+    id                SERIAL PRIMARY KEY,
+    name              VARCHAR(63)  NOT NULL UNIQUE,
+    host              VARCHAR(255) UNIQUE,
+    schema_extensions TEXT[]       NOT NULL DEFAULT '{}',
+    created_at        TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+-- Existing data belongs to the default tenant, served under /scim/v2
+INSERT INTO Tenant (id, name)
+VALUES (1, 'default')
+ON CONFLICT DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('tenant', 'id'), (SELECT MAX(id) FROM Tenant));
+
+-- Scope users and groups to their tenant. Identifiers, user names and group
+-- names are unique within a tenant, and (tenant_id, id) is referenced by the
+-- membership tables so that memberships cannot cross tenants
+ALTER TABLE Employee
+    ADD COLUMN tenant_id  INTEGER NOT NULL DEFAULT 1 REFERENCES Tenant (id) ON DELETE CASCADE,
+    ADD COLUMN extensions JSONB   NOT NULL DEFAULT '{}';
+
+ALTER TABLE Employee
+    ALTER COLUMN tenant_id DROP DEFAULT,
+    DROP CONSTRAINT IF EXISTS employee_email_key,
+    DROP CONSTRAINT IF EXISTS employee_okta_id_key,
+    ADD CONSTRAINT employee_tenant_id_email_key UNIQUE (tenant_id, email),
+    ADD CONSTRAINT employee_tenant_id_okta_id_key UNIQUE (tenant_id, okta_id),
+    ADD CONSTRAINT employee_tenant_id_id_key UNIQUE (tenant_id, id);
+
+ALTER TABLE OktaGroup
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES Tenant (id) ON DELETE CASCADE;
+
+ALTER TABLE OktaGroup
+    ALTER COLUMN tenant_id DROP DEFAULT,
+    DROP CONSTRAINT IF EXISTS oktagroup_name_key,
+    DROP CONSTRAINT IF EXISTS oktagroup_okta_id_key,
+    ADD CONSTRAINT oktagroup_tenant_id_name_key UNIQUE (tenant_id, name),
+    ADD CONSTRAINT oktagroup_tenant_id_okta_id_key UNIQUE (tenant_id, okta_id),
+    ADD CONSTRAINT oktagroup_tenant_id_id_key UNIQUE (tenant_id, id);
+
+ALTER TABLE EmployeeOktaGroup
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE EmployeeOktaGroup
+    ALTER COLUMN tenant_id DROP DEFAULT,
+    ADD CONSTRAINT employeeoktagroup_tenant_employee_fkey FOREIGN KEY (tenant_id, employee_id)
+        REFERENCES Employee (tenant_id, id) ON DELETE CASCADE,
+    ADD CONSTRAINT employeeoktagroup_tenant_group_fkey FOREIGN KEY (tenant_id, okta_group_id)
+        REFERENCES OktaGroup (tenant_id, id) ON DELETE CASCADE;
+
+ALTER TABLE OktaGroupMemberGroup
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE OktaGroupMemberGroup
+    ALTER COLUMN tenant_id DROP DEFAULT,
+    ADD CONSTRAINT oktagroupmembergroup_tenant_group_fkey FOREIGN KEY (tenant_id, group_id)
+        REFERENCES OktaGroup (tenant_id, id) ON DELETE CASCADE,
+    ADD CONSTRAINT oktagroupmembergroup_tenant_member_group_fkey FOREIGN KEY (tenant_id, member_group_id)
+        REFERENCES OktaGroup (tenant_id, id) ON DELETE CASCADE;
+
+-- Credentials are named within their tenant
+ALTER TABLE Credential
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES Tenant (id) ON DELETE CASCADE;
+
+ALTER TABLE Credential
+    ALTER COLUMN tenant_id DROP DEFAULT,
+    DROP CONSTRAINT IF EXISTS credential_name_key,
+    ADD CONSTRAINT credential_tenant_id_name_key UNIQUE (tenant_id, name);
+
+-- The audit log outlives deleted tenants, so it does not reference them
+ALTER TABLE AuditLog
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE AuditLog
+    ALTER COLUMN tenant_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS auditlog_tenant_id_occurred_at_idx ON AuditLog (tenant_id, occurred_at);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (9)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+-- Drop the credential scopes
+ALTER TABLE Credential
+    DROP COLUMN IF EXISTS scopes;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 10;
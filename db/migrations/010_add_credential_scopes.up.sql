@@ -0,0 +1,10 @@
+-- Restrict what each credential can do. Existing credentials keep full access
+ALTER TABLE Credential
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{users:read,users:write,groups:read,groups:write,bulk,admin}';
+
+ALTER TABLE Credential
+    ALTER COLUMN scopes DROP DEFAULT;
+
+INSERT INTO SchemaMigrations (version)
+VALUES (10)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- Drop the IdempotencyKey table
+DROP TABLE IF EXISTS IdempotencyKey;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 11;
@@ -0,0 +1,22 @@
+-- Create the table of responses to requests with an idempotency key, replayed
+-- when clients retry the requests
+CREATE TABLE IF NOT EXISTS IdempotencyKey
+(
+    tenant_id    INTEGER      NOT NULL REFERENCES Tenant (id) ON DELETE CASCADE,
+    credential   VARCHAR(255) NOT NULL,
+    key          VARCHAR(255) NOT NULL,
+    request_hash BYTEA        NOT NULL,
+    -- NULL while the request is being served
+    status       INTEGER,
+    content_type TEXT         NOT NULL DEFAULT '',
+    location     TEXT         NOT NULL DEFAULT '',
+    body         BYTEA,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant_id, credential, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotencykey_created_at_idx ON IdempotencyKey (created_at);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (11)
+ON CONFLICT DO NOTHING;
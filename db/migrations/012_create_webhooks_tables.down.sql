@@ -0,0 +1,7 @@
+-- Drop the WebhookDelivery and WebhookEndpoint tables
+DROP TABLE IF EXISTS WebhookDelivery;
+DROP TABLE IF EXISTS WebhookEndpoint;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 12;
@@ -0,0 +1,41 @@
+-- Create the table of the endpoints notified of provisioning events, managed
+-- with the webhooks command
+CREATE TABLE IF NOT EXISTS WebhookEndpoint
+(
+    id         SERIAL PRIMARY KEY,
+    tenant_id  INTEGER      NOT NULL REFERENCES Tenant (id) ON DELETE CASCADE,
+    name       VARCHAR(255) NOT NULL,
+    url        TEXT         NOT NULL,
+    secret     TEXT         NOT NULL,
+    -- Types of the events delivered to the endpoint, every type when empty
+    events     TEXT[]       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, name)
+);
+
+-- Create the queue of events to deliver to the endpoints. Deliveries that
+-- exhausted their attempts are kept as dead letters until replayed.
+CREATE TABLE IF NOT EXISTS WebhookDelivery
+(
+    id              BIGSERIAL PRIMARY KEY,
+    endpoint_id     INTEGER     NOT NULL REFERENCES WebhookEndpoint (id) ON DELETE CASCADE,
+    event_id        UUID        NOT NULL,
+    event_type      VARCHAR(64) NOT NULL,
+    payload         JSONB       NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMPTZ,
+    failed_at       TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webhookdelivery_pending_idx ON WebhookDelivery (next_attempt_at)
+    WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS webhookdelivery_failed_idx ON WebhookDelivery (endpoint_id)
+    WHERE failed_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS webhookdelivery_delivered_at_idx ON WebhookDelivery (delivered_at);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (12)
+ON CONFLICT DO NOTHING;
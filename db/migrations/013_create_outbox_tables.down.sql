@@ -0,0 +1,7 @@
+-- Drop the OutboxConsumer and Outbox tables
+DROP TABLE IF EXISTS OutboxConsumer;
+DROP TABLE IF EXISTS Outbox;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 13;
@@ -0,0 +1,33 @@
+-- Create the outbox of change events, written in the transactions of the
+-- changes and published to the configured sinks
+CREATE TABLE IF NOT EXISTS Outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    tenant_id    INTEGER     NOT NULL REFERENCES Tenant (id) ON DELETE CASCADE,
+    -- Transaction that wrote the event. Events are published in the order of
+    -- (txid, id) once every transaction that could precede them has ended.
+    txid         BIGINT      NOT NULL DEFAULT txid_current(),
+    event_id     UUID        NOT NULL,
+    event_type   VARCHAR(64) NOT NULL,
+    payload      JSONB       NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- Set once every sink published the event
+    delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_txid_id_idx ON Outbox (txid, id);
+CREATE INDEX IF NOT EXISTS outbox_delivered_at_idx ON Outbox (delivered_at);
+
+-- Create the table of the position of each sink in the outbox, the last event
+-- it published
+CREATE TABLE IF NOT EXISTS OutboxConsumer
+(
+    name       VARCHAR(255) PRIMARY KEY,
+    last_txid  BIGINT       NOT NULL DEFAULT 0,
+    last_id    BIGINT       NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (13)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- Drop the ScimTargetResource table
+DROP TABLE IF EXISTS ScimTargetResource;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 14;
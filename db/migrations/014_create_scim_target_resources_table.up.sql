@@ -0,0 +1,16 @@
+-- Create the table mapping local users and groups to their ids at the SCIM
+-- targets they are provisioned to
+CREATE TABLE IF NOT EXISTS ScimTargetResource
+(
+    target        VARCHAR(255) NOT NULL,
+    tenant_id     INTEGER      NOT NULL REFERENCES Tenant (id) ON DELETE CASCADE,
+    resource_type VARCHAR(16)  NOT NULL,
+    local_id      VARCHAR(255) NOT NULL,
+    remote_id     VARCHAR(255) NOT NULL,
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (target, tenant_id, resource_type, local_id)
+);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (14)
+ON CONFLICT DO NOTHING;
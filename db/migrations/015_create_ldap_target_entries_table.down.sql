@@ -0,0 +1,6 @@
+-- Drop the LdapTargetEntry table
+DROP TABLE IF EXISTS LdapTargetEntry;
+
+DELETE
+FROM SchemaMigrations
+WHERE version = 15;
@@ -0,0 +1,16 @@
+-- Create the table mapping local users and groups to the DNs of their entries
+-- in the LDAP directories they are mirrored to
+CREATE TABLE IF NOT EXISTS LdapTargetEntry
+(
+    target        VARCHAR(255) NOT NULL,
+    tenant_id     INTEGER      NOT NULL REFERENCES Tenant (id) ON DELETE CASCADE,
+    resource_type VARCHAR(16)  NOT NULL,
+    local_id      VARCHAR(255) NOT NULL,
+    dn            TEXT         NOT NULL,
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (target, tenant_id, resource_type, local_id)
+);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (15)
+ON CONFLICT DO NOTHING;
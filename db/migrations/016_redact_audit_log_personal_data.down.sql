@@ -0,0 +1,4 @@
+-- Redacted personal data cannot be restored
+DELETE
+FROM SchemaMigrations
+WHERE version = 16;
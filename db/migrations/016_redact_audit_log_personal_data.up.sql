@@ -0,0 +1,28 @@
+-- Remove the personal data of users from the audit log entries recorded
+-- before it stopped storing them, which purging the users could not erase.
+-- Changed personal attributes stay in the diff with their values redacted
+CREATE OR REPLACE FUNCTION auditlog_redact_diff(diff JSONB, attribute TEXT) RETURNS JSONB AS
+$$
+SELECT CASE
+           WHEN diff ? attribute THEN jsonb_set(diff, ARRAY [attribute], jsonb_build_object(
+                   'before', CASE WHEN COALESCE(diff -> attribute -> 'before', 'null') = 'null' THEN 'null' ELSE '"[redacted]"' END::JSONB,
+                   'after', CASE WHEN COALESCE(diff -> attribute -> 'after', 'null') = 'null' THEN 'null' ELSE '"[redacted]"' END::JSONB))
+           ELSE diff
+           END
+$$ LANGUAGE sql;
+
+ALTER TABLE AuditLog DISABLE TRIGGER auditlog_append_only;
+
+UPDATE AuditLog
+SET before = before - 'userName' - 'name',
+    after  = after - 'userName' - 'name',
+    diff   = auditlog_redact_diff(auditlog_redact_diff(diff, 'userName'), 'name')
+WHERE resource_type = 'User';
+
+ALTER TABLE AuditLog ENABLE TRIGGER auditlog_append_only;
+
+DROP FUNCTION auditlog_redact_diff(JSONB, TEXT);
+
+INSERT INTO SchemaMigrations (version)
+VALUES (16)
+ON CONFLICT DO NOTHING;
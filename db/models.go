@@ -6,23 +6,154 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
+type Auditlog struct {
+	ID           int64                 `json:"id"`
+	OccurredAt   time.Time             `json:"occurred_at"`
+	Actor        string                `json:"actor"`
+	Operation    string                `json:"operation"`
+	ResourceType string                `json:"resource_type"`
+	ResourceID   string                `json:"resource_id"`
+	RequestID    string                `json:"request_id"`
+	Before       pqtype.NullRawMessage `json:"before"`
+	After        pqtype.NullRawMessage `json:"after"`
+	Diff         pqtype.NullRawMessage `json:"diff"`
+	TenantID     int32                 `json:"tenant_id"`
+}
+
+type Credential struct {
+	ID         int32        `json:"id"`
+	Name       string       `json:"name"`
+	SecretHash []byte       `json:"secret_hash"`
+	CreatedAt  time.Time    `json:"created_at"`
+	RotatedAt  sql.NullTime `json:"rotated_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+	TenantID   int32        `json:"tenant_id"`
+	Scopes     []string     `json:"scopes"`
+}
+
 type Employee struct {
-	ID     int32  `json:"id"`
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	OktaID string `json:"okta_id"`
-	Active bool   `json:"active"`
+	ID            int32           `json:"id"`
+	Name          string          `json:"name"`
+	Email         string          `json:"email"`
+	OktaID        string          `json:"okta_id"`
+	Active        bool            `json:"active"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	DeactivatedAt sql.NullTime    `json:"deactivated_at"`
+	PurgedAt      sql.NullTime    `json:"purged_at"`
+	TenantID      int32           `json:"tenant_id"`
+	Extensions    json.RawMessage `json:"extensions"`
 }
 
 type Employeeoktagroup struct {
-	EmployeeID    string `json:"employee_id"`
-	OktaGroupName string `json:"okta_group_name"`
+	EmployeeID  int32 `json:"employee_id"`
+	OktaGroupID int32 `json:"okta_group_id"`
+	TenantID    int32 `json:"tenant_id"`
+}
+
+type Idempotencykey struct {
+	TenantID    int32         `json:"tenant_id"`
+	Credential  string        `json:"credential"`
+	Key         string        `json:"key"`
+	RequestHash []byte        `json:"request_hash"`
+	Status      sql.NullInt32 `json:"status"`
+	ContentType string        `json:"content_type"`
+	Location    string        `json:"location"`
+	Body        []byte        `json:"body"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+type Ldaptargetentry struct {
+	Target       string    `json:"target"`
+	TenantID     int32     `json:"tenant_id"`
+	ResourceType string    `json:"resource_type"`
+	LocalID      string    `json:"local_id"`
+	Dn           string    `json:"dn"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type Oktagroup struct {
-	ID     int32          `json:"id"`
-	Name   string         `json:"name"`
-	OktaID sql.NullString `json:"okta_id"`
+	ID        int32          `json:"id"`
+	Name      string         `json:"name"`
+	OktaID    sql.NullString `json:"okta_id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	TenantID  int32          `json:"tenant_id"`
+}
+
+type Oktagroupmembergroup struct {
+	GroupID       int32 `json:"group_id"`
+	MemberGroupID int32 `json:"member_group_id"`
+	TenantID      int32 `json:"tenant_id"`
+}
+
+type Outbox struct {
+	ID          int64           `json:"id"`
+	TenantID    int32           `json:"tenant_id"`
+	Txid        int64           `json:"txid"`
+	EventID     uuid.UUID       `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt sql.NullTime    `json:"delivered_at"`
+}
+
+type Outboxconsumer struct {
+	Name      string    `json:"name"`
+	LastTxid  int64     `json:"last_txid"`
+	LastID    int64     `json:"last_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Schemamigration struct {
+	Version   int32     `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+type Scimtargetresource struct {
+	Target       string    `json:"target"`
+	TenantID     int32     `json:"tenant_id"`
+	ResourceType string    `json:"resource_type"`
+	LocalID      string    `json:"local_id"`
+	RemoteID     string    `json:"remote_id"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type Tenant struct {
+	ID               int32          `json:"id"`
+	Name             string         `json:"name"`
+	Host             sql.NullString `json:"host"`
+	SchemaExtensions []string       `json:"schema_extensions"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+type Webhookdelivery struct {
+	ID            int64           `json:"id"`
+	EndpointID    int32           `json:"endpoint_id"`
+	EventID       uuid.UUID       `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int32           `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     sql.NullString  `json:"last_error"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   sql.NullTime    `json:"delivered_at"`
+	FailedAt      sql.NullTime    `json:"failed_at"`
+}
+
+type Webhookendpoint struct {
+	ID        int32     `json:"id"`
+	TenantID  int32     `json:"tenant_id"`
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
 }
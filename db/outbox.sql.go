@@ -0,0 +1,260 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: outbox.sql
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const deleteDeliveredOutboxEvents = `-- name: DeleteDeliveredOutboxEvents :execrows
+DELETE
+FROM Outbox
+WHERE delivered_at < $1::timestamptz
+`
+
+func (q *Queries) DeleteDeliveredOutboxEvents(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteDeliveredOutboxEvents, deliveredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ensureOutboxConsumer = `-- name: EnsureOutboxConsumer :exec
+INSERT INTO OutboxConsumer (name)
+VALUES ($1)
+ON CONFLICT DO NOTHING
+`
+
+func (q *Queries) EnsureOutboxConsumer(ctx context.Context, name string) error {
+	_, err := q.db.ExecContext(ctx, ensureOutboxConsumer, name)
+	return err
+}
+
+const getOutboxEvent = `-- name: GetOutboxEvent :one
+SELECT id, tenant_id, txid, event_id, event_type, payload, created_at, delivered_at
+FROM Outbox
+WHERE id = $1
+`
+
+func (q *Queries) GetOutboxEvent(ctx context.Context, id int64) (Outbox, error) {
+	row := q.db.QueryRowContext(ctx, getOutboxEvent, id)
+	var i Outbox
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.Txid,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.DeliveredAt,
+	)
+	return i, err
+}
+
+const listOutboxConsumers = `-- name: ListOutboxConsumers :many
+SELECT outboxconsumer.name, outboxconsumer.last_txid, outboxconsumer.last_id, outboxconsumer.updated_at,
+       (SELECT count(*)
+        FROM Outbox
+        WHERE (Outbox.txid, Outbox.id) > (OutboxConsumer.last_txid, OutboxConsumer.last_id))::bigint AS pending
+FROM OutboxConsumer
+ORDER BY name
+`
+
+type ListOutboxConsumersRow struct {
+	Name      string    `json:"name"`
+	LastTxid  int64     `json:"last_txid"`
+	LastID    int64     `json:"last_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Pending   int64     `json:"pending"`
+}
+
+func (q *Queries) ListOutboxConsumers(ctx context.Context) ([]ListOutboxConsumersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listOutboxConsumers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOutboxConsumersRow
+	for rows.Next() {
+		var i ListOutboxConsumersRow
+		if err := rows.Scan(
+			&i.Name,
+			&i.LastTxid,
+			&i.LastID,
+			&i.UpdatedAt,
+			&i.Pending,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOutboxEvents = `-- name: ListOutboxEvents :many
+SELECT id, tenant_id, txid, event_id, event_type, payload, created_at, delivered_at
+FROM Outbox
+WHERE (txid, id) > ($1::bigint, $2::bigint)
+  AND txid < txid_snapshot_xmin(txid_current_snapshot())
+ORDER BY txid, id
+LIMIT $3
+`
+
+type ListOutboxEventsParams struct {
+	AfterTxid int64 `json:"after_txid"`
+	AfterID   int64 `json:"after_id"`
+	BatchSize int32 `json:"batch_size"`
+}
+
+// Lists the events following the position of a consumer. Events of
+// transactions that are still running, or that started before one that is
+// still running, are left for later so that none is skipped.
+func (q *Queries) ListOutboxEvents(ctx context.Context, arg ListOutboxEventsParams) ([]Outbox, error) {
+	rows, err := q.db.QueryContext(ctx, listOutboxEvents, arg.AfterTxid, arg.AfterID, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Outbox
+	for rows.Next() {
+		var i Outbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.Txid,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockOutboxConsumer = `-- name: LockOutboxConsumer :one
+SELECT name, last_txid, last_id, updated_at
+FROM OutboxConsumer
+WHERE name = $1
+    FOR UPDATE SKIP LOCKED
+`
+
+// Locks a consumer for the rest of the transaction. No row is returned while
+// another transaction holds the lock.
+func (q *Queries) LockOutboxConsumer(ctx context.Context, name string) (Outboxconsumer, error) {
+	row := q.db.QueryRowContext(ctx, lockOutboxConsumer, name)
+	var i Outboxconsumer
+	err := row.Scan(
+		&i.Name,
+		&i.LastTxid,
+		&i.LastID,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markOutboxDelivered = `-- name: MarkOutboxDelivered :execrows
+UPDATE Outbox
+SET delivered_at = now()
+WHERE delivered_at IS NULL
+  AND NOT EXISTS (SELECT 1
+                  FROM OutboxConsumer
+                  WHERE OutboxConsumer.name = ANY ($1::varchar[])
+                    AND (Outbox.txid, Outbox.id) > (OutboxConsumer.last_txid, OutboxConsumer.last_id))
+`
+
+// Marks the events every one of the consumers published as delivered
+func (q *Queries) MarkOutboxDelivered(ctx context.Context, consumers []string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOutboxDelivered, pq.Array(consumers))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const resetOutboxDelivered = `-- name: ResetOutboxDelivered :exec
+UPDATE Outbox
+SET delivered_at = NULL
+WHERE (txid, id) > ($1::bigint, $2::bigint)
+`
+
+type ResetOutboxDeliveredParams struct {
+	AfterTxid int64 `json:"after_txid"`
+	AfterID   int64 `json:"after_id"`
+}
+
+// Marks the events following a position pending again, so that they are
+// kept until a sink rewound to the position published them again
+func (q *Queries) ResetOutboxDelivered(ctx context.Context, arg ResetOutboxDeliveredParams) error {
+	_, err := q.db.ExecContext(ctx, resetOutboxDelivered, arg.AfterTxid, arg.AfterID)
+	return err
+}
+
+const setOutboxConsumerPosition = `-- name: SetOutboxConsumerPosition :execrows
+UPDATE OutboxConsumer
+SET last_txid  = $2,
+    last_id    = $3,
+    updated_at = now()
+WHERE name = $1
+`
+
+type SetOutboxConsumerPositionParams struct {
+	Name     string `json:"name"`
+	LastTxid int64  `json:"last_txid"`
+	LastID   int64  `json:"last_id"`
+}
+
+func (q *Queries) SetOutboxConsumerPosition(ctx context.Context, arg SetOutboxConsumerPositionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setOutboxConsumerPosition, arg.Name, arg.LastTxid, arg.LastID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const writeOutboxEvent = `-- name: WriteOutboxEvent :exec
+INSERT INTO Outbox (tenant_id, event_id, event_type, payload)
+VALUES ($4, $1, $2, $3)
+`
+
+type WriteOutboxEventParams struct {
+	EventID   uuid.UUID       `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	TenantID  int32           `json:"tenant_id"`
+}
+
+func (q *Queries) WriteOutboxEvent(ctx context.Context, arg WriteOutboxEventParams) error {
+	_, err := q.db.ExecContext(ctx, writeOutboxEvent,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+		arg.TenantID,
+	)
+	return err
+}
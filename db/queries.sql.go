@@ -9,92 +9,139 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 const addGroupMember = `-- name: AddGroupMember :exec
-INSERT INTO EmployeeOktaGroup (employee_id, okta_group_name)
-VALUES ($1, $2)
-ON CONFLICT (employee_id, okta_group_name) DO NOTHING
+INSERT INTO EmployeeOktaGroup (tenant_id, employee_id, okta_group_id)
+VALUES ($3, $1, $2)
+ON CONFLICT (employee_id, okta_group_id) DO NOTHING
 `
 
 type AddGroupMemberParams struct {
-	EmployeeID    string `json:"employee_id"`
-	OktaGroupName string `json:"okta_group_name"`
+	EmployeeID  int32 `json:"employee_id"`
+	OktaGroupID int32 `json:"okta_group_id"`
+	TenantID    int32 `json:"tenant_id"`
 }
 
+// The foreign keys on (tenant_id, employee_id) and (tenant_id, okta_group_id)
+// reject memberships across tenants.
 func (q *Queries) AddGroupMember(ctx context.Context, arg AddGroupMemberParams) error {
-	_, err := q.db.ExecContext(ctx, addGroupMember, arg.EmployeeID, arg.OktaGroupName)
+	_, err := q.db.ExecContext(ctx, addGroupMember, arg.EmployeeID, arg.OktaGroupID, arg.TenantID)
 	return err
 }
 
-const createEmployeeOktaGroup = `-- name: CreateEmployeeOktaGroup :exec
-INSERT INTO EmployeeOktaGroup (employee_id, okta_group_name)
-SELECT e.id, og.id
-FROM Employee e,
-     OktaGroup og
-WHERE e.okta_id = $1
-  AND og.name = $2
-ON CONFLICT (employee_id, okta_group_name) DO NOTHING
+const addGroupMemberGroup = `-- name: AddGroupMemberGroup :exec
+INSERT INTO OktaGroupMemberGroup (tenant_id, group_id, member_group_id)
+VALUES ($3, $1, $2)
+ON CONFLICT (group_id, member_group_id) DO NOTHING
 `
 
-type CreateEmployeeOktaGroupParams struct {
-	OktaID string `json:"okta_id"`
-	Name   string `json:"name"`
+type AddGroupMemberGroupParams struct {
+	GroupID       int32 `json:"group_id"`
+	MemberGroupID int32 `json:"member_group_id"`
+	TenantID      int32 `json:"tenant_id"`
 }
 
-func (q *Queries) CreateEmployeeOktaGroup(ctx context.Context, arg CreateEmployeeOktaGroupParams) error {
-	_, err := q.db.ExecContext(ctx, createEmployeeOktaGroup, arg.OktaID, arg.Name)
+func (q *Queries) AddGroupMemberGroup(ctx context.Context, arg AddGroupMemberGroupParams) error {
+	_, err := q.db.ExecContext(ctx, addGroupMemberGroup, arg.GroupID, arg.MemberGroupID, arg.TenantID)
 	return err
 }
 
-const createGroup = `-- name: CreateGroup :one
-WITH inserted AS (
-    INSERT INTO OktaGroup (name, okta_id)
-        VALUES ($1, $2)
-        ON CONFLICT (name) DO NOTHING
-        RETURNING id, name, okta_id)
-SELECT id, name, okta_id
-FROM inserted
-UNION
-SELECT id, name, okta_id
-FROM OktaGroup
-WHERE name = $1
+const anonymizePurgeableUser = `-- name: AnonymizePurgeableUser :one
+UPDATE Employee
+SET name       = 'Purged User',
+    email      = 'purged-' || id || '@invalid',
+    okta_id    = 'purged-' || id,
+    extensions = '{}',
+    purged_at  = now(),
+    updated_at = now()
+WHERE tenant_id = $2
+  AND id = $1
+  AND active = false
+RETURNING id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 `
 
-type CreateGroupParams struct {
-	Name   string         `json:"name"`
-	OktaID sql.NullString `json:"okta_id"`
+type AnonymizePurgeableUserParams struct {
+	ID       int32 `json:"id"`
+	TenantID int32 `json:"tenant_id"`
 }
 
-type CreateGroupRow struct {
-	ID     int32          `json:"id"`
-	Name   string         `json:"name"`
-	OktaID sql.NullString `json:"okta_id"`
+// Replaces the personal data of a deactivated user while keeping its row for
+// referential history.
+func (q *Queries) AnonymizePurgeableUser(ctx context.Context, arg AnonymizePurgeableUserParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, anonymizePurgeableUser, arg.ID, arg.TenantID)
+	var i Employee
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.OktaID,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
+	)
+	return i, err
+}
+
+const createGroup = `-- name: CreateGroup :one
+INSERT INTO OktaGroup (tenant_id, name, okta_id)
+VALUES ($3, $1, $2)
+RETURNING id, name, okta_id, created_at, updated_at, tenant_id
+`
+
+type CreateGroupParams struct {
+	Name     string         `json:"name"`
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
 }
 
-func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (CreateGroupRow, error) {
-	row := q.db.QueryRowContext(ctx, createGroup, arg.Name, arg.OktaID)
-	var i CreateGroupRow
-	err := row.Scan(&i.ID, &i.Name, &i.OktaID)
+func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (Oktagroup, error) {
+	row := q.db.QueryRowContext(ctx, createGroup, arg.Name, arg.OktaID, arg.TenantID)
+	var i Oktagroup
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
 	return i, err
 }
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO Employee (name,
+INSERT INTO Employee (tenant_id,
+                      name,
                       email,
-                      okta_id)
-VALUES ($1, $2, $3)
-RETURNING id, name, email, okta_id, active
+                      okta_id,
+                      extensions)
+VALUES ($5, $1, $2, $3, $4)
+RETURNING id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 `
 
 type CreateUserParams struct {
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	OktaID string `json:"okta_id"`
+	Name       string          `json:"name"`
+	Email      string          `json:"email"`
+	OktaID     string          `json:"okta_id"`
+	Extensions json.RawMessage `json:"extensions"`
+	TenantID   int32           `json:"tenant_id"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (Employee, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Name, arg.Email, arg.OktaID)
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.Name,
+		arg.Email,
+		arg.OktaID,
+		arg.Extensions,
+		arg.TenantID,
+	)
 	var i Employee
 	err := row.Scan(
 		&i.ID,
@@ -102,19 +149,33 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (Employe
 		&i.Email,
 		&i.OktaID,
 		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
 	)
 	return i, err
 }
 
 const deactivateUser = `-- name: DeactivateUser :one
 UPDATE Employee
-SET active = false
-WHERE okta_id = $1
-RETURNING id, name, email, okta_id, active
+SET active         = false,
+    deactivated_at = COALESCE(deactivated_at, now()),
+    updated_at     = now()
+WHERE tenant_id = $2
+  AND okta_id = $1
+RETURNING id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 `
 
-func (q *Queries) DeactivateUser(ctx context.Context, oktaID string) (Employee, error) {
-	row := q.db.QueryRowContext(ctx, deactivateUser, oktaID)
+type DeactivateUserParams struct {
+	OktaID   string `json:"okta_id"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) DeactivateUser(ctx context.Context, arg DeactivateUserParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, deactivateUser, arg.OktaID, arg.TenantID)
 	var i Employee
 	err := row.Scan(
 		&i.ID,
@@ -122,6 +183,12 @@ func (q *Queries) DeactivateUser(ctx context.Context, oktaID string) (Employee,
 		&i.Email,
 		&i.OktaID,
 		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
 	)
 	return i, err
 }
@@ -129,90 +196,310 @@ func (q *Queries) DeactivateUser(ctx context.Context, oktaID string) (Employee,
 const deleteGroup = `-- name: DeleteGroup :exec
 DELETE
 FROM OktaGroup
-WHERE okta_id = $1
+WHERE tenant_id = $2
+  AND okta_id = $1
 `
 
-func (q *Queries) DeleteGroup(ctx context.Context, oktaID sql.NullString) error {
-	_, err := q.db.ExecContext(ctx, deleteGroup, oktaID)
+type DeleteGroupParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteGroup(ctx context.Context, arg DeleteGroupParams) error {
+	_, err := q.db.ExecContext(ctx, deleteGroup, arg.OktaID, arg.TenantID)
 	return err
 }
 
 const deleteGroupMembers = `-- name: DeleteGroupMembers :exec
 DELETE
+FROM EmployeeOktaGroup eog
+WHERE eog.tenant_id = $2
+  AND eog.okta_group_id IN (SELECT g.id
+                            FROM OktaGroup g
+                            WHERE g.tenant_id = $2
+                              AND g.okta_id = $1)
+`
+
+type DeleteGroupMembersParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteGroupMembers(ctx context.Context, arg DeleteGroupMembersParams) error {
+	_, err := q.db.ExecContext(ctx, deleteGroupMembers, arg.OktaID, arg.TenantID)
+	return err
+}
+
+const deletePurgeableUser = `-- name: DeletePurgeableUser :execrows
+DELETE
+FROM Employee
+WHERE tenant_id = $2
+  AND id = $1
+  AND active = false
+`
+
+type DeletePurgeableUserParams struct {
+	ID       int32 `json:"id"`
+	TenantID int32 `json:"tenant_id"`
+}
+
+// Hard deletes a deactivated user. Memberships are removed by the foreign
+// key cascade.
+func (q *Queries) DeletePurgeableUser(ctx context.Context, arg DeletePurgeableUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePurgeableUser, arg.ID, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE
+FROM Employee
+WHERE tenant_id = $2
+  AND okta_id = $1
+`
+
+type DeleteUserParams struct {
+	OktaID   string `json:"okta_id"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteUser(ctx context.Context, arg DeleteUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, arg.OktaID, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserMemberships = `-- name: DeleteUserMemberships :exec
+DELETE
 FROM EmployeeOktaGroup
-WHERE okta_group_name IN (SELECT name
-                          FROM OktaGroup
-                          WHERE okta_id = $1)
+WHERE tenant_id = $2
+  AND employee_id = $1
 `
 
-func (q *Queries) DeleteGroupMembers(ctx context.Context, oktaID sql.NullString) error {
-	_, err := q.db.ExecContext(ctx, deleteGroupMembers, oktaID)
+type DeleteUserMembershipsParams struct {
+	EmployeeID int32 `json:"employee_id"`
+	TenantID   int32 `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteUserMemberships(ctx context.Context, arg DeleteUserMembershipsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteUserMemberships, arg.EmployeeID, arg.TenantID)
 	return err
 }
 
 const getGroupByID = `-- name: GetGroupByID :one
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON e.okta_id = eog.employee_id
-WHERE g.okta_id = $1
-GROUP BY g.name, g.okta_id
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = $2
+  AND g.okta_id = $1
+GROUP BY g.id
 `
 
+type GetGroupByIDParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
+}
+
 type GetGroupByIDRow struct {
-	GroupName   string          `json:"group_name"`
-	GroupOktaID sql.NullString  `json:"group_okta_id"`
-	Members     json.RawMessage `json:"members"`
+	GroupName    string          `json:"group_name"`
+	GroupOktaID  sql.NullString  `json:"group_okta_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Members      json.RawMessage `json:"members"`
+	MemberGroups json.RawMessage `json:"member_groups"`
 }
 
-func (q *Queries) GetGroupByID(ctx context.Context, oktaID sql.NullString) (GetGroupByIDRow, error) {
-	row := q.db.QueryRowContext(ctx, getGroupByID, oktaID)
+func (q *Queries) GetGroupByID(ctx context.Context, arg GetGroupByIDParams) (GetGroupByIDRow, error) {
+	row := q.db.QueryRowContext(ctx, getGroupByID, arg.OktaID, arg.TenantID)
 	var i GetGroupByIDRow
-	err := row.Scan(&i.GroupName, &i.GroupOktaID, &i.Members)
+	err := row.Scan(
+		&i.GroupName,
+		&i.GroupOktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Members,
+		&i.MemberGroups,
+	)
 	return i, err
 }
 
 const getGroupByName = `-- name: GetGroupByName :one
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON e.okta_id = eog.employee_id
-WHERE g.name = $1
-GROUP BY g.name, g.okta_id
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = $2
+  AND g.name = $1
+GROUP BY g.id
 `
 
+type GetGroupByNameParams struct {
+	Name     string `json:"name"`
+	TenantID int32  `json:"tenant_id"`
+}
+
 type GetGroupByNameRow struct {
-	GroupName   string          `json:"group_name"`
-	GroupOktaID sql.NullString  `json:"group_okta_id"`
-	Members     json.RawMessage `json:"members"`
+	GroupName    string          `json:"group_name"`
+	GroupOktaID  sql.NullString  `json:"group_okta_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Members      json.RawMessage `json:"members"`
+	MemberGroups json.RawMessage `json:"member_groups"`
 }
 
-func (q *Queries) GetGroupByName(ctx context.Context, name string) (GetGroupByNameRow, error) {
-	row := q.db.QueryRowContext(ctx, getGroupByName, name)
+func (q *Queries) GetGroupByName(ctx context.Context, arg GetGroupByNameParams) (GetGroupByNameRow, error) {
+	row := q.db.QueryRowContext(ctx, getGroupByName, arg.Name, arg.TenantID)
 	var i GetGroupByNameRow
-	err := row.Scan(&i.GroupName, &i.GroupOktaID, &i.Members)
+	err := row.Scan(
+		&i.GroupName,
+		&i.GroupOktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Members,
+		&i.MemberGroups,
+	)
+	return i, err
+}
+
+const getGroupByOktaID = `-- name: GetGroupByOktaID :one
+SELECT id, name, okta_id, created_at, updated_at, tenant_id
+FROM OktaGroup
+WHERE tenant_id = $2
+  AND okta_id = $1
+`
+
+type GetGroupByOktaIDParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
+}
+
+func (q *Queries) GetGroupByOktaID(ctx context.Context, arg GetGroupByOktaIDParams) (Oktagroup, error) {
+	row := q.db.QueryRowContext(ctx, getGroupByOktaID, arg.OktaID, arg.TenantID)
+	var i Oktagroup
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return i, err
+}
+
+const getGroupByOktaIDForUpdate = `-- name: GetGroupByOktaIDForUpdate :one
+SELECT id, name, okta_id, created_at, updated_at, tenant_id
+FROM OktaGroup
+WHERE tenant_id = $2
+  AND okta_id = $1
+FOR UPDATE
+`
+
+type GetGroupByOktaIDForUpdateParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	TenantID int32          `json:"tenant_id"`
+}
+
+// Locks the group until the end of the transaction, so that concurrent
+// changes to it and its members are applied one after the other
+func (q *Queries) GetGroupByOktaIDForUpdate(ctx context.Context, arg GetGroupByOktaIDForUpdateParams) (Oktagroup, error) {
+	row := q.db.QueryRowContext(ctx, getGroupByOktaIDForUpdate, arg.OktaID, arg.TenantID)
+	var i Oktagroup
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
 	return i, err
 }
 
+const getGroupMemberGroups = `-- name: GetGroupMemberGroups :many
+SELECT g.id, g.okta_id, g.name
+FROM OktaGroup g
+         INNER JOIN OktaGroupMemberGroup gm ON gm.member_group_id = g.id
+WHERE gm.tenant_id = $2
+  AND gm.group_id = $1
+`
+
+type GetGroupMemberGroupsParams struct {
+	GroupID  int32 `json:"group_id"`
+	TenantID int32 `json:"tenant_id"`
+}
+
+type GetGroupMemberGroupsRow struct {
+	ID     int32          `json:"id"`
+	OktaID sql.NullString `json:"okta_id"`
+	Name   string         `json:"name"`
+}
+
+func (q *Queries) GetGroupMemberGroups(ctx context.Context, arg GetGroupMemberGroupsParams) ([]GetGroupMemberGroupsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getGroupMemberGroups, arg.GroupID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGroupMemberGroupsRow
+	for rows.Next() {
+		var i GetGroupMemberGroupsRow
+		if err := rows.Scan(&i.ID, &i.OktaID, &i.Name); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getGroupMembers = `-- name: GetGroupMembers :many
-SELECT e.okta_id, e.email
+SELECT e.id, e.okta_id, e.email
 FROM Employee e
-         INNER JOIN EmployeeOktaGroup eog ON e.okta_id = eog.employee_id
-         INNER JOIN OktaGroup g ON eog.okta_group_name = g.name
-WHERE g.name = $1
+         INNER JOIN EmployeeOktaGroup eog ON eog.employee_id = e.id
+WHERE eog.tenant_id = $2
+  AND eog.okta_group_id = $1
 `
 
+type GetGroupMembersParams struct {
+	OktaGroupID int32 `json:"okta_group_id"`
+	TenantID    int32 `json:"tenant_id"`
+}
+
 type GetGroupMembersRow struct {
+	ID     int32  `json:"id"`
 	OktaID string `json:"okta_id"`
 	Email  string `json:"email"`
 }
 
-func (q *Queries) GetGroupMembers(ctx context.Context, name string) ([]GetGroupMembersRow, error) {
-	rows, err := q.db.QueryContext(ctx, getGroupMembers, name)
+func (q *Queries) GetGroupMembers(ctx context.Context, arg GetGroupMembersParams) ([]GetGroupMembersRow, error) {
+	rows, err := q.db.QueryContext(ctx, getGroupMembers, arg.OktaGroupID, arg.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -220,7 +507,49 @@ func (q *Queries) GetGroupMembers(ctx context.Context, name string) ([]GetGroupM
 	var items []GetGroupMembersRow
 	for rows.Next() {
 		var i GetGroupMembersRow
-		if err := rows.Scan(&i.OktaID, &i.Email); err != nil {
+		if err := rows.Scan(&i.ID, &i.OktaID, &i.Email); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGroupsByOktaIDs = `-- name: GetGroupsByOktaIDs :many
+SELECT id, name, okta_id, created_at, updated_at, tenant_id
+FROM OktaGroup
+WHERE tenant_id = $1
+  AND okta_id = ANY ($2::varchar[])
+`
+
+type GetGroupsByOktaIDsParams struct {
+	TenantID int32    `json:"tenant_id"`
+	OktaIds  []string `json:"okta_ids"`
+}
+
+func (q *Queries) GetGroupsByOktaIDs(ctx context.Context, arg GetGroupsByOktaIDsParams) ([]Oktagroup, error) {
+	rows, err := q.db.QueryContext(ctx, getGroupsByOktaIDs, arg.TenantID, pq.Array(arg.OktaIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Oktagroup
+	for rows.Next() {
+		var i Oktagroup
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OktaID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -235,19 +564,21 @@ func (q *Queries) GetGroupMembers(ctx context.Context, name string) ([]GetGroupM
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, name, email, okta_id, active
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 FROM Employee
-WHERE email = $1
+WHERE tenant_id = $3
+  AND email = $1
   AND active = $2
 `
 
 type GetUserByEmailParams struct {
-	Email  string `json:"email"`
-	Active bool   `json:"active"`
+	Email    string `json:"email"`
+	Active   bool   `json:"active"`
+	TenantID int32  `json:"tenant_id"`
 }
 
 func (q *Queries) GetUserByEmail(ctx context.Context, arg GetUserByEmailParams) (Employee, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, arg.Email, arg.Active)
+	row := q.db.QueryRowContext(ctx, getUserByEmail, arg.Email, arg.Active, arg.TenantID)
 	var i Employee
 	err := row.Scan(
 		&i.ID,
@@ -255,19 +586,96 @@ func (q *Queries) GetUserByEmail(ctx context.Context, arg GetUserByEmailParams)
 		&i.Email,
 		&i.OktaID,
 		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, okta_id, active
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 FROM Employee
-WHERE okta_id = $1
+WHERE tenant_id = $2
+  AND okta_id = $1
   AND active = true
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, oktaID string) (Employee, error) {
-	row := q.db.QueryRowContext(ctx, getUserByID, oktaID)
+type GetUserByIDParams struct {
+	OktaID   string `json:"okta_id"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, arg GetUserByIDParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, arg.OktaID, arg.TenantID)
+	var i Employee
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.OktaID,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
+	)
+	return i, err
+}
+
+const getUserByOktaID = `-- name: GetUserByOktaID :one
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $2
+  AND okta_id = $1
+`
+
+type GetUserByOktaIDParams struct {
+	OktaID   string `json:"okta_id"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) GetUserByOktaID(ctx context.Context, arg GetUserByOktaIDParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, getUserByOktaID, arg.OktaID, arg.TenantID)
+	var i Employee
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.OktaID,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
+	)
+	return i, err
+}
+
+const getUserByOktaIDForUpdate = `-- name: GetUserByOktaIDForUpdate :one
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $2
+  AND okta_id = $1
+FOR UPDATE
+`
+
+type GetUserByOktaIDForUpdateParams struct {
+	OktaID   string `json:"okta_id"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+// Locks the user until the end of the transaction, so that concurrent
+// changes to it are applied one after the other
+func (q *Queries) GetUserByOktaIDForUpdate(ctx context.Context, arg GetUserByOktaIDForUpdateParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, getUserByOktaIDForUpdate, arg.OktaID, arg.TenantID)
 	var i Employee
 	err := row.Scan(
 		&i.ID,
@@ -275,35 +683,282 @@ func (q *Queries) GetUserByID(ctx context.Context, oktaID string) (Employee, err
 		&i.Email,
 		&i.OktaID,
 		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
 	)
 	return i, err
 }
 
+const getUsersByOktaIDs = `-- name: GetUsersByOktaIDs :many
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $1
+  AND okta_id = ANY ($2::varchar[])
+`
+
+type GetUsersByOktaIDsParams struct {
+	TenantID int32    `json:"tenant_id"`
+	OktaIds  []string `json:"okta_ids"`
+}
+
+func (q *Queries) GetUsersByOktaIDs(ctx context.Context, arg GetUsersByOktaIDsParams) ([]Employee, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByOktaIDs, arg.TenantID, pq.Array(arg.OktaIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Employee
+	for rows.Next() {
+		var i Employee
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.OktaID,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeactivatedAt,
+			&i.PurgedAt,
+			&i.TenantID,
+			&i.Extensions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsersGroups = `-- name: GetUsersGroups :many
+WITH RECURSIVE user_groups AS (SELECT eog.employee_id, eog.okta_group_id AS group_id, true AS direct
+                               FROM EmployeeOktaGroup eog
+                               WHERE eog.tenant_id = $1
+                                 AND eog.employee_id = ANY ($2::integer[])
+                               UNION
+                               SELECT ug.employee_id, gm.group_id, false
+                               FROM user_groups ug
+                                        INNER JOIN OktaGroupMemberGroup gm ON gm.member_group_id = ug.group_id)
+SELECT ug.employee_id,
+       g.okta_id,
+       g.name,
+       bool_or(ug.direct)::boolean AS direct
+FROM user_groups ug
+         INNER JOIN OktaGroup g ON g.id = ug.group_id
+GROUP BY ug.employee_id, g.id
+ORDER BY ug.employee_id, g.name
+`
+
+type GetUsersGroupsParams struct {
+	TenantID    int32   `json:"tenant_id"`
+	EmployeeIds []int32 `json:"employee_ids"`
+}
+
+type GetUsersGroupsRow struct {
+	EmployeeID int32          `json:"employee_id"`
+	OktaID     sql.NullString `json:"okta_id"`
+	Name       string         `json:"name"`
+	Direct     bool           `json:"direct"`
+}
+
+// Resolves the direct and indirect (inherited through nested groups)
+// memberships of the given users.
+func (q *Queries) GetUsersGroups(ctx context.Context, arg GetUsersGroupsParams) ([]GetUsersGroupsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersGroups, arg.TenantID, pq.Array(arg.EmployeeIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUsersGroupsRow
+	for rows.Next() {
+		var i GetUsersGroupsRow
+		if err := rows.Scan(
+			&i.EmployeeID,
+			&i.OktaID,
+			&i.Name,
+			&i.Direct,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isGroupDescendant = `-- name: IsGroupDescendant :one
+WITH RECURSIVE descendants AS (SELECT $2::integer AS id
+                               UNION
+                               SELECT gm.member_group_id
+                               FROM OktaGroupMemberGroup gm
+                                        INNER JOIN descendants d ON gm.group_id = d.id
+                               WHERE gm.tenant_id = $3)
+SELECT EXISTS (SELECT 1
+               FROM descendants
+               WHERE id = $1::integer) AS is_descendant
+`
+
+type IsGroupDescendantParams struct {
+	DescendantID int32 `json:"descendant_id"`
+	AncestorID   int32 `json:"ancestor_id"`
+	TenantID     int32 `json:"tenant_id"`
+}
+
+// Reports whether descendant_id is the group ancestor_id itself or is nested
+// anywhere below it. Adding ancestor_id as a member of descendant_id would then
+// create a cycle.
+func (q *Queries) IsGroupDescendant(ctx context.Context, arg IsGroupDescendantParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isGroupDescendant, arg.DescendantID, arg.AncestorID, arg.TenantID)
+	var is_descendant bool
+	err := row.Scan(&is_descendant)
+	return is_descendant, err
+}
+
+const listAllGroups = `-- name: ListAllGroups :many
+SELECT id, name, okta_id, created_at, updated_at, tenant_id
+FROM OktaGroup
+WHERE tenant_id = $3
+ORDER BY id
+LIMIT $1 OFFSET $2
+`
+
+type ListAllGroupsParams struct {
+	Limit    int32 `json:"limit"`
+	Offset   int32 `json:"offset"`
+	TenantID int32 `json:"tenant_id"`
+}
+
+func (q *Queries) ListAllGroups(ctx context.Context, arg ListAllGroupsParams) ([]Oktagroup, error) {
+	rows, err := q.db.QueryContext(ctx, listAllGroups, arg.Limit, arg.Offset, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Oktagroup
+	for rows.Next() {
+		var i Oktagroup
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OktaID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAllUsers = `-- name: ListAllUsers :many
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $3
+ORDER BY id
+LIMIT $1 OFFSET $2
+`
+
+type ListAllUsersParams struct {
+	Limit    int32 `json:"limit"`
+	Offset   int32 `json:"offset"`
+	TenantID int32 `json:"tenant_id"`
+}
+
+// Lists active and deactivated users.
+func (q *Queries) ListAllUsers(ctx context.Context, arg ListAllUsersParams) ([]Employee, error) {
+	rows, err := q.db.QueryContext(ctx, listAllUsers, arg.Limit, arg.Offset, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Employee
+	for rows.Next() {
+		var i Employee
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.OktaID,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeactivatedAt,
+			&i.PurgedAt,
+			&i.TenantID,
+			&i.Extensions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listGroups = `-- name: ListGroups :many
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON eog.employee_id = e.okta_id
-GROUP BY g.name, g.okta_id
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = $3
+GROUP BY g.id
 ORDER BY g.name
 LIMIT $1 OFFSET $2
 `
 
 type ListGroupsParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+	Limit    int32 `json:"limit"`
+	Offset   int32 `json:"offset"`
+	TenantID int32 `json:"tenant_id"`
 }
 
 type ListGroupsRow struct {
-	GroupName   string          `json:"group_name"`
-	GroupOktaID sql.NullString  `json:"group_okta_id"`
-	Members     json.RawMessage `json:"members"`
+	GroupName    string          `json:"group_name"`
+	GroupOktaID  sql.NullString  `json:"group_okta_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Members      json.RawMessage `json:"members"`
+	MemberGroups json.RawMessage `json:"member_groups"`
 }
 
 func (q *Queries) ListGroups(ctx context.Context, arg ListGroupsParams) ([]ListGroupsRow, error) {
-	rows, err := q.db.QueryContext(ctx, listGroups, arg.Limit, arg.Offset)
+	rows, err := q.db.QueryContext(ctx, listGroups, arg.Limit, arg.Offset, arg.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +966,211 @@ func (q *Queries) ListGroups(ctx context.Context, arg ListGroupsParams) ([]ListG
 	var items []ListGroupsRow
 	for rows.Next() {
 		var i ListGroupsRow
-		if err := rows.Scan(&i.GroupName, &i.GroupOktaID, &i.Members); err != nil {
+		if err := rows.Scan(
+			&i.GroupName,
+			&i.GroupOktaID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Members,
+			&i.MemberGroups,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGroupsByMemberType = `-- name: ListGroupsByMemberType :many
+SELECT g.name                                                             AS group_name,
+       g.okta_id                                                          AS group_okta_id,
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
+FROM OktaGroup g
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = $3
+  AND (($4::text = 'Group' AND
+        EXISTS (SELECT 1 FROM OktaGroupMemberGroup gm WHERE gm.group_id = g.id))
+    OR ($4::text = 'User' AND
+        EXISTS (SELECT 1 FROM EmployeeOktaGroup ueog WHERE ueog.okta_group_id = g.id)))
+GROUP BY g.id
+ORDER BY g.name
+LIMIT $1 OFFSET $2
+`
+
+type ListGroupsByMemberTypeParams struct {
+	Limit      int32  `json:"limit"`
+	Offset     int32  `json:"offset"`
+	TenantID   int32  `json:"tenant_id"`
+	MemberType string `json:"member_type"`
+}
+
+type ListGroupsByMemberTypeRow struct {
+	GroupName    string          `json:"group_name"`
+	GroupOktaID  sql.NullString  `json:"group_okta_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Members      json.RawMessage `json:"members"`
+	MemberGroups json.RawMessage `json:"member_groups"`
+}
+
+func (q *Queries) ListGroupsByMemberType(ctx context.Context, arg ListGroupsByMemberTypeParams) ([]ListGroupsByMemberTypeRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGroupsByMemberType,
+		arg.Limit,
+		arg.Offset,
+		arg.TenantID,
+		arg.MemberType,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGroupsByMemberTypeRow
+	for rows.Next() {
+		var i ListGroupsByMemberTypeRow
+		if err := rows.Scan(
+			&i.GroupName,
+			&i.GroupOktaID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Members,
+			&i.MemberGroups,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGroupsModifiedSince = `-- name: ListGroupsModifiedSince :many
+SELECT g.name                                                             AS group_name,
+       g.okta_id                                                          AS group_okta_id,
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
+FROM OktaGroup g
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = $3
+  AND g.updated_at >= $4
+GROUP BY g.id
+ORDER BY g.updated_at, g.id
+LIMIT $1 OFFSET $2
+`
+
+type ListGroupsModifiedSinceParams struct {
+	Limit    int32     `json:"limit"`
+	Offset   int32     `json:"offset"`
+	TenantID int32     `json:"tenant_id"`
+	Since    time.Time `json:"since"`
+}
+
+type ListGroupsModifiedSinceRow struct {
+	GroupName    string          `json:"group_name"`
+	GroupOktaID  sql.NullString  `json:"group_okta_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Members      json.RawMessage `json:"members"`
+	MemberGroups json.RawMessage `json:"member_groups"`
+}
+
+func (q *Queries) ListGroupsModifiedSince(ctx context.Context, arg ListGroupsModifiedSinceParams) ([]ListGroupsModifiedSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGroupsModifiedSince,
+		arg.Limit,
+		arg.Offset,
+		arg.TenantID,
+		arg.Since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGroupsModifiedSinceRow
+	for rows.Next() {
+		var i ListGroupsModifiedSinceRow
+		if err := rows.Scan(
+			&i.GroupName,
+			&i.GroupOktaID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Members,
+			&i.MemberGroups,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPurgeableUsers = `-- name: ListPurgeableUsers :many
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $1
+  AND active = false
+  AND deactivated_at < $2::timestamptz
+ORDER BY id
+    FOR UPDATE
+`
+
+type ListPurgeableUsersParams struct {
+	TenantID int32     `json:"tenant_id"`
+	Cutoff   time.Time `json:"cutoff"`
+}
+
+// Lists the users of a tenant deactivated before the cutoff, locking them
+// until they are purged.
+func (q *Queries) ListPurgeableUsers(ctx context.Context, arg ListPurgeableUsersParams) ([]Employee, error) {
+	rows, err := q.db.QueryContext(ctx, listPurgeableUsers, arg.TenantID, arg.Cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Employee
+	for rows.Next() {
+		var i Employee
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.OktaID,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeactivatedAt,
+			&i.PurgedAt,
+			&i.TenantID,
+			&i.Extensions,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -326,20 +1185,22 @@ func (q *Queries) ListGroups(ctx context.Context, arg ListGroupsParams) ([]ListG
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT id, name, email, okta_id, active
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 FROM Employee
-WHERE active = true
+WHERE tenant_id = $3
+  AND active = true
 ORDER BY id
 LIMIT $1 OFFSET $2
 `
 
 type ListUsersParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+	Limit    int32 `json:"limit"`
+	Offset   int32 `json:"offset"`
+	TenantID int32 `json:"tenant_id"`
 }
 
 func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]Employee, error) {
-	rows, err := q.db.QueryContext(ctx, listUsers, arg.Limit, arg.Offset)
+	rows, err := q.db.QueryContext(ctx, listUsers, arg.Limit, arg.Offset, arg.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -353,6 +1214,12 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]Employe
 			&i.Email,
 			&i.OktaID,
 			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeactivatedAt,
+			&i.PurgedAt,
+			&i.TenantID,
+			&i.Extensions,
 		); err != nil {
 			return nil, err
 		}
@@ -367,86 +1234,217 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]Employe
 	return items, nil
 }
 
-const removeAllGroupMembers = `-- name: RemoveAllGroupMembers :exec
-DELETE
-FROM EmployeeOktaGroup
-WHERE okta_group_name = $1
+const listUsersModifiedSince = `-- name: ListUsersModifiedSince :many
+SELECT id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+FROM Employee
+WHERE tenant_id = $3
+  AND updated_at >= $4
+ORDER BY updated_at, id
+LIMIT $1 OFFSET $2
 `
 
-func (q *Queries) RemoveAllGroupMembers(ctx context.Context, oktaGroupName string) error {
-	_, err := q.db.ExecContext(ctx, removeAllGroupMembers, oktaGroupName)
-	return err
+type ListUsersModifiedSinceParams struct {
+	Limit    int32     `json:"limit"`
+	Offset   int32     `json:"offset"`
+	TenantID int32     `json:"tenant_id"`
+	Since    time.Time `json:"since"`
+}
+
+func (q *Queries) ListUsersModifiedSince(ctx context.Context, arg ListUsersModifiedSinceParams) ([]Employee, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersModifiedSince,
+		arg.Limit,
+		arg.Offset,
+		arg.TenantID,
+		arg.Since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Employee
+	for rows.Next() {
+		var i Employee
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.OktaID,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeactivatedAt,
+			&i.PurgedAt,
+			&i.TenantID,
+			&i.Extensions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const reactivateUser = `-- name: ReactivateUser :one
+UPDATE Employee
+SET okta_id        = $1,
+    name           = $2,
+    email          = $3,
+    extensions     = $4,
+    active         = true,
+    deactivated_at = NULL,
+    updated_at     = now()
+WHERE tenant_id = $5
+  AND id = $6
+RETURNING id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
+`
+
+type ReactivateUserParams struct {
+	OktaID     string          `json:"okta_id"`
+	Name       string          `json:"name"`
+	Email      string          `json:"email"`
+	Extensions json.RawMessage `json:"extensions"`
+	TenantID   int32           `json:"tenant_id"`
+	ID         int32           `json:"id"`
+}
+
+// Reactivates a deactivated user, taking the externalId it is created with
+// again, which Okta may have changed.
+func (q *Queries) ReactivateUser(ctx context.Context, arg ReactivateUserParams) (Employee, error) {
+	row := q.db.QueryRowContext(ctx, reactivateUser,
+		arg.OktaID,
+		arg.Name,
+		arg.Email,
+		arg.Extensions,
+		arg.TenantID,
+		arg.ID,
+	)
+	var i Employee
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.OktaID,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
+	)
+	return i, err
 }
 
 const removeGroupMember = `-- name: RemoveGroupMember :exec
 DELETE
 FROM EmployeeOktaGroup
-WHERE employee_id = $1
-  AND okta_group_name = $2
+WHERE tenant_id = $3
+  AND employee_id = $1
+  AND okta_group_id = $2
 `
 
 type RemoveGroupMemberParams struct {
-	EmployeeID    string `json:"employee_id"`
-	OktaGroupName string `json:"okta_group_name"`
+	EmployeeID  int32 `json:"employee_id"`
+	OktaGroupID int32 `json:"okta_group_id"`
+	TenantID    int32 `json:"tenant_id"`
 }
 
 func (q *Queries) RemoveGroupMember(ctx context.Context, arg RemoveGroupMemberParams) error {
-	_, err := q.db.ExecContext(ctx, removeGroupMember, arg.EmployeeID, arg.OktaGroupName)
+	_, err := q.db.ExecContext(ctx, removeGroupMember, arg.EmployeeID, arg.OktaGroupID, arg.TenantID)
 	return err
 }
 
-const updateGroupName = `-- name: UpdateGroupName :one
+const removeGroupMemberGroup = `-- name: RemoveGroupMemberGroup :exec
+DELETE
+FROM OktaGroupMemberGroup
+WHERE tenant_id = $3
+  AND group_id = $1
+  AND member_group_id = $2
+`
+
+type RemoveGroupMemberGroupParams struct {
+	GroupID       int32 `json:"group_id"`
+	MemberGroupID int32 `json:"member_group_id"`
+	TenantID      int32 `json:"tenant_id"`
+}
+
+func (q *Queries) RemoveGroupMemberGroup(ctx context.Context, arg RemoveGroupMemberGroupParams) error {
+	_, err := q.db.ExecContext(ctx, removeGroupMemberGroup, arg.GroupID, arg.MemberGroupID, arg.TenantID)
+	return err
+}
+
+const touchGroup = `-- name: TouchGroup :exec
 UPDATE OktaGroup
-SET name = $2
-WHERE okta_id = $1
-RETURNING id, name, okta_id
+SET updated_at = now()
+WHERE tenant_id = $2
+  AND id = $1
 `
 
-type UpdateGroupNameParams struct {
-	OktaID sql.NullString `json:"okta_id"`
-	Name   string         `json:"name"`
+type TouchGroupParams struct {
+	ID       int32 `json:"id"`
+	TenantID int32 `json:"tenant_id"`
 }
 
-func (q *Queries) UpdateGroupName(ctx context.Context, arg UpdateGroupNameParams) (Oktagroup, error) {
-	row := q.db.QueryRowContext(ctx, updateGroupName, arg.OktaID, arg.Name)
-	var i Oktagroup
-	err := row.Scan(&i.ID, &i.Name, &i.OktaID)
-	return i, err
+func (q *Queries) TouchGroup(ctx context.Context, arg TouchGroupParams) error {
+	_, err := q.db.ExecContext(ctx, touchGroup, arg.ID, arg.TenantID)
+	return err
 }
 
-const updateGroupOktaID = `-- name: UpdateGroupOktaID :one
+const updateGroupName = `-- name: UpdateGroupName :one
 UPDATE OktaGroup
-SET okta_id = $2
-WHERE name = $1
-RETURNING id, name, okta_id
+SET name       = $2,
+    updated_at = now()
+WHERE tenant_id = $3
+  AND okta_id = $1
+RETURNING id, name, okta_id, created_at, updated_at, tenant_id
 `
 
-type UpdateGroupOktaIDParams struct {
-	Name   string         `json:"name"`
-	OktaID sql.NullString `json:"okta_id"`
+type UpdateGroupNameParams struct {
+	OktaID   sql.NullString `json:"okta_id"`
+	Name     string         `json:"name"`
+	TenantID int32          `json:"tenant_id"`
 }
 
-func (q *Queries) UpdateGroupOktaID(ctx context.Context, arg UpdateGroupOktaIDParams) (Oktagroup, error) {
-	row := q.db.QueryRowContext(ctx, updateGroupOktaID, arg.Name, arg.OktaID)
+func (q *Queries) UpdateGroupName(ctx context.Context, arg UpdateGroupNameParams) (Oktagroup, error) {
+	row := q.db.QueryRowContext(ctx, updateGroupName, arg.OktaID, arg.Name, arg.TenantID)
 	var i Oktagroup
-	err := row.Scan(&i.ID, &i.Name, &i.OktaID)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OktaID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
 	return i, err
 }
 
 const updateUser = `-- name: UpdateUser :one
 UPDATE Employee
-SET name   = $2,
-    email  = $3,
-    active = $4
-WHERE okta_id = $1
-RETURNING id, name, email, okta_id, active
+SET name           = $2,
+    email          = $3,
+    active         = $4,
+    extensions     = $5,
+    deactivated_at = CASE WHEN $4::boolean THEN NULL ELSE COALESCE(deactivated_at, now()) END,
+    updated_at     = now()
+WHERE tenant_id = $6
+  AND okta_id = $1
+RETURNING id, name, email, okta_id, active, created_at, updated_at, deactivated_at, purged_at, tenant_id, extensions
 `
 
 type UpdateUserParams struct {
-	OktaID string `json:"okta_id"`
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Active bool   `json:"active"`
+	OktaID     string          `json:"okta_id"`
+	Name       string          `json:"name"`
+	Email      string          `json:"email"`
+	Active     bool            `json:"active"`
+	Extensions json.RawMessage `json:"extensions"`
+	TenantID   int32           `json:"tenant_id"`
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (Employee, error) {
@@ -455,6 +1453,8 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (Employe
 		arg.Name,
 		arg.Email,
 		arg.Active,
+		arg.Extensions,
+		arg.TenantID,
 	)
 	var i Employee
 	err := row.Scan(
@@ -463,6 +1463,12 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (Employe
 		&i.Email,
 		&i.OktaID,
 		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeactivatedAt,
+		&i.PurgedAt,
+		&i.TenantID,
+		&i.Extensions,
 	)
 	return i, err
 }
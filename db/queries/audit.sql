@@ -0,0 +1,23 @@
+-- name: CreateAuditLogEntry :exec
+INSERT INTO AuditLog (tenant_id,
+                      actor,
+                      operation,
+                      resource_type,
+                      resource_id,
+                      request_id,
+                      before,
+                      after,
+                      diff)
+VALUES (sqlc.arg(tenant_id), $1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListAuditLogEntries :many
+SELECT *
+FROM AuditLog
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (sqlc.narg(resource_type)::varchar IS NULL OR resource_type = sqlc.narg(resource_type))
+  AND (sqlc.narg(resource_id)::varchar IS NULL OR resource_id = sqlc.narg(resource_id))
+  AND (sqlc.narg(actor)::varchar IS NULL OR actor = sqlc.narg(actor))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR occurred_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR occurred_at < sqlc.narg(until))
+ORDER BY occurred_at DESC, id DESC
+LIMIT $1 OFFSET $2;
@@ -0,0 +1,40 @@
+-- name: CreateCredential :one
+INSERT INTO Credential (tenant_id, name, secret_hash, scopes)
+VALUES (sqlc.arg(tenant_id), $1, $2, $3)
+RETURNING *;
+
+-- name: GetActiveCredentialByName :one
+SELECT *
+FROM Credential
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1
+  AND revoked_at IS NULL;
+
+-- name: ListActiveCredentials :many
+SELECT *
+FROM Credential
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND revoked_at IS NULL
+ORDER BY name;
+
+-- name: RotateCredential :execrows
+UPDATE Credential
+SET secret_hash = $2,
+    rotated_at  = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1
+  AND revoked_at IS NULL;
+
+-- name: SetCredentialScopes :execrows
+UPDATE Credential
+SET scopes = $2
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeCredential :execrows
+UPDATE Credential
+SET revoked_at = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1
+  AND revoked_at IS NULL;
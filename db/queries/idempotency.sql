@@ -0,0 +1,43 @@
+-- Claims a key for a request, taking over keys that expired. No row is
+-- claimed while the key is in use.
+-- name: ClaimIdempotencyKey :execrows
+INSERT INTO IdempotencyKey (tenant_id, credential, key, request_hash)
+VALUES (sqlc.arg(tenant_id), sqlc.arg(credential), sqlc.arg(key), sqlc.arg(request_hash))
+ON CONFLICT (tenant_id, credential, key) DO UPDATE
+    SET request_hash = EXCLUDED.request_hash,
+        status       = NULL,
+        content_type = '',
+        location     = '',
+        body         = NULL,
+        created_at   = now()
+WHERE IdempotencyKey.created_at < sqlc.arg(expired_before);
+
+-- name: GetIdempotencyKey :one
+SELECT *
+FROM IdempotencyKey
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND credential = sqlc.arg(credential)
+  AND key = sqlc.arg(key);
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE IdempotencyKey
+SET status       = sqlc.arg(status),
+    content_type = sqlc.arg(content_type),
+    location     = sqlc.arg(location),
+    body         = sqlc.arg(body)
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND credential = sqlc.arg(credential)
+  AND key = sqlc.arg(key);
+
+-- Releases a key whose request failed, so that it can be retried
+-- name: ReleaseIdempotencyKey :exec
+DELETE
+FROM IdempotencyKey
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND credential = sqlc.arg(credential)
+  AND key = sqlc.arg(key);
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE
+FROM IdempotencyKey
+WHERE created_at < $1;
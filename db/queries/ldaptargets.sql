@@ -0,0 +1,29 @@
+-- name: GetLdapTargetEntry :one
+SELECT dn
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+  AND resource_type = $2
+  AND local_id = $3;
+
+-- name: SetLdapTargetEntry :exec
+INSERT INTO LdapTargetEntry (target, tenant_id, resource_type, local_id, dn)
+VALUES ($1, sqlc.arg(tenant_id), $2, $3, $4)
+ON CONFLICT (target, tenant_id, resource_type, local_id) DO UPDATE
+    SET dn         = EXCLUDED.dn,
+        updated_at = now();
+
+-- name: DeleteLdapTargetEntry :exec
+DELETE
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+  AND resource_type = $2
+  AND local_id = $3;
+
+-- name: ListLdapTargetEntries :many
+SELECT *
+FROM LdapTargetEntry
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+ORDER BY resource_type, local_id;
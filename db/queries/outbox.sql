@@ -0,0 +1,69 @@
+-- name: WriteOutboxEvent :exec
+INSERT INTO Outbox (tenant_id, event_id, event_type, payload)
+VALUES (sqlc.arg(tenant_id), $1, $2, $3);
+
+-- name: GetOutboxEvent :one
+SELECT *
+FROM Outbox
+WHERE id = $1;
+
+-- Lists the events following the position of a consumer. Events of
+-- transactions that are still running, or that started before one that is
+-- still running, are left for later so that none is skipped.
+-- name: ListOutboxEvents :many
+SELECT *
+FROM Outbox
+WHERE (txid, id) > (sqlc.arg(after_txid)::bigint, sqlc.arg(after_id)::bigint)
+  AND txid < txid_snapshot_xmin(txid_current_snapshot())
+ORDER BY txid, id
+LIMIT sqlc.arg(batch_size);
+
+-- name: EnsureOutboxConsumer :exec
+INSERT INTO OutboxConsumer (name)
+VALUES ($1)
+ON CONFLICT DO NOTHING;
+
+-- Locks a consumer for the rest of the transaction. No row is returned while
+-- another transaction holds the lock.
+-- name: LockOutboxConsumer :one
+SELECT *
+FROM OutboxConsumer
+WHERE name = $1
+    FOR UPDATE SKIP LOCKED;
+
+-- name: SetOutboxConsumerPosition :execrows
+UPDATE OutboxConsumer
+SET last_txid  = $2,
+    last_id    = $3,
+    updated_at = now()
+WHERE name = $1;
+
+-- name: ListOutboxConsumers :many
+SELECT OutboxConsumer.*,
+       (SELECT count(*)
+        FROM Outbox
+        WHERE (Outbox.txid, Outbox.id) > (OutboxConsumer.last_txid, OutboxConsumer.last_id))::bigint AS pending
+FROM OutboxConsumer
+ORDER BY name;
+
+-- Marks the events every one of the consumers published as delivered
+-- name: MarkOutboxDelivered :execrows
+UPDATE Outbox
+SET delivered_at = now()
+WHERE delivered_at IS NULL
+  AND NOT EXISTS (SELECT 1
+                  FROM OutboxConsumer
+                  WHERE OutboxConsumer.name = ANY (sqlc.arg(consumers)::varchar[])
+                    AND (Outbox.txid, Outbox.id) > (OutboxConsumer.last_txid, OutboxConsumer.last_id));
+
+-- Marks the events following a position pending again, so that they are
+-- kept until a sink rewound to the position published them again
+-- name: ResetOutboxDelivered :exec
+UPDATE Outbox
+SET delivered_at = NULL
+WHERE (txid, id) > (sqlc.arg(after_txid)::bigint, sqlc.arg(after_id)::bigint);
+
+-- name: DeleteDeliveredOutboxEvents :execrows
+DELETE
+FROM Outbox
+WHERE delivered_at < sqlc.arg(delivered_before)::timestamptz;
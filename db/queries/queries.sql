@@ -1,139 +1,379 @@
 -- name: GetUserByID :one
 SELECT *
 FROM Employee
-WHERE okta_id = $1
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
   AND active = true;
 
+-- name: GetUserByOktaID :one
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1;
+
+-- Locks the user until the end of the transaction, so that concurrent
+-- changes to it are applied one after the other
+-- name: GetUserByOktaIDForUpdate :one
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
+FOR UPDATE;
+
 -- name: ListUsers :many
 SELECT *
 FROM Employee
-WHERE active = true
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND active = true
 ORDER BY id
 LIMIT $1 OFFSET $2;
 
 -- name: GetUserByEmail :one
 SELECT *
 FROM Employee
-WHERE email = $1
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND email = $1
   AND active = $2;
 
 -- name: CreateUser :one
-INSERT INTO Employee (name,
+INSERT INTO Employee (tenant_id,
+                      name,
                       email,
-                      okta_id)
-VALUES ($1, $2, $3)
+                      okta_id,
+                      extensions)
+VALUES (sqlc.arg(tenant_id), $1, $2, $3, $4)
 RETURNING *;
 
+-- name: ListUsersModifiedSince :many
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND updated_at >= sqlc.arg(since)
+ORDER BY updated_at, id
+LIMIT $1 OFFSET $2;
+
 -- name: DeactivateUser :one
 UPDATE Employee
-SET active = false
-WHERE okta_id = $1
+SET active         = false,
+    deactivated_at = COALESCE(deactivated_at, now()),
+    updated_at     = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
 RETURNING *;
 
 -- name: UpdateUser :one
 UPDATE Employee
-SET name   = $2,
-    email  = $3,
-    active = $4
-WHERE okta_id = $1
+SET name           = $2,
+    email          = $3,
+    active         = $4,
+    extensions     = $5,
+    deactivated_at = CASE WHEN $4::boolean THEN NULL ELSE COALESCE(deactivated_at, now()) END,
+    updated_at     = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
+RETURNING *;
+
+-- name: ReactivateUser :one
+-- Reactivates a deactivated user, taking the externalId it is created with
+-- again, which Okta may have changed.
+UPDATE Employee
+SET okta_id        = sqlc.arg(okta_id),
+    name           = sqlc.arg(name),
+    email          = sqlc.arg(email),
+    extensions     = sqlc.arg(extensions),
+    active         = true,
+    deactivated_at = NULL,
+    updated_at     = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND id = sqlc.arg(id)
+RETURNING *;
+
+-- name: DeleteUser :execrows
+DELETE
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1;
+
+-- name: ListPurgeableUsers :many
+-- Lists the users of a tenant deactivated before the cutoff, locking them
+-- until they are purged.
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND active = false
+  AND deactivated_at < sqlc.arg(cutoff)::timestamptz
+ORDER BY id
+    FOR UPDATE;
+
+-- name: DeletePurgeableUser :execrows
+-- Hard deletes a deactivated user. Memberships are removed by the foreign
+-- key cascade.
+DELETE
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND id = $1
+  AND active = false;
+
+-- name: DeleteUserMemberships :exec
+DELETE
+FROM EmployeeOktaGroup
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND employee_id = $1;
+
+-- name: AnonymizePurgeableUser :one
+-- Replaces the personal data of a deactivated user while keeping its row for
+-- referential history.
+UPDATE Employee
+SET name       = 'Purged User',
+    email      = 'purged-' || id || '@invalid',
+    okta_id    = 'purged-' || id,
+    extensions = '{}',
+    purged_at  = now(),
+    updated_at = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND id = $1
+  AND active = false
 RETURNING *;
 
 -- name: ListGroups :many
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON eog.employee_id = e.okta_id
-GROUP BY g.name, g.okta_id
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = sqlc.arg(tenant_id)
+GROUP BY g.id
+ORDER BY g.name
+LIMIT $1 OFFSET $2;
+
+-- name: ListGroupsModifiedSince :many
+SELECT g.name                                                             AS group_name,
+       g.okta_id                                                          AS group_okta_id,
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
+FROM OktaGroup g
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = sqlc.arg(tenant_id)
+  AND g.updated_at >= sqlc.arg(since)
+GROUP BY g.id
+ORDER BY g.updated_at, g.id
+LIMIT $1 OFFSET $2;
+
+-- name: ListGroupsByMemberType :many
+SELECT g.name                                                             AS group_name,
+       g.okta_id                                                          AS group_okta_id,
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
+FROM OktaGroup g
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = sqlc.arg(tenant_id)
+  AND ((sqlc.arg(member_type)::text = 'Group' AND
+        EXISTS (SELECT 1 FROM OktaGroupMemberGroup gm WHERE gm.group_id = g.id))
+    OR (sqlc.arg(member_type)::text = 'User' AND
+        EXISTS (SELECT 1 FROM EmployeeOktaGroup ueog WHERE ueog.okta_group_id = g.id)))
+GROUP BY g.id
 ORDER BY g.name
 LIMIT $1 OFFSET $2;
 
 -- name: GetGroupByID :one
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON e.okta_id = eog.employee_id
-WHERE g.okta_id = $1
-GROUP BY g.name, g.okta_id;
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = sqlc.arg(tenant_id)
+  AND g.okta_id = $1
+GROUP BY g.id;
 
 -- name: GetGroupByName :one
 SELECT g.name                                                             AS group_name,
        g.okta_id                                                          AS group_okta_id,
-       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members
+       g.created_at,
+       g.updated_at,
+       json_agg(json_build_object('OktaID', e.okta_id, 'Email', e.email)) AS members,
+       (SELECT json_agg(json_build_object('OktaID', mg.okta_id, 'Name', mg.name))
+        FROM OktaGroupMemberGroup gm
+                 INNER JOIN OktaGroup mg ON mg.id = gm.member_group_id
+        WHERE gm.group_id = g.id)                                         AS member_groups
 FROM OktaGroup g
-         LEFT JOIN EmployeeOktaGroup eog ON g.name = eog.okta_group_name
-         LEFT JOIN Employee e ON e.okta_id = eog.employee_id
-WHERE g.name = $1
-GROUP BY g.name, g.okta_id;
+         LEFT JOIN EmployeeOktaGroup eog ON eog.okta_group_id = g.id
+         LEFT JOIN Employee e ON e.id = eog.employee_id
+WHERE g.tenant_id = sqlc.arg(tenant_id)
+  AND g.name = $1
+GROUP BY g.id;
 
 -- name: CreateGroup :one
-WITH inserted AS (
-    INSERT INTO OktaGroup (name, okta_id)
-        VALUES ($1, $2)
-        ON CONFLICT (name) DO NOTHING
-        RETURNING *)
-SELECT *
-FROM inserted
-UNION
+INSERT INTO OktaGroup (tenant_id, name, okta_id)
+VALUES (sqlc.arg(tenant_id), $1, $2)
+RETURNING *;
+
+-- name: GetGroupByOktaID :one
 SELECT *
 FROM OktaGroup
-WHERE name = $1;
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1;
 
--- name: CreateEmployeeOktaGroup :exec
-INSERT INTO EmployeeOktaGroup (employee_id, okta_group_name)
-SELECT e.id, og.id
-FROM Employee e,
-     OktaGroup og
-WHERE e.okta_id = $1
-  AND og.name = $2
-ON CONFLICT (employee_id, okta_group_name) DO NOTHING;
+-- Locks the group until the end of the transaction, so that concurrent
+-- changes to it and its members are applied one after the other
+-- name: GetGroupByOktaIDForUpdate :one
+SELECT *
+FROM OktaGroup
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
+FOR UPDATE;
 
 -- name: UpdateGroupName :one
 UPDATE OktaGroup
-SET name = $2
-WHERE okta_id = $1
+SET name       = $2,
+    updated_at = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1
 RETURNING *;
 
--- name: UpdateGroupOktaID :one
+-- name: TouchGroup :exec
 UPDATE OktaGroup
-SET okta_id = $2
-WHERE name = $1
-RETURNING *;
-
--- name: RemoveAllGroupMembers :exec
-DELETE
-FROM EmployeeOktaGroup
-WHERE okta_group_name = $1;
+SET updated_at = now()
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND id = $1;
 
 -- name: AddGroupMember :exec
-INSERT INTO EmployeeOktaGroup (employee_id, okta_group_name)
-VALUES ($1, $2)
-ON CONFLICT (employee_id, okta_group_name) DO NOTHING;
+-- The foreign keys on (tenant_id, employee_id) and (tenant_id, okta_group_id)
+-- reject memberships across tenants.
+INSERT INTO EmployeeOktaGroup (tenant_id, employee_id, okta_group_id)
+VALUES (sqlc.arg(tenant_id), $1, $2)
+ON CONFLICT (employee_id, okta_group_id) DO NOTHING;
 
 -- name: DeleteGroup :exec
 DELETE
 FROM OktaGroup
-WHERE okta_id = $1;
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = $1;
 
 -- name: DeleteGroupMembers :exec
 DELETE
-FROM EmployeeOktaGroup
-WHERE okta_group_name IN (SELECT name
-                          FROM OktaGroup
-                          WHERE okta_id = $1);
+FROM EmployeeOktaGroup eog
+WHERE eog.tenant_id = sqlc.arg(tenant_id)
+  AND eog.okta_group_id IN (SELECT g.id
+                            FROM OktaGroup g
+                            WHERE g.tenant_id = sqlc.arg(tenant_id)
+                              AND g.okta_id = $1);
 
 -- name: GetGroupMembers :many
-SELECT e.okta_id, e.email
+SELECT e.id, e.okta_id, e.email
 FROM Employee e
-         INNER JOIN EmployeeOktaGroup eog ON e.okta_id = eog.employee_id
-         INNER JOIN OktaGroup g ON eog.okta_group_name = g.name
-WHERE g.name = $1;
+         INNER JOIN EmployeeOktaGroup eog ON eog.employee_id = e.id
+WHERE eog.tenant_id = sqlc.arg(tenant_id)
+  AND eog.okta_group_id = $1;
 
 -- name: RemoveGroupMember :exec
 DELETE
 FROM EmployeeOktaGroup
-WHERE employee_id = $1
-  AND okta_group_name = $2;
\ No newline at end of file
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND employee_id = $1
+  AND okta_group_id = $2;
+
+-- name: GetUsersByOktaIDs :many
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = ANY (sqlc.arg(okta_ids)::varchar[]);
+
+-- name: GetGroupsByOktaIDs :many
+SELECT *
+FROM OktaGroup
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND okta_id = ANY (sqlc.arg(okta_ids)::varchar[]);
+
+-- name: GetGroupMemberGroups :many
+SELECT g.id, g.okta_id, g.name
+FROM OktaGroup g
+         INNER JOIN OktaGroupMemberGroup gm ON gm.member_group_id = g.id
+WHERE gm.tenant_id = sqlc.arg(tenant_id)
+  AND gm.group_id = $1;
+
+-- name: AddGroupMemberGroup :exec
+INSERT INTO OktaGroupMemberGroup (tenant_id, group_id, member_group_id)
+VALUES (sqlc.arg(tenant_id), $1, $2)
+ON CONFLICT (group_id, member_group_id) DO NOTHING;
+
+-- name: RemoveGroupMemberGroup :exec
+DELETE
+FROM OktaGroupMemberGroup
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND group_id = $1
+  AND member_group_id = $2;
+
+-- name: IsGroupDescendant :one
+-- Reports whether descendant_id is the group ancestor_id itself or is nested
+-- anywhere below it. Adding ancestor_id as a member of descendant_id would then
+-- create a cycle.
+WITH RECURSIVE descendants AS (SELECT sqlc.arg(ancestor_id)::integer AS id
+                               UNION
+                               SELECT gm.member_group_id
+                               FROM OktaGroupMemberGroup gm
+                                        INNER JOIN descendants d ON gm.group_id = d.id
+                               WHERE gm.tenant_id = sqlc.arg(tenant_id))
+SELECT EXISTS (SELECT 1
+               FROM descendants
+               WHERE id = sqlc.arg(descendant_id)::integer) AS is_descendant;
+
+-- name: GetUsersGroups :many
+-- Resolves the direct and indirect (inherited through nested groups)
+-- memberships of the given users.
+WITH RECURSIVE user_groups AS (SELECT eog.employee_id, eog.okta_group_id AS group_id, true AS direct
+                               FROM EmployeeOktaGroup eog
+                               WHERE eog.tenant_id = sqlc.arg(tenant_id)
+                                 AND eog.employee_id = ANY (sqlc.arg(employee_ids)::integer[])
+                               UNION
+                               SELECT ug.employee_id, gm.group_id, false
+                               FROM user_groups ug
+                                        INNER JOIN OktaGroupMemberGroup gm ON gm.member_group_id = ug.group_id)
+SELECT ug.employee_id,
+       g.okta_id,
+       g.name,
+       bool_or(ug.direct)::boolean AS direct
+FROM user_groups ug
+         INNER JOIN OktaGroup g ON g.id = ug.group_id
+GROUP BY ug.employee_id, g.id
+ORDER BY ug.employee_id, g.name;
+
+-- name: ListAllUsers :many
+-- Lists active and deactivated users.
+SELECT *
+FROM Employee
+WHERE tenant_id = sqlc.arg(tenant_id)
+ORDER BY id
+LIMIT $1 OFFSET $2;
+
+-- name: ListAllGroups :many
+SELECT *
+FROM OktaGroup
+WHERE tenant_id = sqlc.arg(tenant_id)
+ORDER BY id
+LIMIT $1 OFFSET $2;
@@ -0,0 +1,3 @@
+-- name: GetSchemaVersion :one
+SELECT COALESCE(MAX(version), 0)::int AS version
+FROM SchemaMigrations;
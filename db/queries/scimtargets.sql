@@ -0,0 +1,29 @@
+-- name: GetScimTargetResource :one
+SELECT remote_id
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+  AND resource_type = $2
+  AND local_id = $3;
+
+-- name: SetScimTargetResource :exec
+INSERT INTO ScimTargetResource (target, tenant_id, resource_type, local_id, remote_id)
+VALUES ($1, sqlc.arg(tenant_id), $2, $3, $4)
+ON CONFLICT (target, tenant_id, resource_type, local_id) DO UPDATE
+    SET remote_id  = EXCLUDED.remote_id,
+        updated_at = now();
+
+-- name: DeleteScimTargetResource :exec
+DELETE
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+  AND resource_type = $2
+  AND local_id = $3;
+
+-- name: ListScimTargetResources :many
+SELECT *
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = sqlc.arg(tenant_id)
+ORDER BY resource_type, local_id;
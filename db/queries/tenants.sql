@@ -0,0 +1,34 @@
+-- name: CreateTenant :one
+INSERT INTO Tenant (name, host, schema_extensions)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetTenantByName :one
+SELECT *
+FROM Tenant
+WHERE name = $1;
+
+-- name: GetTenantByHost :one
+SELECT *
+FROM Tenant
+WHERE host = $1;
+
+-- name: ListTenants :many
+SELECT *
+FROM Tenant
+ORDER BY name;
+
+-- name: UpdateTenant :one
+UPDATE Tenant
+SET host              = $2,
+    schema_extensions = $3
+WHERE name = $1
+RETURNING *;
+
+-- name: DeleteTenant :execrows
+-- Deletes a tenant with its users, groups and credentials. The default tenant
+-- cannot be deleted.
+DELETE
+FROM Tenant
+WHERE name = $1
+  AND id <> 1;
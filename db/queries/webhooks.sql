@@ -0,0 +1,120 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO WebhookEndpoint (tenant_id, name, url, secret, events)
+VALUES (sqlc.arg(tenant_id), $1, $2, $3, $4)
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT *
+FROM WebhookEndpoint
+WHERE tenant_id = sqlc.arg(tenant_id)
+ORDER BY name;
+
+-- name: CountWebhookEndpoints :one
+SELECT count(*)
+FROM WebhookEndpoint;
+
+-- name: RotateWebhookEndpointSecret :execrows
+UPDATE WebhookEndpoint
+SET secret = $2
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE
+FROM WebhookEndpoint
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND name = $1;
+
+-- Queues an event for delivery to every endpoint of the tenant subscribed to
+-- its type
+-- name: EnqueueWebhookEvent :exec
+INSERT INTO WebhookDelivery (endpoint_id, event_id, event_type, payload)
+SELECT id, sqlc.arg(event_id)::uuid, sqlc.arg(event_type)::varchar, sqlc.arg(payload)::jsonb
+FROM WebhookEndpoint
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (cardinality(events) = 0 OR sqlc.arg(event_type)::varchar = ANY (events));
+
+-- Claims the deliveries that are due in the order of the events, leasing them
+-- until lease_until so that other replicas skip them. Deliveries left behind
+-- by a replica that stopped are claimed again once the lease ends.
+-- name: ClaimWebhookDeliveries :many
+WITH claimed AS (
+    UPDATE WebhookDelivery
+        SET next_attempt_at = sqlc.arg(lease_until)
+        WHERE WebhookDelivery.id IN (SELECT pending.id
+                                     FROM WebhookDelivery pending
+                                     WHERE pending.delivered_at IS NULL
+                                       AND pending.failed_at IS NULL
+                                       AND pending.next_attempt_at <= now()
+                                     ORDER BY pending.id
+                                     LIMIT sqlc.arg(batch_size) FOR UPDATE SKIP LOCKED)
+        RETURNING WebhookDelivery.id, WebhookDelivery.endpoint_id, WebhookDelivery.event_id,
+            WebhookDelivery.event_type, WebhookDelivery.payload, WebhookDelivery.attempts)
+SELECT claimed.id,
+       claimed.event_id,
+       claimed.event_type,
+       claimed.payload,
+       claimed.attempts,
+       WebhookEndpoint.name AS endpoint,
+       WebhookEndpoint.url,
+       WebhookEndpoint.secret
+FROM claimed
+         JOIN WebhookEndpoint ON WebhookEndpoint.id = claimed.endpoint_id
+ORDER BY claimed.id;
+
+-- name: MarkWebhookDelivered :exec
+UPDATE WebhookDelivery
+SET attempts     = attempts + 1,
+    delivered_at = now(),
+    last_error   = NULL
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE WebhookDelivery
+SET attempts        = attempts + 1,
+    next_attempt_at = $2,
+    last_error      = $3
+WHERE id = $1;
+
+-- Moves a delivery that exhausted its attempts to the dead letters
+-- name: FailWebhookDelivery :exec
+UPDATE WebhookDelivery
+SET attempts   = attempts + 1,
+    failed_at  = now(),
+    last_error = $2
+WHERE id = $1;
+
+-- name: ListFailedWebhookDeliveries :many
+SELECT WebhookDelivery.id,
+       WebhookDelivery.event_id,
+       WebhookDelivery.event_type,
+       WebhookDelivery.attempts,
+       WebhookDelivery.last_error,
+       WebhookDelivery.created_at,
+       WebhookDelivery.failed_at,
+       WebhookEndpoint.name AS endpoint
+FROM WebhookDelivery
+         JOIN WebhookEndpoint ON WebhookEndpoint.id = WebhookDelivery.endpoint_id
+WHERE WebhookEndpoint.tenant_id = sqlc.arg(tenant_id)
+  AND WebhookDelivery.failed_at IS NOT NULL
+  AND (sqlc.narg(endpoint)::varchar IS NULL OR WebhookEndpoint.name = sqlc.narg(endpoint))
+ORDER BY WebhookDelivery.id;
+
+-- Queues dead letters for delivery again, all of those of the tenant or
+-- endpoint unless ids are given
+-- name: ReplayWebhookDeliveries :execrows
+UPDATE WebhookDelivery
+SET attempts        = 0,
+    next_attempt_at = now(),
+    failed_at       = NULL
+FROM WebhookEndpoint
+WHERE WebhookEndpoint.id = WebhookDelivery.endpoint_id
+  AND WebhookEndpoint.tenant_id = sqlc.arg(tenant_id)
+  AND WebhookDelivery.failed_at IS NOT NULL
+  AND (sqlc.narg(endpoint)::varchar IS NULL OR WebhookEndpoint.name = sqlc.narg(endpoint))
+  AND (cardinality(sqlc.arg(ids)::bigint[]) = 0 OR WebhookDelivery.id = ANY (sqlc.arg(ids)::bigint[]));
+
+-- name: DeleteDeliveredWebhookDeliveries :execrows
+DELETE
+FROM WebhookDelivery
+WHERE delivered_at < sqlc.arg(delivered_before)::timestamptz;
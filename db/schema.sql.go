@@ -0,0 +1,22 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: schema.sql
+
+package db
+
+import (
+	"context"
+)
+
+const getSchemaVersion = `-- name: GetSchemaVersion :one
+SELECT COALESCE(MAX(version), 0)::int AS version
+FROM SchemaMigrations
+`
+
+func (q *Queries) GetSchemaVersion(ctx context.Context) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getSchemaVersion)
+	var version int32
+	err := row.Scan(&version)
+	return version, err
+}
@@ -0,0 +1,134 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: scimtargets.sql
+
+package db
+
+import (
+	"context"
+)
+
+const deleteScimTargetResource = `-- name: DeleteScimTargetResource :exec
+DELETE
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = $4
+  AND resource_type = $2
+  AND local_id = $3
+`
+
+type DeleteScimTargetResourceParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteScimTargetResource(ctx context.Context, arg DeleteScimTargetResourceParams) error {
+	_, err := q.db.ExecContext(ctx, deleteScimTargetResource,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.TenantID,
+	)
+	return err
+}
+
+const getScimTargetResource = `-- name: GetScimTargetResource :one
+SELECT remote_id
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = $4
+  AND resource_type = $2
+  AND local_id = $3
+`
+
+type GetScimTargetResourceParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) GetScimTargetResource(ctx context.Context, arg GetScimTargetResourceParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, getScimTargetResource,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.TenantID,
+	)
+	var remote_id string
+	err := row.Scan(&remote_id)
+	return remote_id, err
+}
+
+const listScimTargetResources = `-- name: ListScimTargetResources :many
+SELECT target, tenant_id, resource_type, local_id, remote_id, updated_at
+FROM ScimTargetResource
+WHERE target = $1
+  AND tenant_id = $2
+ORDER BY resource_type, local_id
+`
+
+type ListScimTargetResourcesParams struct {
+	Target   string `json:"target"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) ListScimTargetResources(ctx context.Context, arg ListScimTargetResourcesParams) ([]Scimtargetresource, error) {
+	rows, err := q.db.QueryContext(ctx, listScimTargetResources, arg.Target, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Scimtargetresource
+	for rows.Next() {
+		var i Scimtargetresource
+		if err := rows.Scan(
+			&i.Target,
+			&i.TenantID,
+			&i.ResourceType,
+			&i.LocalID,
+			&i.RemoteID,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setScimTargetResource = `-- name: SetScimTargetResource :exec
+INSERT INTO ScimTargetResource (target, tenant_id, resource_type, local_id, remote_id)
+VALUES ($1, $5, $2, $3, $4)
+ON CONFLICT (target, tenant_id, resource_type, local_id) DO UPDATE
+    SET remote_id  = EXCLUDED.remote_id,
+        updated_at = now()
+`
+
+type SetScimTargetResourceParams struct {
+	Target       string `json:"target"`
+	ResourceType string `json:"resource_type"`
+	LocalID      string `json:"local_id"`
+	RemoteID     string `json:"remote_id"`
+	TenantID     int32  `json:"tenant_id"`
+}
+
+func (q *Queries) SetScimTargetResource(ctx context.Context, arg SetScimTargetResourceParams) error {
+	_, err := q.db.ExecContext(ctx, setScimTargetResource,
+		arg.Target,
+		arg.ResourceType,
+		arg.LocalID,
+		arg.RemoteID,
+		arg.TenantID,
+	)
+	return err
+}
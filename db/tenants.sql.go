@@ -0,0 +1,155 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: tenants.sql
+
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const createTenant = `-- name: CreateTenant :one
+INSERT INTO Tenant (name, host, schema_extensions)
+VALUES ($1, $2, $3)
+RETURNING id, name, host, schema_extensions, created_at
+`
+
+type CreateTenantParams struct {
+	Name             string         `json:"name"`
+	Host             sql.NullString `json:"host"`
+	SchemaExtensions []string       `json:"schema_extensions"`
+}
+
+func (q *Queries) CreateTenant(ctx context.Context, arg CreateTenantParams) (Tenant, error) {
+	row := q.db.QueryRowContext(ctx, createTenant, arg.Name, arg.Host, pq.Array(arg.SchemaExtensions))
+	var i Tenant
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Host,
+		pq.Array(&i.SchemaExtensions),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteTenant = `-- name: DeleteTenant :execrows
+DELETE
+FROM Tenant
+WHERE name = $1
+  AND id <> 1
+`
+
+// Deletes a tenant with its users, groups and credentials. The default tenant
+// cannot be deleted.
+func (q *Queries) DeleteTenant(ctx context.Context, name string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTenant, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getTenantByHost = `-- name: GetTenantByHost :one
+SELECT id, name, host, schema_extensions, created_at
+FROM Tenant
+WHERE host = $1
+`
+
+func (q *Queries) GetTenantByHost(ctx context.Context, host sql.NullString) (Tenant, error) {
+	row := q.db.QueryRowContext(ctx, getTenantByHost, host)
+	var i Tenant
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Host,
+		pq.Array(&i.SchemaExtensions),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getTenantByName = `-- name: GetTenantByName :one
+SELECT id, name, host, schema_extensions, created_at
+FROM Tenant
+WHERE name = $1
+`
+
+func (q *Queries) GetTenantByName(ctx context.Context, name string) (Tenant, error) {
+	row := q.db.QueryRowContext(ctx, getTenantByName, name)
+	var i Tenant
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Host,
+		pq.Array(&i.SchemaExtensions),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listTenants = `-- name: ListTenants :many
+SELECT id, name, host, schema_extensions, created_at
+FROM Tenant
+ORDER BY name
+`
+
+func (q *Queries) ListTenants(ctx context.Context) ([]Tenant, error) {
+	rows, err := q.db.QueryContext(ctx, listTenants)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Tenant
+	for rows.Next() {
+		var i Tenant
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Host,
+			pq.Array(&i.SchemaExtensions),
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateTenant = `-- name: UpdateTenant :one
+UPDATE Tenant
+SET host              = $2,
+    schema_extensions = $3
+WHERE name = $1
+RETURNING id, name, host, schema_extensions, created_at
+`
+
+type UpdateTenantParams struct {
+	Name             string         `json:"name"`
+	Host             sql.NullString `json:"host"`
+	SchemaExtensions []string       `json:"schema_extensions"`
+}
+
+func (q *Queries) UpdateTenant(ctx context.Context, arg UpdateTenantParams) (Tenant, error) {
+	row := q.db.QueryRowContext(ctx, updateTenant, arg.Name, arg.Host, pq.Array(arg.SchemaExtensions))
+	var i Tenant
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Host,
+		pq.Array(&i.SchemaExtensions),
+		&i.CreatedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,405 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: webhooks.sql
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+WITH claimed AS (
+    UPDATE WebhookDelivery
+        SET next_attempt_at = $1
+        WHERE WebhookDelivery.id IN (SELECT pending.id
+                                     FROM WebhookDelivery pending
+                                     WHERE pending.delivered_at IS NULL
+                                       AND pending.failed_at IS NULL
+                                       AND pending.next_attempt_at <= now()
+                                     ORDER BY pending.id
+                                     LIMIT $2 FOR UPDATE SKIP LOCKED)
+        RETURNING WebhookDelivery.id, WebhookDelivery.endpoint_id, WebhookDelivery.event_id,
+            WebhookDelivery.event_type, WebhookDelivery.payload, WebhookDelivery.attempts)
+SELECT claimed.id,
+       claimed.event_id,
+       claimed.event_type,
+       claimed.payload,
+       claimed.attempts,
+       WebhookEndpoint.name AS endpoint,
+       WebhookEndpoint.url,
+       WebhookEndpoint.secret
+FROM claimed
+         JOIN WebhookEndpoint ON WebhookEndpoint.id = claimed.endpoint_id
+ORDER BY claimed.id
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LeaseUntil time.Time `json:"lease_until"`
+	BatchSize  int32     `json:"batch_size"`
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	ID        int64           `json:"id"`
+	EventID   uuid.UUID       `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int32           `json:"attempts"`
+	Endpoint  string          `json:"endpoint"`
+	Url       string          `json:"url"`
+	Secret    string          `json:"secret"`
+}
+
+// Claims the deliveries that are due in the order of the events, leasing them
+// until lease_until so that other replicas skip them. Deliveries left behind
+// by a replica that stopped are claimed again once the lease ends.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, claimWebhookDeliveries, arg.LeaseUntil, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Attempts,
+			&i.Endpoint,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countWebhookEndpoints = `-- name: CountWebhookEndpoints :one
+SELECT count(*)
+FROM WebhookEndpoint
+`
+
+func (q *Queries) CountWebhookEndpoints(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countWebhookEndpoints)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createWebhookEndpoint = `-- name: CreateWebhookEndpoint :one
+INSERT INTO WebhookEndpoint (tenant_id, name, url, secret, events)
+VALUES ($5, $1, $2, $3, $4)
+RETURNING id, tenant_id, name, url, secret, events, created_at
+`
+
+type CreateWebhookEndpointParams struct {
+	Name     string   `json:"name"`
+	Url      string   `json:"url"`
+	Secret   string   `json:"secret"`
+	Events   []string `json:"events"`
+	TenantID int32    `json:"tenant_id"`
+}
+
+func (q *Queries) CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (Webhookendpoint, error) {
+	row := q.db.QueryRowContext(ctx, createWebhookEndpoint,
+		arg.Name,
+		arg.Url,
+		arg.Secret,
+		pq.Array(arg.Events),
+		arg.TenantID,
+	)
+	var i Webhookendpoint
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.Name,
+		&i.Url,
+		&i.Secret,
+		pq.Array(&i.Events),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteDeliveredWebhookDeliveries = `-- name: DeleteDeliveredWebhookDeliveries :execrows
+DELETE
+FROM WebhookDelivery
+WHERE delivered_at < $1::timestamptz
+`
+
+func (q *Queries) DeleteDeliveredWebhookDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteDeliveredWebhookDeliveries, deliveredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :execrows
+DELETE
+FROM WebhookEndpoint
+WHERE tenant_id = $2
+  AND name = $1
+`
+
+type DeleteWebhookEndpointParams struct {
+	Name     string `json:"name"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) DeleteWebhookEndpoint(ctx context.Context, arg DeleteWebhookEndpointParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWebhookEndpoint, arg.Name, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueWebhookEvent = `-- name: EnqueueWebhookEvent :exec
+INSERT INTO WebhookDelivery (endpoint_id, event_id, event_type, payload)
+SELECT id, $1::uuid, $2::varchar, $3::jsonb
+FROM WebhookEndpoint
+WHERE tenant_id = $4
+  AND (cardinality(events) = 0 OR $2::varchar = ANY (events))
+`
+
+type EnqueueWebhookEventParams struct {
+	EventID   uuid.UUID       `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	TenantID  int32           `json:"tenant_id"`
+}
+
+// Queues an event for delivery to every endpoint of the tenant subscribed to
+// its type
+func (q *Queries) EnqueueWebhookEvent(ctx context.Context, arg EnqueueWebhookEventParams) error {
+	_, err := q.db.ExecContext(ctx, enqueueWebhookEvent,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+		arg.TenantID,
+	)
+	return err
+}
+
+const failWebhookDelivery = `-- name: FailWebhookDelivery :exec
+UPDATE WebhookDelivery
+SET attempts   = attempts + 1,
+    failed_at  = now(),
+    last_error = $2
+WHERE id = $1
+`
+
+type FailWebhookDeliveryParams struct {
+	ID        int64          `json:"id"`
+	LastError sql.NullString `json:"last_error"`
+}
+
+// Moves a delivery that exhausted its attempts to the dead letters
+func (q *Queries) FailWebhookDelivery(ctx context.Context, arg FailWebhookDeliveryParams) error {
+	_, err := q.db.ExecContext(ctx, failWebhookDelivery, arg.ID, arg.LastError)
+	return err
+}
+
+const listFailedWebhookDeliveries = `-- name: ListFailedWebhookDeliveries :many
+SELECT WebhookDelivery.id,
+       WebhookDelivery.event_id,
+       WebhookDelivery.event_type,
+       WebhookDelivery.attempts,
+       WebhookDelivery.last_error,
+       WebhookDelivery.created_at,
+       WebhookDelivery.failed_at,
+       WebhookEndpoint.name AS endpoint
+FROM WebhookDelivery
+         JOIN WebhookEndpoint ON WebhookEndpoint.id = WebhookDelivery.endpoint_id
+WHERE WebhookEndpoint.tenant_id = $1
+  AND WebhookDelivery.failed_at IS NOT NULL
+  AND ($2::varchar IS NULL OR WebhookEndpoint.name = $2)
+ORDER BY WebhookDelivery.id
+`
+
+type ListFailedWebhookDeliveriesParams struct {
+	TenantID int32          `json:"tenant_id"`
+	Endpoint sql.NullString `json:"endpoint"`
+}
+
+type ListFailedWebhookDeliveriesRow struct {
+	ID        int64          `json:"id"`
+	EventID   uuid.UUID      `json:"event_id"`
+	EventType string         `json:"event_type"`
+	Attempts  int32          `json:"attempts"`
+	LastError sql.NullString `json:"last_error"`
+	CreatedAt time.Time      `json:"created_at"`
+	FailedAt  sql.NullTime   `json:"failed_at"`
+	Endpoint  string         `json:"endpoint"`
+}
+
+func (q *Queries) ListFailedWebhookDeliveries(ctx context.Context, arg ListFailedWebhookDeliveriesParams) ([]ListFailedWebhookDeliveriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFailedWebhookDeliveries, arg.TenantID, arg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFailedWebhookDeliveriesRow
+	for rows.Next() {
+		var i ListFailedWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.EventType,
+			&i.Attempts,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.FailedAt,
+			&i.Endpoint,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEndpoints = `-- name: ListWebhookEndpoints :many
+SELECT id, tenant_id, name, url, secret, events, created_at
+FROM WebhookEndpoint
+WHERE tenant_id = $1
+ORDER BY name
+`
+
+func (q *Queries) ListWebhookEndpoints(ctx context.Context, tenantID int32) ([]Webhookendpoint, error) {
+	rows, err := q.db.QueryContext(ctx, listWebhookEndpoints, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhookendpoint
+	for rows.Next() {
+		var i Webhookendpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.Name,
+			&i.Url,
+			&i.Secret,
+			pq.Array(&i.Events),
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markWebhookDelivered = `-- name: MarkWebhookDelivered :exec
+UPDATE WebhookDelivery
+SET attempts     = attempts + 1,
+    delivered_at = now(),
+    last_error   = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkWebhookDelivered(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markWebhookDelivered, id)
+	return err
+}
+
+const replayWebhookDeliveries = `-- name: ReplayWebhookDeliveries :execrows
+UPDATE WebhookDelivery
+SET attempts        = 0,
+    next_attempt_at = now(),
+    failed_at       = NULL
+FROM WebhookEndpoint
+WHERE WebhookEndpoint.id = WebhookDelivery.endpoint_id
+  AND WebhookEndpoint.tenant_id = $1
+  AND WebhookDelivery.failed_at IS NOT NULL
+  AND ($2::varchar IS NULL OR WebhookEndpoint.name = $2)
+  AND (cardinality($3::bigint[]) = 0 OR WebhookDelivery.id = ANY ($3::bigint[]))
+`
+
+type ReplayWebhookDeliveriesParams struct {
+	TenantID int32          `json:"tenant_id"`
+	Endpoint sql.NullString `json:"endpoint"`
+	Ids      []int64        `json:"ids"`
+}
+
+// Queues dead letters for delivery again, all of those of the tenant or
+// endpoint unless ids are given
+func (q *Queries) ReplayWebhookDeliveries(ctx context.Context, arg ReplayWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, replayWebhookDeliveries, arg.TenantID, arg.Endpoint, pq.Array(arg.Ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retryWebhookDelivery = `-- name: RetryWebhookDelivery :exec
+UPDATE WebhookDelivery
+SET attempts        = attempts + 1,
+    next_attempt_at = $2,
+    last_error      = $3
+WHERE id = $1
+`
+
+type RetryWebhookDeliveryParams struct {
+	ID            int64          `json:"id"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     sql.NullString `json:"last_error"`
+}
+
+func (q *Queries) RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) error {
+	_, err := q.db.ExecContext(ctx, retryWebhookDelivery, arg.ID, arg.NextAttemptAt, arg.LastError)
+	return err
+}
+
+const rotateWebhookEndpointSecret = `-- name: RotateWebhookEndpointSecret :execrows
+UPDATE WebhookEndpoint
+SET secret = $2
+WHERE tenant_id = $3
+  AND name = $1
+`
+
+type RotateWebhookEndpointSecretParams struct {
+	Name     string `json:"name"`
+	Secret   string `json:"secret"`
+	TenantID int32  `json:"tenant_id"`
+}
+
+func (q *Queries) RotateWebhookEndpointSecret(ctx context.Context, arg RotateWebhookEndpointSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateWebhookEndpointSecret, arg.Name, arg.Secret, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
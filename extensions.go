@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// decodeUserRequest decodes the user in the body of r into req and returns
+// its attributes, and the attributes of the schema extensions enabled for the
+// tenant, keyed by extension URN, as stored in the extensions column.
+// Attributes of other extensions are not stored.
+func decodeUserRequest(r *http.Request, req interface{}) (map[string]interface{}, json.RawMessage, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resource, err := decodeResource(body, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(body, &attributes); err != nil {
+		return nil, nil, err
+	}
+
+	extensions := make(map[string]json.RawMessage)
+	for _, urn := range tenantFromContext(r.Context()).SchemaExtensions {
+		if value, ok := attributes[urn]; ok && len(value) > 0 && value[0] == '{' {
+			extensions[urn] = value
+		}
+	}
+	stored, err := json.Marshal(extensions)
+	return resource, stored, err
+}
+
+// enabledExtensions returns the extension attributes stored for a user that
+// belong to the given enabled extensions.
+func enabledExtensions(stored map[string]json.RawMessage, enabled []string) map[string]json.RawMessage {
+	extensions := make(map[string]json.RawMessage)
+	for urn, value := range stored {
+		if slices.Contains(enabled, urn) {
+			extensions[urn] = value
+		}
+	}
+	return extensions
+}
+
+// MarshalJSON adds the schema extension attributes of the user alongside the
+// core attributes, and their URNs to schemas.
+func (u SCIMUser) MarshalJSON() ([]byte, error) {
+	type scimUser SCIMUser
+	if len(u.Extensions) == 0 {
+		return json.Marshal(scimUser(u))
+	}
+
+	urns := make([]string, 0, len(u.Extensions))
+	for urn := range u.Extensions {
+		urns = append(urns, urn)
+	}
+	sort.Strings(urns)
+	u.Schemas = append(slices.Clip(u.Schemas), urns...)
+
+	core, err := json.Marshal(scimUser(u))
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(core, &attributes); err != nil {
+		return nil, err
+	}
+	for urn, value := range u.Extensions {
+		attributes[urn] = value
+	}
+	return json.Marshal(attributes)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var lastModifiedFilterPattern = regexp.MustCompile(`^meta\.lastModified\s+(gt|ge)\s+"([^"]+)"$`)
+
+// parseLastModifiedFilter parses filters of the form
+// `meta.lastModified gt "2024-01-02T15:04:05Z"` used for incremental sync.
+// ok is false when the filter is not a meta.lastModified filter. The returned
+// time is inclusive: a "gt" filter is converted to "ge" one microsecond later,
+// which is the precision of PostgreSQL timestamps.
+func parseLastModifiedFilter(filter string) (since time.Time, ok bool, err error) {
+	matches := lastModifiedFilterPattern.FindStringSubmatch(filter)
+	if matches == nil {
+		return time.Time{}, false, nil
+	}
+
+	since, err = time.Parse(time.RFC3339Nano, matches[2])
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("invalid meta.lastModified value %q", matches[2])
+	}
+
+	if matches[1] == "gt" {
+		since = since.Truncate(time.Microsecond).Add(time.Microsecond)
+	}
+	return since, true, nil
+}
+
+var memberTypeFilterPattern = regexp.MustCompile(`^members\[type\s+eq\s+"(User|Group)"\]$`)
+
+// parseMemberTypeFilter parses value path filters of the form
+// `members[type eq "Group"]`, which select groups having at least one member
+// of the given type.
+func parseMemberTypeFilter(filter string) (memberType string, ok bool) {
+	matches := memberTypeFilterPattern.FindStringSubmatch(filter)
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
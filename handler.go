@@ -1,34 +1,47 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httptest"
 	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
-	"main/db"
+	"okta-scim/db"
 )
 
 type handler struct {
 	username   string
 	password   string
-	logger     *log.Logger
+	logger     *slog.Logger
 	db         *db.Queries
 	dbConn     *sql.DB
-	oktaClient *oktaClient
+	oktaClient *lazyOktaClient
+	locator    *resourceLocator
+	lifecycle  userLifecyclePolicy
+	accessLog  accessLogConfig
+	maxBody    int64
+	limiter    *requestLimiter
+
+	idempotency *idempotencyKeys
+
+	certCredentials *certCredentials
+	// credentialScopes restricts the scopes of the configured and client
+	// certificate credentials, see parseCredentialScopes.
+	credentialScopes map[string][]string
+	// features are the optional features that are enabled, by name.
+	features map[string]bool
+	// mapping maps SCIM attributes to the stored columns and back.
+	mapping *attributeMapping
 }
 
-func NewHandler(username, password string, logger *log.Logger, db *db.Queries, dbConn *sql.DB, oktaClient *oktaClient) *handler {
+func NewHandler(username, password string, logger *slog.Logger, db *db.Queries, dbConn *sql.DB, oktaClient *lazyOktaClient, locator *resourceLocator, lifecycle userLifecyclePolicy, accessLog accessLogConfig, maxBodyBytes int64, limiter *requestLimiter, idempotency *idempotencyKeys, certCredentials *certCredentials, credentialScopes map[string][]string, features map[string]bool, mapping *attributeMapping) *handler {
 	return &handler{
 		username:   username,
 		password:   password,
@@ -36,69 +49,119 @@ func NewHandler(username, password string, logger *log.Logger, db *db.Queries, d
 		db:         db,
 		dbConn:     dbConn,
 		oktaClient: oktaClient,
+		locator:    locator,
+		lifecycle:  lifecycle,
+		accessLog:  accessLog,
+		maxBody:    maxBodyBytes,
+		limiter:    limiter,
+
+		idempotency: idempotency,
+
+		certCredentials:  certCredentials,
+		credentialScopes: credentialScopes,
+		features:         features,
+		mapping:          mapping,
 	}
 }
 
-func (h *handler) applyMiddlewares(handle httprouter.Handle) httprouter.Handle {
-	return h.basicAuth(h.loggingMiddleware(handle))
+// applyMiddlewares wraps the handler of an endpoint that requires scope.
+func (h *handler) applyMiddlewares(scope string, handle httprouter.Handle) httprouter.Handle {
+	return h.requestID(h.limitConcurrency(h.resolveTenant(h.authenticate(h.loggingMiddleware(h.authorize(scope, h.limitRate(scope, h.limitRequestBody(handle))))))))
 }
 
-func (h *handler) basicAuth(handle httprouter.Handle) httprouter.Handle {
+// authenticate identifies the caller by its verified client certificate when
+// it maps to a credential, and by basic authentication otherwise, against the
+// configured username and password or the credentials of the tenant stored in
+// the database. Client certificates and the configured username and password
+// are credentials of the default tenant. The scopes of the credential are
+// added to the request context.
+func (h *handler) authenticate(handle httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		user, pass, ok := r.BasicAuth()
-		if !ok || user != h.username || pass != h.password {
+		defaultTenant := tenantIDFromContext(r.Context()) == defaultTenantID
+
+		var credential string
+		var scopes []string
+		var ok bool
+		if defaultTenant {
+			credential, ok = h.clientCertCredential(r)
+			scopes = h.configuredScopes(credential)
+		}
+		if user, pass, basicOK := r.BasicAuth(); !ok && basicOK {
+			if defaultTenant && h.username != "" && user == h.username && pass == h.password {
+				credential, scopes, ok = user, h.configuredScopes(user), true
+			} else {
+				stored, valid, err := h.storedCredential(r.Context(), user, pass)
+				if err != nil {
+					h.logger.Error("Error checking credential", "error", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				credential, scopes, ok = user, stored, valid
+			}
+		}
+		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		handle(w, r, ps)
+		ctx := context.WithValue(r.Context(), credentialKey, credential)
+		handle(w, r.WithContext(context.WithValue(ctx, scopesKey, scopes)), ps)
 	}
 }
 
+// clientCertCredential returns the credential the verified client
+// certificate of the request maps to.
+func (h *handler) clientCertCredential(r *http.Request) (string, bool) {
+	if h.certCredentials == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return h.certCredentials.credential(r.TLS.VerifiedChains[0][0])
+}
+
 func (h *handler) loggingMiddleware(handle httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if h.accessLog.Mode == accessLogOff {
+			handle(w, r, ps)
+			return
+		}
+
 		// Save the current time to calculate the request duration later
 		startTime := time.Now()
 
-		// Read the request body
-		bodyBytes, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			h.logger.Printf("Error reading request body: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		// Capture the request body as the handler reads it when it is logged
+		var body *cappedBuffer
+		if h.accessLog.Mode == accessLogRedactedBody {
+			body = &cappedBuffer{limit: h.maxBody}
+			r.Body = teeReadCloser{ReadCloser: r.Body, w: body}
 		}
 
-		// Restore the request body for future use in the chain
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-
 		// Use a ResponseWriter that allows us to capture the status code and body size
-		rec := httptest.NewRecorder()
+		rec := newResponseRecorder(w)
 		handle(rec, r, ps)
 
-		// Copy everything from the recorder to the actual response writer
-		for k, v := range rec.Header() {
-			w.Header()[k] = v
-		}
-		w.WriteHeader(rec.Code)
-		rec.Body.WriteTo(w)
-
-		// Calculate request duration
-		duration := time.Since(startTime)
-
-		// Log in Nginx combined log format with request body appended
-		h.logger.Printf(`%s - - [%s] "%s %s %s" %d %d "%s" "%s" "%s" %v`,
-			r.RemoteAddr,
-			startTime.Format("02/Jan/2006:15:04:05 -0700"),
-			r.Method,
-			r.URL.RequestURI(),
-			r.Proto,
-			rec.Code,
-			rec.Body.Len(),
-			r.Referer(),
-			r.UserAgent(),
-			string(bodyBytes),
-			duration,
-		)
+		attrs := []slog.Attr{
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", redactQuery(r.URL.Query())),
+			slog.String("proto", r.Proto),
+			slog.Int("status", rec.Status()),
+			slog.Int("bytes", rec.bytes),
+			slog.String("referer", r.Referer()),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("request_id", requestIDFromContext(r.Context())),
+			slog.String("tenant", tenantFromContext(r.Context()).Name),
+			slog.String("credential", credentialFromContext(r.Context())),
+			slog.Duration("duration", time.Since(startTime)),
+		}
+
+		// Append the request body with sensitive attributes redacted
+		if body != nil {
+			redacted, truncated := h.accessLog.redactBody(body.Bytes())
+			attrs = append(attrs, slog.String("body", redacted), slog.Bool("body_truncated", truncated || body.truncated))
+		}
+
+		h.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
 	}
 }
 
@@ -114,15 +177,78 @@ func (h *handler) extractOktaID(encodedID string) string {
 	}
 }
 
+// writeSCIMError responds with a SCIM error message.
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SCIMError{
+		Schemas:  []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		ScimType: scimType,
+		Detail:   detail,
+		Status:   strconv.Itoa(status),
+	})
+}
+
+// userResource converts a user to its SCIM representation, including meta.location.
+func (h *handler) userResource(r *http.Request, user *User) (SCIMUser, error) {
+	scimUser, err := h.mapping.convertToSCIMUser(user)
+	if err != nil {
+		return SCIMUser{}, err
+	}
+	scimUser.Meta.Location = h.locator.location(r, "Users", scimUser.ID)
+	for i := range scimUser.Groups {
+		scimUser.Groups[i].Ref = h.locator.location(r, "Groups", scimUser.Groups[i].Value)
+	}
+	return scimUser, nil
+}
+
+// userResources converts users to their SCIM representation, resolving the
+// direct and indirect groups of each user.
+func (h *handler) userResources(r *http.Request, employees ...db.Employee) ([]SCIMUser, error) {
+	tenant := tenantFromContext(r.Context())
+	users, err := usersWithGroups(r.Context(), h.db, tenant.ID, employees...)
+	if err != nil {
+		return nil, err
+	}
+
+	scimUsers := make([]SCIMUser, len(users))
+	for i, user := range users {
+		user.Extensions = enabledExtensions(user.Extensions, tenant.SchemaExtensions)
+		scimUsers[i], err = h.userResource(r, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scimUsers, nil
+}
+
+// groupResource converts a group to its SCIM representation, including meta.location.
+func (h *handler) groupResource(r *http.Request, group *Group) (SCIMGroup, error) {
+	scimGroup, err := h.mapping.convertToSCIMGroup(group)
+	if err != nil {
+		return SCIMGroup{}, err
+	}
+	scimGroup.Meta.Location = h.locator.location(r, "Groups", scimGroup.ID)
+	for i, member := range scimGroup.Members {
+		switch member.Type {
+		case memberTypeUser:
+			scimGroup.Members[i].Ref = h.locator.location(r, "Users", member.Value)
+		case memberTypeGroup:
+			scimGroup.Members[i].Ref = h.locator.location(r, "Groups", member.Value)
+		}
+	}
+	return scimGroup, nil
+}
+
 func (h *handler) GetUser() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.applyMiddlewares(scopeUsersRead, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Extract the user ID from the path parameters
 		encodedID := ps.ByName("id")
 
 		// Attempt to extract the actual ID from the encoded format
 		oktaID := h.extractOktaID(encodedID)
 
-		user, err := h.db.GetUserByID(context.Background(), oktaID)
+		user, err := h.db.GetUserByID(r.Context(), db.GetUserByIDParams{TenantID: tenantIDFromContext(r.Context()), OktaID: oktaID})
 		if err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "User not found", http.StatusNotFound)
@@ -132,88 +258,172 @@ func (h *handler) GetUser() httprouter.Handle {
 			return
 		}
 
-		scimUser := convertToSCIMUser(
-			&User{
-				ID:     user.ID,
-				Name:   user.Name,
-				Email:  user.Email,
-				OktaID: oktaID,
-			},
-		)
+		scimUsers, err := h.userResources(r, user)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(scimUser); err != nil {
+		if err := json.NewEncoder(w).Encode(scimUsers[0]); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	})
 }
 
 func (h *handler) UpdateUser() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.applyMiddlewares(scopeUsersWrite, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
 		userID := ps.ByName("id")
 
 		var updateUserReq SCIMUserUpdate
-		if err := json.NewDecoder(r.Body).Decode(&updateUserReq); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		resource, extensions, err := decodeUserRequest(r, &updateUserReq)
+		if err != nil {
+			writeDecodeError(w, err, err.Error())
 			return
 		}
+		columns, err := h.mapping.userColumns(resource)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+
+		var updatedUser db.Employee
+		err = h.withTx(r.Context(), func(q *txQueries) error {
+			// Lock the current user, which is recorded in the audit log
+			user, err := q.GetUserByOktaIDForUpdate(r.Context(), db.GetUserByOktaIDForUpdateParams{TenantID: tenantID, OktaID: userID})
+			if err != nil {
+				return err
+			}
+
+			updatedUser, err = q.UpdateUser(r.Context(), db.UpdateUserParams{
+				TenantID:   tenantID,
+				OktaID:     userID,
+				Name:       columns.Name,
+				Email:      columns.Email,
+				Active:     updateUserReq.Active,
+				Extensions: extensions,
+			})
+			if err != nil {
+				return err
+			}
 
-		updatedUser, err := h.db.UpdateUser(context.Background(), db.UpdateUserParams{
-			OktaID: userID,
-			Name:   updateUserReq.Name.GivenName + " " + updateUserReq.Name.FamilyName,
-			Email:  updateUserReq.Emails[0].Value,
-			Active: updateUserReq.Active,
+			q.audit(auditEntry{
+				Operation:    auditUpdate,
+				ResourceType: "User",
+				ResourceID:   userID,
+				Before:       auditUser(user),
+				After:        auditUser(updatedUser),
+			})
+			return nil
 		})
 		if err != nil {
-			// Handle errors, e.g., user not found or database errors
-			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			if err == sql.ErrNoRows {
+				http.Error(w, "User not found", http.StatusNotFound)
+			} else {
+				h.logger.Error("Error updating user", "error", err)
+				http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			}
 			return
 		}
 
 		// Convert the updated database user model to a SCIM user model here
-		scimUser := convertToSCIMUser(&User{
-			ID:     updatedUser.ID,
-			Name:   updatedUser.Name,
-			Email:  updatedUser.Email,
-			OktaID: userID,
-		})
+		scimUsers, err := h.userResources(r, updatedUser)
+		if err != nil {
+			http.Error(w, "Failed to fetch user groups", http.StatusInternalServerError)
+			return
+		}
 
 		// Set response header
 		w.Header().Set("Content-Type", "application/json")
 
 		// Respond with the updated user object
-		if err := json.NewEncoder(w).Encode(scimUser); err != nil {
+		if err := json.NewEncoder(w).Encode(scimUsers[0]); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	})
 }
 
 func (h *handler) DeactivateUser() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.applyMiddlewares(scopeUsersWrite, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
+
 		// Extract the user ID from the path parameters
 		userID := ps.ByName("id")
 
-		// Deactivate the user by setting the Active attribute to false
-		_, err := h.db.DeactivateUser(context.Background(), userID)
+		err := h.withTx(r.Context(), func(q *txQueries) error {
+			// Lock the current user, which is recorded in the audit log
+			user, err := q.GetUserByOktaIDForUpdate(r.Context(), db.GetUserByOktaIDForUpdateParams{TenantID: tenantID, OktaID: userID})
+			if err != nil {
+				return err
+			}
+
+			// Remove the user and its memberships when hard deletes are enabled
+			if h.lifecycle.HardDelete {
+				removals, err := removeUserMemberships(r.Context(), q.Queries, tenantID, user)
+				if err != nil {
+					return err
+				}
+				for _, removal := range removals {
+					q.audit(removal)
+				}
+				if _, err := q.DeleteUser(r.Context(), db.DeleteUserParams{TenantID: tenantID, OktaID: userID}); err != nil {
+					return err
+				}
+				q.audit(auditEntry{
+					Operation:    auditDelete,
+					ResourceType: "User",
+					ResourceID:   userID,
+					Before:       auditUser(user),
+				})
+				return nil
+			}
+
+			// Deactivate the user by setting the Active attribute to false
+			deactivatedUser, err := q.DeactivateUser(r.Context(), db.DeactivateUserParams{TenantID: tenantID, OktaID: userID})
+			if err != nil {
+				return err
+			}
+			q.audit(auditEntry{
+				Operation:    auditDeactivate,
+				ResourceType: "User",
+				ResourceID:   userID,
+				Before:       auditUser(user),
+				After:        auditUser(deactivatedUser),
+			})
+			return nil
+		})
 		if err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "User not found", http.StatusNotFound)
 			} else {
+				h.logger.Error("Error deactivating user", "error", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 			return
 		}
 
+		if h.lifecycle.HardDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		// Respond with a success status code
 		w.WriteHeader(http.StatusOK)
 	})
 }
 
 func (h *handler) GetUsers() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.applyMiddlewares(scopeUsersRead, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		query := r.URL.Query()
 		filter := query.Get("filter")
-		if filter != "" {
+		if since, ok, err := parseLastModifiedFilter(filter); ok {
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.ListUsersModifiedSince(w, r, since)
+		} else if filter != "" {
 			h.FindUser(w, r, ps)
 		} else {
 			h.ListUsers(w, r, ps)
@@ -221,10 +431,14 @@ func (h *handler) GetUsers() httprouter.Handle {
 	})
 }
 
-func (h *handler) ListUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// maxPageSize bounds the count of resources returned in a single page.
+const maxPageSize = 1000
+
+// parsePagination reads the SCIM startIndex and count query parameters.
+func parsePagination(r *http.Request) (startIndex, count int) {
 	// Set default values for pagination
-	var startIndex int = 1 // Pagination starts at 1
-	var count int = 100    // Default count
+	startIndex = 1 // Pagination starts at 1
+	count = 100    // Default count
 
 	// Parse startIndex and count from query parameters, if present
 	if start := r.URL.Query().Get("startIndex"); start != "" {
@@ -235,31 +449,54 @@ func (h *handler) ListUsers(w http.ResponseWriter, r *http.Request, ps httproute
 
 	if c := r.URL.Query().Get("count"); c != "" {
 		if c, err := strconv.Atoi(c); err == nil && c > 0 {
-			count = c
+			count = min(c, maxPageSize)
 		}
 	}
 
+	return startIndex, count
+}
+
+func (h *handler) ListUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	startIndex, count := parsePagination(r)
+
 	// Adjust startIndex for SQL OFFSET which starts at 0
 	offset := startIndex - 1
 
 	// Fetch paginated list of users from the database
-	dbUsers, err := h.db.ListUsers(context.Background(), db.ListUsersParams{int32(count), int32(offset)})
+	dbUsers, err := h.db.ListUsers(r.Context(), db.ListUsersParams{TenantID: tenantIDFromContext(r.Context()), Limit: int32(count), Offset: int32(offset)})
 	if err != nil {
 		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
 		return
 	}
 
+	h.writeUserList(w, r, dbUsers, startIndex)
+}
+
+// ListUsersModifiedSince lists users, including deactivated ones, that were
+// modified at or after since so that clients can synchronize incrementally.
+func (h *handler) ListUsersModifiedSince(w http.ResponseWriter, r *http.Request, since time.Time) {
+	startIndex, count := parsePagination(r)
+
+	dbUsers, err := h.db.ListUsersModifiedSince(r.Context(), db.ListUsersModifiedSinceParams{
+		TenantID: tenantIDFromContext(r.Context()),
+		Since:    since,
+		Limit:    int32(count),
+		Offset:   int32(startIndex - 1),
+	})
+	if err != nil {
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeUserList(w, r, dbUsers, startIndex)
+}
+
+func (h *handler) writeUserList(w http.ResponseWriter, r *http.Request, dbUsers []db.Employee, startIndex int) {
 	// Convert users from DB format to SCIM format
-	scimUsers := make([]SCIMUser, len(dbUsers))
-	for i, dbUser := range dbUsers {
-		scimUsers[i] = convertToSCIMUser(
-			&User{
-				ID:     dbUser.ID,
-				Name:   dbUser.Name,
-				Email:  dbUser.Email,
-				OktaID: dbUser.OktaID,
-			},
-		)
+	scimUsers, err := h.userResources(r, dbUsers...)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user groups", http.StatusInternalServerError)
+		return
 	}
 
 	// Construct SCIM response with list of users
@@ -299,9 +536,10 @@ func (h *handler) FindUser(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	user, err := h.db.GetUserByEmail(context.Background(), db.GetUserByEmailParams{
-		Email:  userName,
-		Active: true,
+	user, err := h.db.GetUserByEmail(r.Context(), db.GetUserByEmailParams{
+		TenantID: tenantIDFromContext(r.Context()),
+		Email:    userName,
+		Active:   true,
 	})
 	if err != nil {
 		// Return an empty SCIM list response when the user is not found
@@ -323,164 +561,300 @@ func (h *handler) FindUser(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	scimUser := convertToSCIMUser(
-		&User{
-			ID:     user.ID,
-			Name:   user.Name,
-			Email:  user.Email,
-			OktaID: user.OktaID,
-		},
-	)
+	scimUsers, err := h.userResources(r, user)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(scimUser)
+	json.NewEncoder(w).Encode(scimUsers[0])
 }
 
 func (h *handler) CreateUser() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		var req SCIMUserCreateRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	return h.applyMiddlewares(scopeUsersWrite, h.idempotent(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
 
-		// Check if user already exists based on userName or email
-		exists, err := h.db.GetUserByEmail(context.Background(), db.GetUserByEmailParams{
-			Email:  req.UserName,
-			Active: false,
-		})
-		if err != nil && err != sql.ErrNoRows {
-			http.Error(w, "Error checking user existence", http.StatusInternalServerError)
+		var req SCIMUserCreateRequest
+		resource, extensions, err := decodeUserRequest(r, &req)
+		if err != nil {
+			writeDecodeError(w, err, "Invalid request body")
 			return
 		}
-		if exists.ID > 0 && exists.Active {
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(SCIMError{
-				Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
-				Detail:  "User already exists",
-				Status:  "409",
-			})
+		columns, err := h.mapping.userColumns(resource)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
 			return
 		}
 
-		var user db.Employee
-
-		if exists.ID == 0 {
-			user, err = h.db.CreateUser(context.Background(), db.CreateUserParams{
-				Email:  req.UserName,
-				Name:   req.Name.GivenName + " " + req.Name.FamilyName,
-				OktaID: req.ExternalID,
+		// Look up and create or reactivate the user in one transaction, so that
+		// concurrent requests for the same userName do not both create it
+		var exists, user db.Employee
+		err = h.withTx(r.Context(), func(q *txQueries) error {
+			// Check if user already exists based on its mapped email
+			var err error
+			exists, err = q.GetUserByEmail(r.Context(), db.GetUserByEmailParams{
+				TenantID: tenantID,
+				Email:    columns.Email,
+				Active:   false,
 			})
-			if err != nil {
-				fmt.Errorf("Error creating user: %v", err)
-				http.Error(w, "Error creating user", http.StatusInternalServerError)
-				return
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if exists.ID > 0 && exists.Active {
+				return nil
+			}
+
+			if exists.ID == 0 {
+				user, err = q.CreateUser(r.Context(), db.CreateUserParams{
+					TenantID:   tenantID,
+					Email:      columns.Email,
+					Name:       columns.Name,
+					OktaID:     req.ExternalID,
+					Extensions: extensions,
+				})
+			} else {
+				user, err = q.ReactivateUser(r.Context(), db.ReactivateUserParams{
+					TenantID:   tenantID,
+					ID:         exists.ID,
+					OktaID:     req.ExternalID,
+					Name:       columns.Name,
+					Email:      columns.Email,
+					Extensions: extensions,
+				})
 			}
-		} else {
-			user, err = h.db.UpdateUser(context.Background(), db.UpdateUserParams{
-				OktaID: req.ExternalID,
-				Name:   req.Name.GivenName + " " + req.Name.FamilyName,
-				Email:  req.UserName,
-				Active: true,
-			})
 			if err != nil {
-				fmt.Errorf("Error updating user: %v", err)
-				http.Error(w, "Error updating user", http.StatusInternalServerError)
-				return
+				return err
 			}
+
+			var before map[string]interface{}
+			if exists.ID != 0 {
+				before = auditUser(exists)
+			}
+			q.audit(auditEntry{
+				Operation:    auditCreate,
+				ResourceType: "User",
+				ResourceID:   user.OktaID,
+				Before:       before,
+				After:        auditUser(user),
+			})
+			return nil
+		})
+		if isUniqueViolation(err) {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", "A user with this externalId already exists")
+			return
+		}
+		if err != nil {
+			h.logger.Error("Error creating user", "error", err)
+			http.Error(w, "Error creating user", http.StatusInternalServerError)
+			return
+		}
+		if exists.ID > 0 && exists.Active {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", fmt.Sprintf("User %q already exists", req.UserName))
+			return
 		}
 
 		// Convert to SCIM user response
-		scimUser := convertToSCIMUser(&User{
-			ID:     user.ID,
-			Name:   user.Name,
-			Email:  user.Email,
-			OktaID: user.OktaID,
-		})
+		scimUsers, err := h.userResources(r, user)
+		if err != nil {
+			http.Error(w, "Error fetching user groups", http.StatusInternalServerError)
+			return
+		}
+		scimUser := scimUsers[0]
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", scimUser.Meta.Location)
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(scimUser)
-	})
+	}))
 }
 
 func (h *handler) CreateGroup() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	return h.applyMiddlewares(scopeGroupsWrite, h.idempotent(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
+
 		var groupReq SCIMGroupCreateRequest
-		if err := json.NewDecoder(r.Body).Decode(&groupReq); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		resource, err := decodeGroupRequest(r, &groupReq)
+		if err != nil {
+			writeDecodeError(w, err, err.Error())
 			return
 		}
-
-		// Insert the new group into the database
-		newGroup, err := h.db.CreateGroup(context.Background(), db.CreateGroupParams{
-			Name:   groupReq.DisplayName,
-			OktaID: sql.NullString{String: uuid.New().String(), Valid: true},
-		})
+		name, err := h.mapping.groupName(resource)
 		if err != nil {
-			http.Error(w, "Failed to create group", http.StatusInternalServerError)
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
 			return
 		}
 
+		// Create the group together with its members, or not at all
+		var newGroup db.Oktagroup
 		var members []User
+		var groups []Group
+		err = h.withTx(r.Context(), func(q *txQueries) error {
+			members, groups = nil, nil
 
-		// For each member in the group request, insert a record into the employeeoktagroup table
-		for _, member := range groupReq.Members {
-			members = append(members, User{
-				OktaID: member.Value,
-				Name:   member.Display,
-			})
-			err := h.db.CreateEmployeeOktaGroup(context.Background(), db.CreateEmployeeOktaGroupParams{
-				OktaID: member.Value,
-				Name:   newGroup.Name,
+			// Resolve the requested members into users and nested groups
+			userMembers, memberGroups, err := resolveMembers(r.Context(), q.Queries, groupReq.Members)
+			if err != nil {
+				return err
+			}
+
+			// Insert the new group into the database
+			newGroup, err = q.CreateGroup(r.Context(), db.CreateGroupParams{
+				TenantID: tenantID,
+				Name:     name,
+				OktaID:   sql.NullString{String: uuid.New().String(), Valid: true},
 			})
 			if err != nil {
-				// Handle the error, e.g., rollback the transaction, log the error, etc.
-				// For simplicity, returning an error response here
-				http.Error(w, "Failed to add member to group", http.StatusInternalServerError)
-				return
+				return err
+			}
+
+			q.audit(auditEntry{
+				Operation:    auditCreate,
+				ResourceType: "Group",
+				ResourceID:   newGroup.OktaID.String,
+				After:        auditGroup(newGroup.Name),
+			})
+
+			// For each user member in the group request, insert a record into the employeeoktagroup table
+			for _, member := range userMembers {
+				members = append(members, User{
+					OktaID: member.OktaID,
+					Name:   member.Name,
+					Email:  member.Email,
+				})
+				if err := q.AddGroupMember(r.Context(), db.AddGroupMemberParams{
+					TenantID:    tenantID,
+					EmployeeID:  member.ID,
+					OktaGroupID: newGroup.ID,
+				}); err != nil {
+					return err
+				}
+				q.audit(auditEntry{
+					Operation:    auditMemberAdd,
+					ResourceType: "Group",
+					ResourceID:   newGroup.OktaID.String,
+					After:        auditMember(memberTypeUser, member.OktaID),
+				})
 			}
+
+			// Nest each group member, rejecting memberships that would create a cycle
+			for _, memberGroup := range memberGroups {
+				groups = append(groups, Group{
+					OktaID: memberGroup.OktaID.String,
+					Name:   memberGroup.Name,
+				})
+				if err := addMemberGroup(r.Context(), q.Queries, newGroup.ID, memberGroup); err != nil {
+					return err
+				}
+				q.audit(auditEntry{
+					Operation:    auditMemberAdd,
+					ResourceType: "Group",
+					ResourceID:   newGroup.OktaID.String,
+					After:        auditMember(memberTypeGroup, memberGroup.OktaID.String),
+				})
+			}
+			return nil
+		})
+		if isUniqueViolation(err) {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", fmt.Sprintf("Group %q already exists", name))
+			return
+		}
+		if err != nil {
+			h.writeMembershipError(w, err, "Failed to create group")
+			return
 		}
 
 		// Convert the newly created database group model to a SCIM group model
-		scimGroup := convertToSCIMGroup(&Group{
-			Name:    newGroup.Name,
-			OktaID:  newGroup.OktaID.String,
-			Members: members,
+		scimGroup, err := h.groupResource(r, &Group{
+			Name:         newGroup.Name,
+			OktaID:       newGroup.OktaID.String,
+			Members:      members,
+			MemberGroups: groups,
+			CreatedAt:    newGroup.CreatedAt,
+			UpdatedAt:    newGroup.UpdatedAt,
 		})
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
-		// Set response header
+		// Set response headers
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", scimGroup.Meta.Location)
 
 		// Respond with the created group object
 		w.WriteHeader(http.StatusCreated)
 		if err := json.NewEncoder(w).Encode(scimGroup); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 }
 
 func (h *handler) ListGroups() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		// Default values
-		startIndex := 1
-		count := 100
+	return h.applyMiddlewares(scopeGroupsRead, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
 
-		// Parse query parameters
-		if start, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && start > 0 {
-			startIndex = start
-		}
-		if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c > 0 {
-			count = c
-		}
+		startIndex, count := parsePagination(r)
 
 		// Adjust for SQL OFFSET (0-indexed)
 		offset := startIndex - 1
 
 		// Parse filter
 		filter := r.URL.Query().Get("filter")
-		var err error
 		var scimGroups []SCIMGroup
 
-		if filter != "" {
+		if since, ok, err := parseLastModifiedFilter(filter); ok {
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// Fetch groups modified since the given time for incremental sync
+			groups, err := h.db.ListGroupsModifiedSince(r.Context(), db.ListGroupsModifiedSinceParams{
+				TenantID: tenantID,
+				Since:    since,
+				Limit:    int32(count),
+				Offset:   int32(offset),
+			})
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			scimGroups = make([]SCIMGroup, len(groups))
+			for i, group := range groups {
+				g, err := groupFromRow(group.GroupName, group.GroupOktaID, group.CreatedAt, group.UpdatedAt, group.Members, group.MemberGroups)
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if scimGroups[i], err = h.groupResource(r, g); err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			}
+		} else if memberType, ok := parseMemberTypeFilter(filter); ok {
+			// Fetch groups that have members of the given type
+			groups, err := h.db.ListGroupsByMemberType(r.Context(), db.ListGroupsByMemberTypeParams{
+				TenantID:   tenantID,
+				MemberType: memberType,
+				Limit:      int32(count),
+				Offset:     int32(offset),
+			})
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			scimGroups = make([]SCIMGroup, len(groups))
+			for i, group := range groups {
+				g, err := groupFromRow(group.GroupName, group.GroupOktaID, group.CreatedAt, group.UpdatedAt, group.Members, group.MemberGroups)
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if scimGroups[i], err = h.groupResource(r, g); err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			}
+		} else if filter != "" {
 			// Extract the filter value (group name) from the filter query
 			var groups []db.GetGroupByNameRow
 			var groupName string
@@ -491,7 +865,7 @@ func (h *handler) ListGroups() httprouter.Handle {
 			}
 
 			// Fetch a specific group by name
-			group, err := h.db.GetGroupByName(context.Background(), groupName)
+			group, err := h.db.GetGroupByName(r.Context(), db.GetGroupByNameParams{TenantID: tenantID, Name: groupName})
 			if err != nil {
 				if err == sql.ErrNoRows {
 					http.Error(w, "No group found", http.StatusNotFound)
@@ -503,41 +877,35 @@ func (h *handler) ListGroups() httprouter.Handle {
 			groups = append(groups, group)
 			scimGroups = make([]SCIMGroup, len(groups))
 			for i, group := range groups {
-				var members []User
-				if group.Members != nil {
-					if err := json.Unmarshal(group.Members, &members); err != nil {
-						http.Error(w, "Internal server error", http.StatusInternalServerError)
-						return
-					}
+				g, err := groupFromRow(group.GroupName, group.GroupOktaID, group.CreatedAt, group.UpdatedAt, group.Members, group.MemberGroups)
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if scimGroups[i], err = h.groupResource(r, g); err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
 				}
-				scimGroups[i] = convertToSCIMGroup(&Group{
-					Name:    group.GroupName,
-					OktaID:  group.GroupOktaID.String,
-					Members: members,
-				})
 			}
 		} else {
 			// Fetch all groups with pagination
 			var groups []db.ListGroupsRow
-			groups, err = h.db.ListGroups(context.Background(), db.ListGroupsParams{Limit: int32(count), Offset: int32(offset)})
+			groups, err = h.db.ListGroups(r.Context(), db.ListGroupsParams{TenantID: tenantID, Limit: int32(count), Offset: int32(offset)})
 			if err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
-			scimGroups := make([]SCIMGroup, len(groups))
+			scimGroups = make([]SCIMGroup, len(groups))
 			for i, group := range groups {
-				var members []User
-				if group.Members != nil {
-					if err := json.Unmarshal(group.Members, &members); err != nil {
-						http.Error(w, "Internal server error", http.StatusInternalServerError)
-						return
-					}
+				g, err := groupFromRow(group.GroupName, group.GroupOktaID, group.CreatedAt, group.UpdatedAt, group.Members, group.MemberGroups)
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if scimGroups[i], err = h.groupResource(r, g); err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
 				}
-				scimGroups[i] = convertToSCIMGroup(&Group{
-					Name:    group.GroupName,
-					OktaID:  group.GroupOktaID.String,
-					Members: members,
-				})
 			}
 		}
 
@@ -550,11 +918,11 @@ func (h *handler) ListGroups() httprouter.Handle {
 }
 
 func (h *handler) GetGroup() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.applyMiddlewares(scopeGroupsRead, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		groupID := ps.ByName("id")
 
 		// Fetch group from the database by ID
-		group, err := h.db.GetGroupByID(context.Background(), sql.NullString{String: groupID, Valid: true})
+		group, err := h.db.GetGroupByID(r.Context(), db.GetGroupByIDParams{TenantID: tenantIDFromContext(r.Context()), OktaID: sql.NullString{String: groupID, Valid: true}})
 		if err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "Group not found", http.StatusNotFound)
@@ -564,18 +932,18 @@ func (h *handler) GetGroup() httprouter.Handle {
 			return
 		}
 
-		var members []User
-		if err := json.Unmarshal(group.Members, &members); err != nil {
+		g, err := groupFromRow(group.GroupName, group.GroupOktaID, group.CreatedAt, group.UpdatedAt, group.Members, group.MemberGroups)
+		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
 		// Convert to SCIM format
-		scimGroup := convertToSCIMGroup(&Group{
-			Name:    group.GroupName,
-			OktaID:  group.GroupOktaID.String,
-			Members: members,
-		})
+		scimGroup, err := h.groupResource(r, g)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		// Construct and send response
 		w.Header().Set("Content-Type", "application/json")
@@ -584,155 +952,292 @@ func (h *handler) GetGroup() httprouter.Handle {
 }
 
 func (h *handler) UpdateGroup() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		// Extract the group ID from the request parameters
+	return h.applyMiddlewares(scopeGroupsWrite, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
 
+		// Extract the group ID from the request parameters
 		groupID := ps.ByName("id")
 
 		// Decode the request body to get the updated group details
 		var updateReq SCIMGroupUpdateRequest
-		if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		resource, err := decodeGroupRequest(r, &updateReq)
+		if err != nil {
+			writeDecodeError(w, err, err.Error())
 			return
 		}
-
-		// Begin a transaction to ensure atomic updates
-		tx, err := h.dbConn.BeginTx(context.Background(), nil)
-		if err != nil {
-			http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		// The group is renamed to its mapped name
+		if updateReq.DisplayName, err = h.mapping.groupName(resource); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
 			return
 		}
-		defer tx.Rollback()
 
-		_, err = h.db.UpdateGroupOktaID(context.Background(), db.UpdateGroupOktaIDParams{
-			Name:   updateReq.DisplayName,
-			OktaID: sql.NullString{String: groupID, Valid: true},
+		// Apply the update in a transaction holding a lock on the group, so that
+		// concurrent updates of the same group are applied one after the other
+		var group db.Oktagroup
+		err = h.withTx(r.Context(), func(q *txQueries) error {
+			var err error
+
+			// The group is identified by the id in the URL, never by the request body
+			group, err = q.GetGroupByOktaIDForUpdate(r.Context(), db.GetGroupByOktaIDForUpdateParams{
+				TenantID: tenantID,
+				OktaID:   sql.NullString{String: groupID, Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+			return updateGroup(r.Context(), q, group, updateReq)
 		})
-		if err != nil {
-			http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		case isUniqueViolation(err):
+			writeSCIMError(w, http.StatusConflict, "uniqueness", fmt.Sprintf("Group %q already exists", updateReq.DisplayName))
+			return
+		case err != nil:
+			h.writeMembershipError(w, err, "Failed to update group")
 			return
 		}
 
-		// Fetch current group members
-		currentMembers, err := h.db.GetGroupMembers(context.Background(), updateReq.DisplayName)
+		// Fetch the updated group details and members
+		updatedGroupDetails, err := h.db.GetGroupByID(r.Context(), db.GetGroupByIDParams{TenantID: tenantID, OktaID: group.OktaID})
 		if err != nil {
-			http.Error(w, "Failed to fetch group members", http.StatusInternalServerError)
+			http.Error(w, "Failed to fetch updated group details", http.StatusInternalServerError)
 			return
 		}
 
-		// Map current members for easy lookup
-		currentMemberMap := make(map[string]bool)
-		for _, member := range currentMembers {
-			currentMemberMap[member.OktaID] = true
+		g, err := groupFromRow(updatedGroupDetails.GroupName, updatedGroupDetails.GroupOktaID, updatedGroupDetails.CreatedAt, updatedGroupDetails.UpdatedAt, updatedGroupDetails.Members, updatedGroupDetails.MemberGroups)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 
-		// Map new members from the update request
-		newMemberMap := make(map[string]SCIMGroupMember)
-		for _, member := range updateReq.Members {
-			if member.Display == "" || member.Value == "" {
-				continue
-			}
-			newMemberMap[member.Value] = member
+		// Construct the SCIM group response with updated details and members
+		updatedGroup, err := h.groupResource(r, g)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 
-		// Determine members to add and remove
-		var membersToAdd []string
-		var membersToRemove []string
-		for value, member := range newMemberMap {
-			if !currentMemberMap[value] {
-				membersToAdd = append(membersToAdd, member.Value)
-			}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(updatedGroup); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		for value := range currentMemberMap {
-			if _, exists := newMemberMap[value]; !exists {
-				membersToRemove = append(membersToRemove, value)
-			}
+	})
+}
+
+// updateGroup renames group and replaces its members with those of updateReq
+// through the queries of a transaction, recording every change in the audit
+// log.
+func updateGroup(ctx context.Context, q *txQueries, group db.Oktagroup, updateReq SCIMGroupUpdateRequest) error {
+	tenantID := tenantIDFromContext(ctx)
+	groupID := group.OktaID.String
+
+	// Rename the group when the displayName changed. Memberships reference the
+	// group by id and are unaffected.
+	changed := false
+	if updateReq.DisplayName != "" && updateReq.DisplayName != group.Name {
+		if _, err := q.UpdateGroupName(ctx, db.UpdateGroupNameParams{
+			TenantID: tenantID,
+			OktaID:   group.OktaID,
+			Name:     updateReq.DisplayName,
+		}); err != nil {
+			return err
 		}
+		q.audit(auditEntry{
+			Operation:    auditUpdate,
+			ResourceType: "Group",
+			ResourceID:   groupID,
+			Before:       auditGroup(group.Name),
+			After:        auditGroup(updateReq.DisplayName),
+		})
+		changed = true
+	}
 
-		fmt.Printf("updateReq: %v\n", updateReq.DisplayName)
-		fmt.Printf("membersToAdd: %v\n", membersToAdd)
-		fmt.Printf("membersToRemove: %v\n", membersToRemove)
+	// Fetch current group members
+	currentMembers, err := q.GetGroupMembers(ctx, db.GetGroupMembersParams{TenantID: tenantID, OktaGroupID: group.ID})
+	if err != nil {
+		return err
+	}
 
-		// Add new members
-		for _, member := range membersToAdd {
-			if err := h.db.AddGroupMember(context.Background(), db.AddGroupMemberParams{
-				EmployeeID:    member,
-				OktaGroupName: updateReq.DisplayName,
-			}); err != nil {
-				http.Error(w, "Failed to add group member", http.StatusInternalServerError)
-				return
-			}
-		}
+	// Map current members for easy lookup
+	currentMemberMap := make(map[int32]bool)
+	memberOktaIDs := make(map[int32]string)
+	for _, member := range currentMembers {
+		currentMemberMap[member.ID] = true
+		memberOktaIDs[member.ID] = member.OktaID
+	}
 
-		// Remove members no longer in the group
-		for _, value := range membersToRemove {
-			if err := h.db.RemoveGroupMember(context.Background(), db.RemoveGroupMemberParams{
-				EmployeeID:    value,
-				OktaGroupName: updateReq.DisplayName,
-			}); err != nil {
-				http.Error(w, "Failed to remove group member", http.StatusInternalServerError)
-				return
-			}
+	// Fetch current member groups
+	currentMemberGroups, err := q.GetGroupMemberGroups(ctx, db.GetGroupMemberGroupsParams{TenantID: tenantID, GroupID: group.ID})
+	if err != nil {
+		return err
+	}
+
+	currentMemberGroupMap := make(map[int32]bool)
+	memberGroupOktaIDs := make(map[int32]string)
+	for _, memberGroup := range currentMemberGroups {
+		currentMemberGroupMap[memberGroup.ID] = true
+		memberGroupOktaIDs[memberGroup.ID] = memberGroup.OktaID.String
+	}
+
+	// Split the requested members into users and nested groups. Members are
+	// resolved by value, display is optional
+	var requestedMembers []SCIMGroupMember
+	for _, member := range updateReq.Members {
+		if member.Value == "" {
+			continue
 		}
+		requestedMembers = append(requestedMembers, member)
+	}
+	userMembers, memberGroups, err := resolveMembers(ctx, q.Queries, requestedMembers)
+	if err != nil {
+		return err
+	}
 
-		// Commit the transaction
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
-			return
+	// Map new members from the update request
+	newMemberMap := make(map[int32]bool)
+	for _, member := range userMembers {
+		newMemberMap[member.ID] = true
+		memberOktaIDs[member.ID] = member.OktaID
+	}
+
+	newMemberGroupMap := make(map[int32]bool)
+	for _, memberGroup := range memberGroups {
+		newMemberGroupMap[memberGroup.ID] = true
+	}
+
+	// Determine members to add and remove
+	var membersToAdd []int32
+	var membersToRemove []int32
+	for memberID := range newMemberMap {
+		if !currentMemberMap[memberID] {
+			membersToAdd = append(membersToAdd, memberID)
+		}
+	}
+	for memberID := range currentMemberMap {
+		if !newMemberMap[memberID] {
+			membersToRemove = append(membersToRemove, memberID)
 		}
+	}
 
-		// Fetch the updated group details and members
-		updatedGroupDetails, err := h.db.GetGroupByName(context.Background(), updateReq.DisplayName)
-		if err != nil {
-			http.Error(w, "Failed to fetch updated group details", http.StatusInternalServerError)
-			return
+	// Add new members
+	for _, memberID := range membersToAdd {
+		if err := q.AddGroupMember(ctx, db.AddGroupMemberParams{
+			TenantID:    tenantID,
+			EmployeeID:  memberID,
+			OktaGroupID: group.ID,
+		}); err != nil {
+			return err
 		}
+		q.audit(auditEntry{
+			Operation:    auditMemberAdd,
+			ResourceType: "Group",
+			ResourceID:   groupID,
+			After:        auditMember(memberTypeUser, memberOktaIDs[memberID]),
+		})
+		changed = true
+	}
 
-		var members []User
-		if err := json.Unmarshal(updatedGroupDetails.Members, &members); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+	// Remove members no longer in the group
+	for _, memberID := range membersToRemove {
+		if err := q.RemoveGroupMember(ctx, db.RemoveGroupMemberParams{
+			TenantID:    tenantID,
+			EmployeeID:  memberID,
+			OktaGroupID: group.ID,
+		}); err != nil {
+			return err
 		}
+		q.audit(auditEntry{
+			Operation:    auditMemberRemove,
+			ResourceType: "Group",
+			ResourceID:   groupID,
+			Before:       auditMember(memberTypeUser, memberOktaIDs[memberID]),
+		})
+		changed = true
+	}
 
-		// Construct the SCIM group response with updated details and members
-		updatedGroup := convertToSCIMGroup(&Group{
-			Name:    updatedGroupDetails.GroupName,
-			OktaID:  updatedGroupDetails.GroupOktaID.String,
-			Members: members,
+	// Nest new member groups, rejecting memberships that would create a cycle
+	for _, memberGroup := range memberGroups {
+		if currentMemberGroupMap[memberGroup.ID] {
+			continue
+		}
+		if err := addMemberGroup(ctx, q.Queries, group.ID, memberGroup); err != nil {
+			return err
+		}
+		q.audit(auditEntry{
+			Operation:    auditMemberAdd,
+			ResourceType: "Group",
+			ResourceID:   groupID,
+			After:        auditMember(memberTypeGroup, memberGroup.OktaID.String),
 		})
+		changed = true
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(updatedGroup); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Remove member groups no longer in the group
+	for memberGroupID := range currentMemberGroupMap {
+		if newMemberGroupMap[memberGroupID] {
+			continue
 		}
-	})
+		if err := q.RemoveGroupMemberGroup(ctx, db.RemoveGroupMemberGroupParams{
+			TenantID:      tenantID,
+			GroupID:       group.ID,
+			MemberGroupID: memberGroupID,
+		}); err != nil {
+			return err
+		}
+		q.audit(auditEntry{
+			Operation:    auditMemberRemove,
+			ResourceType: "Group",
+			ResourceID:   groupID,
+			Before:       auditMember(memberTypeGroup, memberGroupOktaIDs[memberGroupID]),
+		})
+		changed = true
+	}
+
+	// Membership changes modify the group
+	if changed {
+		return q.TouchGroup(ctx, db.TouchGroupParams{TenantID: tenantID, ID: group.ID})
+	}
+	return nil
 }
 
 func (h *handler) DeleteGroup() httprouter.Handle {
-	return h.applyMiddlewares(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		groupID := ps.ByName("id")
+	return h.applyMiddlewares(scopeGroupsWrite, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		tenantID := tenantIDFromContext(r.Context())
 
-		// Begin a transaction
-		tx, err := h.dbConn.Begin()
-		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
-		}
-		defer tx.Rollback()
+		groupID := ps.ByName("id")
+		oktaID := sql.NullString{String: groupID, Valid: true}
 
-		if err := h.db.DeleteGroupMembers(context.Background(), sql.NullString{String: groupID, Valid: true}); err != nil {
-			http.Error(w, "Failed to delete group members", http.StatusInternalServerError)
-			return
-		}
+		err := h.withTx(r.Context(), func(q *txQueries) error {
+			// Lock the group, which also provides its name for the audit log
+			group, err := q.GetGroupByOktaIDForUpdate(r.Context(), db.GetGroupByOktaIDForUpdateParams{TenantID: tenantID, OktaID: oktaID})
+			if err != nil {
+				return err
+			}
 
-		if err := h.db.DeleteGroup(context.Background(), sql.NullString{String: groupID, Valid: true}); err != nil {
-			http.Error(w, "Failed to delete group", http.StatusInternalServerError)
-			return
-		}
+			if err := q.DeleteGroupMembers(r.Context(), db.DeleteGroupMembersParams{TenantID: tenantID, OktaID: oktaID}); err != nil {
+				return err
+			}
+			if err := q.DeleteGroup(r.Context(), db.DeleteGroupParams{TenantID: tenantID, OktaID: oktaID}); err != nil {
+				return err
+			}
 
-		// Commit the transaction
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			q.audit(auditEntry{
+				Operation:    auditDelete,
+				ResourceType: "Group",
+				ResourceID:   groupID,
+				Before:       auditGroup(group.Name),
+			})
+			return nil
+		})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Group not found", http.StatusNotFound)
+			} else {
+				h.logger.Error("Error deleting group", "error", err)
+				http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+			}
 			return
 		}
 
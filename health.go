@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"okta-scim/db"
+)
+
+// buildCommit is set at build time with -ldflags "-X main.buildCommit=<sha>".
+// When unset, the VCS revision recorded by the Go toolchain is reported.
+var buildCommit string
+
+// readinessTimeout bounds the checks run for each readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// healthChecker serves the unauthenticated health, readiness and version endpoints.
+type healthChecker struct {
+	db     *db.Queries
+	dbConn *sql.DB
+	// okta is checked for reachability when set. Results are cached for
+	// oktaCacheTTL so that probes do not count against the Okta rate limits.
+	okta         *lazyOktaClient
+	oktaCacheTTL time.Duration
+	features     map[string]bool
+
+	draining atomic.Bool
+
+	mu            sync.Mutex
+	oktaCheckedAt time.Time
+	oktaErr       error
+}
+
+func newHealthChecker(queries *db.Queries, dbConn *sql.DB, okta *lazyOktaClient, oktaCacheTTL time.Duration, features map[string]bool) *healthChecker {
+	return &healthChecker{
+		db:           queries,
+		dbConn:       dbConn,
+		okta:         okta,
+		oktaCacheTTL: oktaCacheTTL,
+		features:     features,
+	}
+}
+
+// SetDraining makes readiness fail so that load balancers stop routing new
+// requests while the server shuts down.
+func (c *healthChecker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Healthz reports that the process is alive.
+func (c *healthChecker) Healthz() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeHealthStatus(w, http.StatusOK, nil)
+	}
+}
+
+// Readyz reports whether the server can serve requests: the database is
+// reachable, its schema is at least as recent as the build and, optionally,
+// Okta is reachable.
+func (c *healthChecker) Readyz() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := map[string]string{}
+		ready := true
+		check := func(name string, err error) {
+			if err != nil {
+				checks[name] = err.Error()
+				ready = false
+				return
+			}
+			checks[name] = "ok"
+		}
+
+		if c.draining.Load() {
+			check("shutdown", fmt.Errorf("server is shutting down"))
+		}
+		check("database", c.dbConn.PingContext(ctx))
+		check("migrations", c.checkSchemaVersion(ctx))
+		if c.okta != nil {
+			check("okta", c.checkOkta(ctx))
+		}
+
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealthStatus(w, status, checks)
+	}
+}
+
+// checkSchemaVersion fails when the schema lacks migrations of this build. A
+// newer schema is accepted, since migrations are additive and are applied
+// before a rolling deploy replaces the older servers.
+func (c *healthChecker) checkSchemaVersion(ctx context.Context) error {
+	version, err := c.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if expected := db.LatestSchemaVersion(); int(version) < expected {
+		return fmt.Errorf("schema version %d, expected at least %d", version, expected)
+	}
+	return nil
+}
+
+// checkOkta returns the cached result of the last Okta reachability check,
+// checking again once it has expired.
+func (c *healthChecker) checkOkta(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.oktaCheckedAt.IsZero() && time.Since(c.oktaCheckedAt) < c.oktaCacheTTL {
+		return c.oktaErr
+	}
+	client, err := c.okta.Client()
+	if err == nil {
+		err = client.Ping(ctx)
+	}
+	c.oktaErr = err
+	c.oktaCheckedAt = time.Now()
+	return c.oktaErr
+}
+
+func writeHealthStatus(w http.ResponseWriter, status int, checks map[string]string) {
+	text := "ok"
+	if status != http.StatusOK {
+		text = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}{
+		Status: text,
+		Checks: checks,
+	})
+}
+
+// Version reports the build commit, the schema version, whether it is ahead
+// of the build, and the enabled features.
+func (c *healthChecker) Version() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		// The schema version is omitted when the database cannot be reached
+		var schemaVersion *int32
+		if version, err := c.db.GetSchemaVersion(ctx); err == nil {
+			schemaVersion = &version
+		}
+		expected := db.LatestSchemaVersion()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Commit          string          `json:"commit"`
+			GoVersion       string          `json:"goVersion"`
+			SchemaVersion   *int32          `json:"schemaVersion"`
+			ExpectedVersion int             `json:"expectedSchemaVersion"`
+			SchemaAhead     bool            `json:"schemaAhead"`
+			Features        map[string]bool `json:"features"`
+		}{
+			Commit:          commit(),
+			GoVersion:       goVersion(),
+			SchemaVersion:   schemaVersion,
+			ExpectedVersion: expected,
+			SchemaAhead:     schemaVersion != nil && int(*schemaVersion) > expected,
+			Features:        c.features,
+		})
+	}
+}
+
+func commit() string {
+	if buildCommit != "" {
+		return buildCommit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+func goVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.GoVersion
+	}
+	return "unknown"
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"okta-scim/db"
+)
+
+const (
+	// maxIdempotencyKeyLength matches the key column.
+	maxIdempotencyKeyLength = 255
+
+	// idempotencyPruneInterval is how often expired keys are deleted.
+	idempotencyPruneInterval = time.Hour
+)
+
+// idempotencyConfig configures the replay of requests retried by clients.
+type idempotencyConfig struct {
+	// KeyTTL is how long the response to a request with an idempotency key is
+	// replayed to retries.
+	KeyTTL time.Duration `yaml:"keyTTL"`
+}
+
+func (c idempotencyConfig) validate() error {
+	if c.KeyTTL <= 0 {
+		return errors.New("idempotency.keyTTL must be positive")
+	}
+	return nil
+}
+
+// idempotencyKeys stores the responses to requests with an idempotency key.
+type idempotencyKeys struct {
+	config idempotencyConfig
+	db     *db.Queries
+	logger *slog.Logger
+}
+
+func newIdempotencyKeys(config idempotencyConfig, queries *db.Queries, logger *slog.Logger) *idempotencyKeys {
+	return &idempotencyKeys{
+		config: config,
+		db:     queries,
+		logger: logger,
+	}
+}
+
+// Run deletes expired keys every idempotencyPruneInterval until ctx is
+// canceled.
+func (k *idempotencyKeys) Run(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if deleted, err := k.db.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-k.config.KeyTTL)); err != nil {
+			k.logger.Error("Error deleting expired idempotency keys", "error", err)
+		} else if deleted > 0 {
+			k.logger.Info("Deleted expired idempotency keys", "count", deleted)
+		}
+	}
+}
+
+// idempotent replays the stored response to retries of a request with the
+// same Idempotency-Key header and payload instead of serving them again.
+// Requests without the header are not de-duplicated. Only successful
+// responses are stored, so that a request that failed, e.g. because of a
+// conflict resolved since, is served again when retried.
+func (h *handler) idempotent(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeDecodeError(w, err, "Invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handle(w, r, ps)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", "Idempotency-Key must not be longer than "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+			return
+		}
+
+		requestHash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\x00"), body...))
+		tenantID := tenantIDFromContext(r.Context())
+		credential := credentialFromContext(r.Context())
+
+		claimed, err := h.db.ClaimIdempotencyKey(r.Context(), db.ClaimIdempotencyKeyParams{
+			TenantID:      tenantID,
+			Credential:    credential,
+			Key:           key,
+			RequestHash:   requestHash[:],
+			ExpiredBefore: time.Now().Add(-h.idempotency.config.KeyTTL),
+		})
+		if err != nil {
+			h.logger.Error("Error claiming idempotency key", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if claimed == 0 {
+			h.replay(w, r, db.GetIdempotencyKeyParams{TenantID: tenantID, Credential: credential, Key: key}, requestHash[:])
+			return
+		}
+
+		// Release the key unless the response is stored, including when the
+		// request is canceled or the handler panics
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			if err := h.db.ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), db.ReleaseIdempotencyKeyParams{
+				TenantID:   tenantID,
+				Credential: credential,
+				Key:        key,
+			}); err != nil {
+				h.logger.Error("Error releasing idempotency key", "error", err)
+			}
+		}()
+
+		rec := &bodyRecorder{responseRecorder: newResponseRecorder(w)}
+		handle(rec, r, ps)
+		if rec.Status() < 200 || rec.Status() > 299 {
+			return
+		}
+
+		if err := h.db.CompleteIdempotencyKey(context.WithoutCancel(r.Context()), db.CompleteIdempotencyKeyParams{
+			TenantID:    tenantID,
+			Credential:  credential,
+			Key:         key,
+			Status:      sql.NullInt32{Int32: int32(rec.Status()), Valid: true},
+			ContentType: rec.Header().Get("Content-Type"),
+			Location:    rec.Header().Get("Location"),
+			Body:        rec.body.Bytes(),
+		}); err != nil {
+			h.logger.Error("Error storing idempotent response", "error", err)
+			return
+		}
+		stored = true
+	}
+}
+
+// replay responds to a retried request with the stored response. Requests
+// reusing a key with another payload and retries of requests still being
+// served are rejected.
+func (h *handler) replay(w http.ResponseWriter, r *http.Request, params db.GetIdempotencyKeyParams, requestHash []byte) {
+	stored, err := h.db.GetIdempotencyKey(r.Context(), params)
+	switch {
+	case err == sql.ErrNoRows || err == nil && !stored.Status.Valid:
+		// The key is in use by a request being served, or was just released
+		w.Header().Set("Retry-After", "1")
+		writeSCIMError(w, http.StatusServiceUnavailable, "", "A request with this idempotency key is being served, retry later")
+		return
+	case err != nil:
+		h.logger.Error("Error fetching idempotent response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	case !bytes.Equal(stored.RequestHash, requestHash):
+		writeSCIMError(w, http.StatusUnprocessableEntity, "invalidValue", "The idempotency key was used for a different request")
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	if stored.Location != "" {
+		w.Header().Set("Location", stored.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.Status.Int32))
+	w.Write(stored.Body)
+}
+
+// bodyRecorder records the response body in addition to its status code.
+type bodyRecorder struct {
+	*responseRecorder
+	body bytes.Buffer
+}
+
+func (rw *bodyRecorder) Write(b []byte) (int, error) {
+	n, err := rw.responseRecorder.Write(b)
+	rw.body.Write(b[:n])
+	return n, err
+}
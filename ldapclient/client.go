@@ -0,0 +1,205 @@
+// Package ldapclient writes entries to LDAP directories, keeping the members
+// of the groups referencing them up to date.
+package ldapclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// EmptyGroupMember is the member of groups without members, since
+// groupOfNames requires at least one.
+const EmptyGroupMember = ""
+
+// Config configures the connection to a directory.
+type Config struct {
+	// URL is the ldap:// or ldaps:// URL of the directory server.
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS.
+	StartTLS bool
+	// BindDN and BindPassword authenticate to the directory, which is used
+	// anonymously when BindDN is empty.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the subtree searched for the groups referencing an entry.
+	BaseDN string
+	// Timeout bounds dialing and each request.
+	Timeout time.Duration
+}
+
+// Directory is a connection to a directory.
+type Directory struct {
+	conn   *ldap.Conn
+	baseDN string
+}
+
+// Dial connects and binds to the directory.
+func Dial(config Config) (*Directory, error) {
+	conn, err := ldap.DialURL(config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: config.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(config.Timeout)
+
+	if config.StartTLS {
+		u, _ := url.Parse(config.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if config.BindDN != "" {
+		if err := conn.Bind(config.BindDN, config.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &Directory{conn: conn, baseDN: config.BaseDN}, nil
+}
+
+// Close closes the connection.
+func (d *Directory) Close() error {
+	return d.conn.Close()
+}
+
+// IsRejected reports whether err is a result of the directory rejecting a
+// change as invalid, which retrying does not fix.
+func IsRejected(err error) bool {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return false
+	}
+	switch ldapErr.ResultCode {
+	case ldap.LDAPResultUndefinedAttributeType,
+		ldap.LDAPResultConstraintViolation,
+		ldap.LDAPResultInvalidAttributeSyntax,
+		ldap.LDAPResultInvalidDNSyntax,
+		ldap.LDAPResultNamingViolation,
+		ldap.LDAPResultObjectClassViolation,
+		ldap.LDAPResultNotAllowedOnNonLeaf,
+		ldap.LDAPResultEntryAlreadyExists:
+		return true
+	}
+	return false
+}
+
+// WriteEntry moves the entry at previousDN to dn when they differ, then
+// replaces the attributes of the entry, adding it with objectClasses when it
+// does not exist, and reports whether it was added. previousDN is "" for
+// entries not written before.
+func (d *Directory) WriteEntry(previousDN, dn string, objectClasses []string, attributes map[string][]string) (bool, error) {
+	if previousDN != "" && !SameDN(previousDN, dn) {
+		if err := d.move(previousDN, dn); err != nil {
+			return false, err
+		}
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	modify := ldap.NewModifyRequest(dn, nil)
+	for _, name := range names {
+		modify.Replace(name, attributes[name])
+	}
+	err := d.conn.Modify(modify)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, err
+	}
+
+	add := ldap.NewAddRequest(dn, nil)
+	add.Attribute("objectClass", objectClasses)
+	for _, name := range names {
+		if len(attributes[name]) > 0 {
+			add.Attribute(name, attributes[name])
+		}
+	}
+	if err := d.conn.Add(add); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// move renames an entry and updates the groups referencing it. Entries that
+// no longer exist are added again by the caller.
+func (d *Directory) move(fromDN, toDN string) error {
+	to, err := ldap.ParseDN(toDN)
+	if err != nil {
+		return err
+	}
+	rdn := to.RDNs[0].String()
+	parent := (&ldap.DN{RDNs: to.RDNs[1:]}).String()
+
+	err = d.conn.ModifyDN(ldap.NewModifyDNRequest(fromDN, rdn, true, parent))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return d.replaceReferences(fromDN, toDN)
+}
+
+// DeleteEntry deletes an entry, if it still exists, and removes it from the
+// groups referencing it.
+func (d *Directory) DeleteEntry(dn string) error {
+	err := d.conn.Del(ldap.NewDelRequest(dn, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return err
+	}
+	return d.replaceReferences(dn, "")
+}
+
+// replaceReferences replaces fromDN with toDN in the members of the groups
+// under the base DN, or removes it when toDN is "". Directories do not keep
+// references up to date without a referential integrity overlay.
+func (d *Directory) replaceReferences(fromDN, toDN string) error {
+	result, err := d.conn.Search(ldap.NewSearchRequest(
+		d.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(member="+ldap.EscapeFilter(fromDN)+")", []string{"member"}, nil,
+	))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range result.Entries {
+		var members []string
+		for _, member := range entry.GetAttributeValues("member") {
+			if !SameDN(member, fromDN) {
+				members = append(members, member)
+			}
+		}
+		if toDN != "" {
+			members = append(members, toDN)
+		}
+		if len(members) == 0 {
+			members = []string{EmptyGroupMember}
+		}
+
+		modify := ldap.NewModifyRequest(entry.DN, nil)
+		modify.Replace("member", members)
+		if err := d.conn.Modify(modify); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SameDN reports whether two DNs name the same entry.
+func SameDN(a, b string) bool {
+	parsedA, errA := ldap.ParseDN(a)
+	parsedB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return parsedA.EqualFold(parsedB)
+}
@@ -0,0 +1,445 @@
+package ldapclient
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBaseDN   = "dc=example,dc=com"
+	testBindDN   = "cn=admin,dc=example,dc=com"
+	testPassword = "secret"
+)
+
+// fakeDirectory is an in-process LDAP server keeping its entries in memory.
+// It understands simple binds, searches with an equality filter, and adding,
+// modifying, renaming and deleting entries, which is what Directory uses.
+type fakeDirectory struct {
+	mu      sync.Mutex
+	entries map[string]*ldap.Entry
+}
+
+func newFakeDirectory(t *testing.T) (*fakeDirectory, Config) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	d := &fakeDirectory{entries: map[string]*ldap.Entry{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d, Config{
+		URL:          "ldap://" + listener.Addr().String(),
+		BindDN:       testBindDN,
+		BindPassword: testPassword,
+		BaseDN:       testBaseDN,
+		Timeout:      5 * time.Second,
+	}
+}
+
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return strings.ToLower(parsed.String())
+}
+
+func (d *fakeDirectory) put(dn string, attributes map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[normalizeDN(dn)] = ldap.NewEntry(dn, attributes)
+}
+
+// get returns the attributes of the entry with the DN, or nil if it does not
+// exist.
+func (d *fakeDirectory) get(dn string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[normalizeDN(dn)]
+	if !ok {
+		return nil
+	}
+	attributes := map[string][]string{}
+	for _, attribute := range entry.Attributes {
+		attributes[attribute.Name] = attribute.Values
+	}
+	return attributes
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, op := packet.Children[0].Value, packet.Children[1]
+		if op.Tag == ldap.ApplicationUnbindRequest {
+			return
+		}
+
+		d.mu.Lock()
+		responses := d.handle(op)
+		d.mu.Unlock()
+		for _, response := range responses {
+			message := ber.NewSequence("")
+			message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			message.AppendChild(response)
+			if _, err := conn.Write(message.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.LDAPResultCodeMap[code], ""))
+	return response
+}
+
+// handle applies a request to the entries and returns the responses.
+func (d *fakeDirectory) handle(op *ber.Packet) []*ber.Packet {
+	switch op.Tag {
+	case ldap.ApplicationBindRequest:
+		dn, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+		if dn != testBindDN || password != testPassword {
+			return []*ber.Packet{result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials)}
+		}
+		return []*ber.Packet{result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess)}
+
+	case ldap.ApplicationSearchRequest:
+		filter := op.Children[6]
+		if filter.ClassType != ber.ClassContext || filter.Tag != ldap.FilterEqualityMatch {
+			return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultUnwillingToPerform)}
+		}
+		name, value := filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+		var responses []*ber.Packet
+		for _, entry := range d.entries {
+			if !containsDN(entry.GetAttributeValues(name), value) {
+				continue
+			}
+			response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+			response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+			attributes := ber.NewSequence("")
+			for _, attribute := range entry.Attributes {
+				encoded := ber.NewSequence("")
+				encoded.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute.Name, ""))
+				values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+				for _, v := range attribute.Values {
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+				}
+				encoded.AppendChild(values)
+				attributes.AppendChild(encoded)
+			}
+			response.AppendChild(attributes)
+			responses = append(responses, response)
+		}
+		return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+
+	case ldap.ApplicationAddRequest:
+		return []*ber.Packet{result(ldap.ApplicationAddResponse, d.add(op))}
+	case ldap.ApplicationModifyRequest:
+		return []*ber.Packet{result(ldap.ApplicationModifyResponse, d.modify(op))}
+	case ldap.ApplicationModifyDNRequest:
+		return []*ber.Packet{result(ldap.ApplicationModifyDNResponse, d.modifyDN(op))}
+	case ldap.ApplicationDelRequest:
+		dn := normalizeDN(op.Data.String())
+		if _, ok := d.entries[dn]; !ok {
+			return []*ber.Packet{result(ldap.ApplicationDelResponse, ldap.LDAPResultNoSuchObject)}
+		}
+		delete(d.entries, dn)
+		return []*ber.Packet{result(ldap.ApplicationDelResponse, ldap.LDAPResultSuccess)}
+	}
+	return []*ber.Packet{result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError)}
+}
+
+func containsDN(values []string, dn string) bool {
+	for _, v := range values {
+		if SameDN(v, dn) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeAttribute(attribute *ber.Packet) (string, []string) {
+	var values []string
+	for _, v := range attribute.Children[1].Children {
+		values = append(values, v.Value.(string))
+	}
+	return attribute.Children[0].Value.(string), values
+}
+
+func (d *fakeDirectory) add(op *ber.Packet) uint16 {
+	dn := op.Children[0].Value.(string)
+	if _, err := ldap.ParseDN(dn); err != nil {
+		return ldap.LDAPResultInvalidDNSyntax
+	}
+	if _, ok := d.entries[normalizeDN(dn)]; ok {
+		return ldap.LDAPResultEntryAlreadyExists
+	}
+	attributes := map[string][]string{}
+	for _, attribute := range op.Children[1].Children {
+		name, values := decodeAttribute(attribute)
+		attributes[name] = values
+	}
+	if len(attributes["objectClass"]) == 0 {
+		return ldap.LDAPResultObjectClassViolation
+	}
+	d.entries[normalizeDN(dn)] = ldap.NewEntry(dn, attributes)
+	return ldap.LDAPResultSuccess
+}
+
+func (d *fakeDirectory) modify(op *ber.Packet) uint16 {
+	dn := op.Children[0].Value.(string)
+	if _, err := ldap.ParseDN(dn); err != nil {
+		return ldap.LDAPResultInvalidDNSyntax
+	}
+	entry, ok := d.entries[normalizeDN(dn)]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject
+	}
+	attributes := map[string][]string{}
+	for _, attribute := range entry.Attributes {
+		attributes[attribute.Name] = attribute.Values
+	}
+	for _, change := range op.Children[1].Children {
+		name, values := decodeAttribute(change.Children[1])
+		switch change.Children[0].Value.(int64) {
+		case ldap.AddAttribute:
+			attributes[name] = append(attributes[name], values...)
+		case ldap.DeleteAttribute:
+			delete(attributes, name)
+		case ldap.ReplaceAttribute:
+			attributes[name] = values
+			if len(values) == 0 {
+				delete(attributes, name)
+			}
+		}
+	}
+	d.entries[normalizeDN(dn)] = ldap.NewEntry(entry.DN, attributes)
+	return ldap.LDAPResultSuccess
+}
+
+func (d *fakeDirectory) modifyDN(op *ber.Packet) uint16 {
+	from := normalizeDN(op.Children[0].Value.(string))
+	entry, ok := d.entries[from]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject
+	}
+	rdn := op.Children[1].Value.(string)
+	dn := rdn
+	if len(op.Children) > 3 {
+		dn += "," + op.Children[3].Data.String()
+	}
+	if _, ok := d.entries[normalizeDN(dn)]; ok {
+		return ldap.LDAPResultEntryAlreadyExists
+	}
+
+	attributes := map[string][]string{}
+	for _, attribute := range entry.Attributes {
+		attributes[attribute.Name] = attribute.Values
+	}
+	oldRDN, _ := ldap.ParseDN(entry.DN)
+	newRDN, _ := ldap.ParseDN(dn)
+	if op.Children[2].Value.(bool) {
+		for _, a := range oldRDN.RDNs[0].Attributes {
+			delete(attributes, a.Type)
+		}
+	}
+	for _, a := range newRDN.RDNs[0].Attributes {
+		attributes[a.Type] = append(attributes[a.Type], a.Value)
+	}
+	delete(d.entries, from)
+	d.entries[normalizeDN(dn)] = ldap.NewEntry(dn, attributes)
+	return ldap.LDAPResultSuccess
+}
+
+func dial(t *testing.T, config Config) *Directory {
+	dir, err := Dial(config)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { dir.Close() })
+	return dir
+}
+
+func TestDial(t *testing.T) {
+	_, config := newFakeDirectory(t)
+	dial(t, config)
+
+	config.BindPassword = "wrong"
+	_, err := Dial(config)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("Dial with a wrong password: got %v, want invalid credentials", err)
+	}
+}
+
+func TestWriteEntry(t *testing.T) {
+	d, config := newFakeDirectory(t)
+	dir := dial(t, config)
+	dn := "uid=jane,ou=people," + testBaseDN
+
+	added, err := dir.WriteEntry("", dn, []string{"inetOrgPerson"}, map[string][]string{
+		"cn":              {"Jane Doe"},
+		"mail":            {"jane@example.com"},
+		"telephoneNumber": nil,
+	})
+	if err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if !added {
+		t.Error("WriteEntry of a new entry reported it was not added")
+	}
+	want := map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"cn":          {"Jane Doe"},
+		"mail":        {"jane@example.com"},
+	}
+	if got := d.get(dn); !reflect.DeepEqual(got, want) {
+		t.Errorf("added entry = %v, want %v", got, want)
+	}
+
+	// Existing entries are replaced, removing attributes without values
+	added, err = dir.WriteEntry(dn, dn, []string{"inetOrgPerson"}, map[string][]string{
+		"cn":   {"Jane Roe"},
+		"mail": nil,
+	})
+	if err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if added {
+		t.Error("WriteEntry of an existing entry reported it was added")
+	}
+	want = map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"cn":          {"Jane Roe"},
+	}
+	if got := d.get(dn); !reflect.DeepEqual(got, want) {
+		t.Errorf("replaced entry = %v, want %v", got, want)
+	}
+}
+
+func TestWriteEntryMoves(t *testing.T) {
+	d, config := newFakeDirectory(t)
+	dir := dial(t, config)
+	from := "uid=jane,ou=people," + testBaseDN
+	to := "uid=jane.roe,ou=people," + testBaseDN
+	other := "uid=john,ou=people," + testBaseDN
+	group := "cn=admins,ou=groups," + testBaseDN
+	d.put(from, map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {"jane"}, "cn": {"Jane Doe"}})
+	d.put(group, map[string][]string{"objectClass": {"groupOfNames"}, "member": {other, from}})
+
+	// The DN is compared regardless of case and spacing
+	if _, err := dir.WriteEntry("UID=jane, ou=People,"+testBaseDN, from, []string{"inetOrgPerson"}, map[string][]string{"cn": {"Jane Doe"}}); err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if d.get(from) == nil {
+		t.Fatal("WriteEntry moved an entry whose DN did not change")
+	}
+
+	added, err := dir.WriteEntry(from, to, []string{"inetOrgPerson"}, map[string][]string{"cn": {"Jane Roe"}})
+	if err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if added {
+		t.Error("WriteEntry of a moved entry reported it was added")
+	}
+	if d.get(from) != nil {
+		t.Errorf("entry %s still exists after the move", from)
+	}
+	want := map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {"jane.roe"}, "cn": {"Jane Roe"}}
+	if got := d.get(to); !reflect.DeepEqual(got, want) {
+		t.Errorf("moved entry = %v, want %v", got, want)
+	}
+	if got, want := d.get(group)["member"], []string{other, to}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members after the move = %v, want %v", got, want)
+	}
+
+	// Entries deleted in the directory meanwhile are added at their new DN
+	moved := "uid=jane.doe,ou=people," + testBaseDN
+	added, err = dir.WriteEntry(from, moved, []string{"inetOrgPerson"}, map[string][]string{"cn": {"Jane Doe"}})
+	if err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if !added || d.get(moved) == nil {
+		t.Errorf("WriteEntry of a missing entry did not add it at %s", moved)
+	}
+}
+
+func TestDeleteEntry(t *testing.T) {
+	d, config := newFakeDirectory(t)
+	dir := dial(t, config)
+	jane := "uid=jane,ou=people," + testBaseDN
+	john := "uid=john,ou=people," + testBaseDN
+	group := "cn=admins,ou=groups," + testBaseDN
+	d.put(jane, map[string][]string{"objectClass": {"inetOrgPerson"}})
+	d.put(john, map[string][]string{"objectClass": {"inetOrgPerson"}})
+	d.put(group, map[string][]string{"objectClass": {"groupOfNames"}, "member": {jane, john}})
+
+	if err := dir.DeleteEntry(jane); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	if d.get(jane) != nil {
+		t.Errorf("entry %s still exists after its deletion", jane)
+	}
+	if got, want := d.get(group)["member"], []string{john}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+
+	// groupOfNames requires a member
+	if err := dir.DeleteEntry(john); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	if got, want := d.get(group)["member"], []string{EmptyGroupMember}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members = %q, want %q", got, want)
+	}
+
+	if err := dir.DeleteEntry(john); err != nil {
+		t.Errorf("DeleteEntry of a deleted entry: %v", err)
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	d, config := newFakeDirectory(t)
+	dir := dial(t, config)
+
+	_, err := dir.WriteEntry("", "not a dn", []string{"inetOrgPerson"}, map[string][]string{"cn": {"Jane Doe"}})
+	if !IsRejected(err) {
+		t.Errorf("WriteEntry with an invalid DN: got %v, want a rejection", err)
+	}
+	_, err = dir.WriteEntry("", "uid=jane,ou=people,"+testBaseDN, nil, map[string][]string{"cn": {"Jane Doe"}})
+	if !IsRejected(err) {
+		t.Errorf("WriteEntry without object classes: got %v, want a rejection", err)
+	}
+	if d.get("uid=jane,ou=people,"+testBaseDN) != nil {
+		t.Error("rejected entry was added")
+	}
+
+	if IsRejected(ldap.NewError(ldap.LDAPResultBusy, errors.New("busy"))) {
+		t.Error("IsRejected of a busy directory, want retries")
+	}
+	if IsRejected(errors.New("connection reset")) {
+		t.Error("IsRejected of a connection error, want retries")
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"okta-scim/db"
+	"okta-scim/ldapclient"
+)
+
+// sinkLDAP is the outbox sink type mirroring users and groups to an LDAP
+// directory.
+const sinkLDAP = "ldap"
+
+// defaultLDAPTargetTimeout bounds requests to LDAP targets without a timeout.
+const defaultLDAPTargetTimeout = 30 * time.Second
+
+// ldapTargetConfig configures an LDAP directory the users and groups of a
+// tenant are mirrored to, through the ldap:<name> outbox sink. Active users
+// are inetOrgPerson entries and groups groupOfNames entries unless other
+// object classes are configured.
+type ldapTargetConfig struct {
+	Name string `yaml:"name"`
+	// URL is the ldap:// or ldaps:// URL of the directory server.
+	URL string `yaml:"url"`
+	// StartTLS upgrades ldap:// connections to TLS.
+	StartTLS bool   `yaml:"startTLS"`
+	Tenant   string `yaml:"tenant"`
+	// BindDN and BindPassword authenticate to the directory.
+	BindDN       string `yaml:"bindDN"`
+	BindPassword string `yaml:"bindPassword"`
+	// BaseDN is the subtree searched for the groups referencing an entry, and
+	// holds the ou=people and ou=groups entries by default.
+	BaseDN  string          `yaml:"baseDN"`
+	Timeout time.Duration   `yaml:"timeout"`
+	Users   ldapEntryConfig `yaml:"users"`
+	Groups  ldapEntryConfig `yaml:"groups"`
+}
+
+// ldapEntryConfig maps users or groups to LDAP entries. DN and the attribute
+// values are text/template templates executed with the attributes of the
+// user (id, userName, email, name, givenName and familyName) or group (id
+// and displayName) and the fields of attributeMapping. Attributes rendered
+// empty are removed from the entry.
+type ldapEntryConfig struct {
+	DN            string            `yaml:"dn"`
+	ObjectClasses []string          `yaml:"objectClasses"`
+	Attributes    map[string]string `yaml:"attributes"`
+}
+
+// withDefaults returns the configuration with the defaults of the unset
+// fields.
+func (c ldapTargetConfig) withDefaults() ldapTargetConfig {
+	if c.Tenant == "" {
+		c.Tenant = defaultTenantName
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultLDAPTargetTimeout
+	}
+	if c.Users.DN == "" {
+		c.Users.DN = "uid={{.userName}},ou=people," + c.BaseDN
+	}
+	if len(c.Users.ObjectClasses) == 0 {
+		c.Users.ObjectClasses = []string{"inetOrgPerson"}
+	}
+	if c.Users.Attributes == nil {
+		c.Users.Attributes = map[string]string{
+			"uid":            "{{.userName}}",
+			"cn":             "{{or .name .userName}}",
+			"sn":             "{{or .familyName .name .userName}}",
+			"givenName":      "{{.givenName}}",
+			"displayName":    "{{.name}}",
+			"mail":           "{{.email}}",
+			"employeeNumber": "{{.id}}",
+		}
+	}
+	if c.Groups.DN == "" {
+		c.Groups.DN = "cn={{.displayName}},ou=groups," + c.BaseDN
+	}
+	if len(c.Groups.ObjectClasses) == 0 {
+		c.Groups.ObjectClasses = []string{"groupOfNames"}
+	}
+	if c.Groups.Attributes == nil {
+		c.Groups.Attributes = map[string]string{
+			"cn": "{{.displayName}}",
+		}
+	}
+	return c
+}
+
+// validateLDAPTargets checks the configured LDAP targets.
+func validateLDAPTargets(targets []ldapTargetConfig) error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, target := range targets {
+		if target.Name == "" || names[target.Name] {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d].name must be set and unique", i))
+		}
+		names[target.Name] = true
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d].url must be an ldap or ldaps URL", i))
+		}
+		if _, err := ldap.ParseDN(target.BaseDN); err != nil || target.BaseDN == "" {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d].baseDN must be a DN", i))
+		}
+		if (target.BindDN == "") != (target.BindPassword == "") {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d].bindDN and ldapTargets[%d].bindPassword must be set together", i, i))
+		}
+		if target.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d].timeout must not be negative", i))
+		}
+		if _, err := newLDAPTarget(target, nil, slog.Default()); err != nil {
+			errs = append(errs, fmt.Errorf("ldapTargets[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findLDAPTarget returns the configuration of the LDAP target with the name.
+func findLDAPTarget(targets []ldapTargetConfig, name string) (ldapTargetConfig, error) {
+	i := slices.IndexFunc(targets, func(t ldapTargetConfig) bool { return t.Name == name })
+	if i < 0 {
+		return ldapTargetConfig{}, fmt.Errorf("LDAP target %s not found in ldapTargets", name)
+	}
+	return targets[i], nil
+}
+
+// ldapEntryTemplate renders the entries of users or groups.
+type ldapEntryTemplate struct {
+	dn            *template.Template
+	objectClasses []string
+	attributes    map[string]*template.Template
+}
+
+func parseLDAPEntryTemplate(config ldapEntryConfig) (ldapEntryTemplate, error) {
+	entry := ldapEntryTemplate{
+		objectClasses: config.ObjectClasses,
+		attributes:    make(map[string]*template.Template, len(config.Attributes)),
+	}
+	var err error
+	if entry.dn, err = template.New("dn").Option("missingkey=zero").Parse(config.DN); err != nil {
+		return entry, err
+	}
+	for name, value := range config.Attributes {
+		if strings.EqualFold(name, "objectClass") || strings.EqualFold(name, "member") {
+			return entry, fmt.Errorf("attribute %s is managed and cannot be mapped", name)
+		}
+		if entry.attributes[name], err = template.New(name).Option("missingkey=zero").Parse(value); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// renderDN returns the DN of the entry with the attributes, escaped as DN
+// values.
+func (e ldapEntryTemplate) renderDN(data map[string]string) (string, error) {
+	escaped := make(map[string]string, len(data))
+	for key, value := range data {
+		escaped[key] = ldap.EscapeDN(value)
+	}
+	var dn strings.Builder
+	if err := e.dn.Execute(&dn, escaped); err != nil {
+		return "", err
+	}
+	if _, err := ldap.ParseDN(dn.String()); err != nil {
+		return "", fmt.Errorf("invalid DN %q: %w", dn.String(), err)
+	}
+	return dn.String(), nil
+}
+
+// renderAttributes returns the values of the mapped attributes, without
+// values for those rendered empty.
+func (e ldapEntryTemplate) renderAttributes(data map[string]string) (map[string][]string, error) {
+	attributes := make(map[string][]string, len(e.attributes))
+	for name, tmpl := range e.attributes {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, err
+		}
+		attributes[name] = nil
+		if value.Len() > 0 {
+			attributes[name] = []string{value.String()}
+		}
+	}
+	return attributes, nil
+}
+
+// userData returns the attributes of a user entry templates are executed
+// with, the mapped SCIM attributes of the user and its mapped fields.
+func (t *ldapTarget) userData(employee db.Employee) (map[string]string, error) {
+	user := userFromEmployee(employee)
+	attributes, err := t.mapping.userSCIM(user)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := t.mapping.userFields(user)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{
+		"id":         user.OktaID,
+		"userName":   attributes["userName"],
+		"email":      user.Email,
+		"name":       user.Name,
+		"givenName":  attributes["name.givenName"],
+		"familyName": attributes["name.familyName"],
+	}
+	maps.Copy(data, fields)
+	return data, nil
+}
+
+// groupData returns the attributes of a group entry templates are executed
+// with, the mapped SCIM attributes of the group and its mapped fields.
+func (t *ldapTarget) groupData(oktaID, name string) (map[string]string, error) {
+	attributes, err := t.mapping.groupSCIM(oktaID, name)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := t.mapping.groupFields(oktaID, name)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{
+		"id":          oktaID,
+		"displayName": attributes["displayName"],
+	}
+	maps.Copy(data, fields)
+	return data, nil
+}
+
+// ldapTarget mirrors the users and groups of a tenant to an LDAP directory,
+// recording the DN of each entry in the LdapTargetEntry table so that renamed
+// users and groups are moved. Deactivated users are removed from the
+// directory. As an outbox sink, it applies the changes of the tenant in order.
+type ldapTarget struct {
+	config  ldapTargetConfig
+	users   ldapEntryTemplate
+	groups  ldapEntryTemplate
+	mapping *attributeMapping
+	logger  *slog.Logger
+}
+
+func newLDAPTarget(config ldapTargetConfig, mapping *attributeMapping, logger *slog.Logger) (*ldapTarget, error) {
+	config = config.withDefaults()
+	users, err := parseLDAPEntryTemplate(config.Users)
+	if err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	groups, err := parseLDAPEntryTemplate(config.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("groups: %w", err)
+	}
+	return &ldapTarget{
+		config:  config,
+		users:   users,
+		groups:  groups,
+		mapping: mapping,
+		logger:  logger.With("ldap_target", config.Name),
+	}, nil
+}
+
+// dial connects and binds to the directory.
+func (t *ldapTarget) dial() (*ldapclient.Directory, error) {
+	return ldapclient.Dial(ldapclient.Config{
+		URL:          t.config.URL,
+		StartTLS:     t.config.StartTLS,
+		BindDN:       t.config.BindDN,
+		BindPassword: t.config.BindPassword,
+		BaseDN:       t.config.BaseDN,
+		Timeout:      t.config.Timeout,
+	})
+}
+
+// Publish applies the changes of the tenant of the target to it. Changes the
+// directory rejects as invalid are logged and skipped, other failures are
+// retried with the rest of the events.
+func (t *ldapTarget) Publish(ctx context.Context, q *db.Queries, events []db.Outbox) error {
+	tenant, err := q.GetTenantByName(ctx, t.config.Tenant)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("tenant %s of LDAP target %s not found", t.config.Tenant, t.config.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	dir, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	s := t.sync(dir, q, tenant.ID)
+	for _, event := range events {
+		if event.TenantID != tenant.ID {
+			continue
+		}
+		var change ChangeEvent
+		if err := json.Unmarshal(event.Payload, &change); err != nil {
+			return err
+		}
+
+		err := s.apply(ctx, change)
+		if ldapclient.IsRejected(err) {
+			ldapTargetOperationsTotal.WithLabelValues(t.config.Name, "rejected").Inc()
+			t.logger.Warn("LDAP target rejected change, skipping it",
+				"event_id", change.ID,
+				"event_type", change.Type,
+				"resource_id", change.ResourceID,
+				"error", err,
+			)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply %s of %s to LDAP target %s: %w", change.Type, change.ResourceID, t.config.Name, err)
+		}
+		ldapTargetOperationsTotal.WithLabelValues(t.config.Name, "applied").Inc()
+	}
+	return nil
+}
+
+// Resync mirrors every user and group of the tenant of the target through q,
+// and deletes the entries of users and groups that no longer exist.
+func (t *ldapTarget) Resync(ctx context.Context, q *db.Queries) (users, groups, deleted int, err error) {
+	tenant, err := q.GetTenantByName(ctx, t.config.Tenant)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("tenant %s of LDAP target %s: %w", t.config.Tenant, t.config.Name, err)
+	}
+	dir, err := t.dial()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer dir.Close()
+	s := t.sync(dir, q, tenant.ID)
+
+	local := map[string]bool{}
+	storedUsers, err := listAllUsers(ctx, q, tenant.ID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, user := range storedUsers {
+		if _, err := s.syncUser(ctx, user.OktaID); err != nil {
+			return users, groups, deleted, fmt.Errorf("user %s: %w", user.OktaID, err)
+		}
+		local[memberTypeUser+"/"+user.OktaID] = true
+		users++
+	}
+
+	storedGroups, err := listAllGroups(ctx, q, tenant.ID)
+	if err != nil {
+		return users, groups, deleted, err
+	}
+	for _, group := range storedGroups {
+		if _, err := s.syncGroup(ctx, group.OktaID.String); err != nil {
+			return users, groups, deleted, fmt.Errorf("group %s: %w", group.OktaID.String, err)
+		}
+		local[memberTypeGroup+"/"+group.OktaID.String] = true
+		groups++
+	}
+
+	entries, err := q.ListLdapTargetEntries(ctx, db.ListLdapTargetEntriesParams{Target: t.config.Name, TenantID: tenant.ID})
+	if err != nil {
+		return users, groups, deleted, err
+	}
+	for _, entry := range entries {
+		if local[entry.ResourceType+"/"+entry.LocalID] {
+			continue
+		}
+		if err := s.deleteEntry(ctx, entry.ResourceType, entry.LocalID); err != nil {
+			return users, groups, deleted, fmt.Errorf("entry %s: %w", entry.Dn, err)
+		}
+		deleted++
+	}
+	return users, groups, deleted, nil
+}
+
+func (t *ldapTarget) sync(dir *ldapclient.Directory, q *db.Queries, tenantID int32) *ldapTargetSync {
+	return &ldapTargetSync{target: t, dir: dir, q: q, tenantID: tenantID}
+}
+
+// ldapTargetSync applies the local state of users and groups of a tenant to
+// a directory.
+type ldapTargetSync struct {
+	target   *ldapTarget
+	dir      *ldapclient.Directory
+	q        *db.Queries
+	tenantID int32
+}
+
+// apply brings the entry of the resource of a change up to date. Entries are
+// written with the current local state rather than the state in the event,
+// so that replayed events do no harm.
+func (s *ldapTargetSync) apply(ctx context.Context, event ChangeEvent) error {
+	switch event.Type {
+	case eventUserCreated, eventUserUpdated, eventUserDeactivated:
+		added, err := s.syncUser(ctx, event.ResourceID)
+		if err != nil || !added {
+			return err
+		}
+		// Reactivated users are added back to their groups
+		return s.syncUserGroups(ctx, event.ResourceID)
+	case eventUserDeleted:
+		return s.deleteEntry(ctx, memberTypeUser, event.ResourceID)
+	case eventGroupCreated, eventGroupRenamed, eventGroupMemberAdded, eventGroupMemberRemoved:
+		_, err := s.syncGroup(ctx, event.ResourceID)
+		return err
+	case eventGroupDeleted:
+		return s.deleteEntry(ctx, memberTypeGroup, event.ResourceID)
+	}
+	return nil
+}
+
+// entryDN returns the DN of the entry of a user or group, or "" when it is
+// not mirrored.
+func (s *ldapTargetSync) entryDN(ctx context.Context, resourceType, localID string) (string, error) {
+	dn, err := s.q.GetLdapTargetEntry(ctx, db.GetLdapTargetEntryParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+	})
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return dn, err
+}
+
+// syncUser writes the entry of an active user and deletes that of a
+// deactivated one, reporting whether the entry was added. Nothing is done for
+// users deleted since, whose deletion follows.
+func (s *ldapTargetSync) syncUser(ctx context.Context, localID string) (bool, error) {
+	user, err := s.q.GetUserByOktaID(ctx, db.GetUserByOktaIDParams{TenantID: s.tenantID, OktaID: localID})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !user.Active {
+		return false, s.deleteEntry(ctx, memberTypeUser, localID)
+	}
+
+	data, err := s.target.userData(user)
+	if err != nil {
+		return false, err
+	}
+	dn, err := s.target.users.renderDN(data)
+	if err != nil {
+		return false, err
+	}
+	attributes, err := s.target.users.renderAttributes(data)
+	if err != nil {
+		return false, err
+	}
+	return s.writeEntry(ctx, memberTypeUser, localID, dn, s.target.users.objectClasses, attributes)
+}
+
+// syncUserGroups writes the entries of the groups a user is a direct member
+// of.
+func (s *ldapTargetSync) syncUserGroups(ctx context.Context, localID string) error {
+	user, err := s.q.GetUserByOktaID(ctx, db.GetUserByOktaIDParams{TenantID: s.tenantID, OktaID: localID})
+	if err != nil {
+		return err
+	}
+	groups, err := s.q.GetUsersGroups(ctx, db.GetUsersGroupsParams{TenantID: s.tenantID, EmployeeIds: []int32{user.ID}})
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if !group.Direct {
+			continue
+		}
+		if _, err := s.syncGroup(ctx, group.OktaID.String); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncGroup writes the entry of a group with the entries of its members,
+// reporting whether the entry was added. Nothing is done for groups deleted
+// since, whose deletion follows.
+func (s *ldapTargetSync) syncGroup(ctx context.Context, localID string) (bool, error) {
+	group, err := s.q.GetGroupByOktaID(ctx, db.GetGroupByOktaIDParams{
+		TenantID: s.tenantID,
+		OktaID:   sql.NullString{String: localID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	data, err := s.target.groupData(localID, group.Name)
+	if err != nil {
+		return false, err
+	}
+	dn, err := s.target.groups.renderDN(data)
+	if err != nil {
+		return false, err
+	}
+	attributes, err := s.target.groups.renderAttributes(data)
+	if err != nil {
+		return false, err
+	}
+	if attributes["member"], err = s.memberDNs(ctx, group.ID); err != nil {
+		return false, err
+	}
+	return s.writeEntry(ctx, memberTypeGroup, localID, dn, s.target.groups.objectClasses, attributes)
+}
+
+// memberDNs returns the DNs of the members of a group: the entries of its
+// users in the directory, which deactivated users have none of, and of its
+// member groups, which are rendered for groups that are not mirrored yet.
+func (s *ldapTargetSync) memberDNs(ctx context.Context, groupID int32) ([]string, error) {
+	users, err := s.q.GetGroupMembers(ctx, db.GetGroupMembersParams{TenantID: s.tenantID, OktaGroupID: groupID})
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.q.GetGroupMemberGroups(ctx, db.GetGroupMemberGroupsParams{TenantID: s.tenantID, GroupID: groupID})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []string
+	for _, user := range users {
+		dn, err := s.entryDN(ctx, memberTypeUser, user.OktaID)
+		if err != nil {
+			return nil, err
+		}
+		if dn != "" {
+			members = append(members, dn)
+		}
+	}
+	for _, group := range groups {
+		dn, err := s.entryDN(ctx, memberTypeGroup, group.OktaID.String)
+		if err == nil && dn == "" {
+			var data map[string]string
+			if data, err = s.target.groupData(group.OktaID.String, group.Name); err == nil {
+				dn, err = s.target.groups.renderDN(data)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, dn)
+	}
+	if len(members) == 0 {
+		members = []string{ldapclient.EmptyGroupMember}
+	}
+	return members, nil
+}
+
+// writeEntry moves the entry of a user or group to dn when its DN changed,
+// then replaces its attributes, adding it when it does not exist. The entry
+// is recorded with its DN, and whether it was added reported.
+func (s *ldapTargetSync) writeEntry(ctx context.Context, resourceType, localID, dn string, objectClasses []string, attributes map[string][]string) (bool, error) {
+	previousDN, err := s.entryDN(ctx, resourceType, localID)
+	if err != nil {
+		return false, err
+	}
+	added, err := s.dir.WriteEntry(previousDN, dn, objectClasses, attributes)
+	if err != nil {
+		return false, err
+	}
+
+	return added, s.q.SetLdapTargetEntry(ctx, db.SetLdapTargetEntryParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+		Dn:           dn,
+	})
+}
+
+// deleteEntry deletes the entry of a user or group, removes it from the groups
+// referencing it and forgets its DN.
+func (s *ldapTargetSync) deleteEntry(ctx context.Context, resourceType, localID string) error {
+	dn, err := s.entryDN(ctx, resourceType, localID)
+	if err != nil || dn == "" {
+		return err
+	}
+
+	if err := s.dir.DeleteEntry(dn); err != nil {
+		return err
+	}
+	return s.q.DeleteLdapTargetEntry(ctx, db.DeleteLdapTargetEntryParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"okta-scim/db"
+)
+
+// userLifecyclePolicy controls how users deleted or deactivated by Okta are
+// retained and when their personal data is erased.
+type userLifecyclePolicy struct {
+	// HardDelete makes DELETE /Users/:id remove the user instead of deactivating it.
+	HardDelete bool `yaml:"hardDelete"`
+	// Retention is how long deactivated users are kept before being purged.
+	// Zero keeps them forever.
+	Retention time.Duration `yaml:"retention"`
+	// Anonymize purges users by replacing their personal data instead of
+	// deleting their rows.
+	Anonymize bool `yaml:"anonymize"`
+	// PurgeInterval is how often the purge job runs.
+	PurgeInterval time.Duration `yaml:"purgeInterval"`
+}
+
+func (p userLifecyclePolicy) validate() error {
+	if p.Retention < 0 {
+		return errors.New("users.retention must not be negative")
+	}
+	if p.PurgeInterval <= 0 {
+		return errors.New("users.purgeInterval must be positive")
+	}
+	return nil
+}
+
+// purgeActor is the actor the audit log attributes purges by the purge job to.
+const purgeActor = "purge"
+
+// userPurger erases deactivated users once their retention period has passed.
+type userPurger struct {
+	policy userLifecyclePolicy
+	db     *db.Queries
+	dbConn *sql.DB
+	logger *slog.Logger
+}
+
+func newUserPurger(policy userLifecyclePolicy, queries *db.Queries, dbConn *sql.DB, logger *slog.Logger) *userPurger {
+	return &userPurger{
+		policy: policy,
+		db:     queries,
+		dbConn: dbConn,
+		logger: logger,
+	}
+}
+
+// Run purges users every PurgeInterval until ctx is canceled. It does nothing
+// when no retention period is configured.
+func (p *userPurger) Run(ctx context.Context) {
+	if p.policy.Retention == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.policy.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.PurgeOnce(ctx); err != nil {
+			p.logger.Error("Error purging deactivated users", "error", err)
+		} else if purged > 0 {
+			p.logger.Info("Purged deactivated users", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce purges the users of every tenant deactivated for longer than the
+// retention period, see PurgeTenant, and returns the number of users purged.
+func (p *userPurger) PurgeOnce(ctx context.Context) (int64, error) {
+	tenants, err := p.db.ListTenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, tenant := range tenants {
+		n, err := p.PurgeTenant(ctx, tenant)
+		if err != nil {
+			return purged, fmt.Errorf("tenant %s: %w", tenant.Name, err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// PurgeTenant deletes or anonymizes the users of tenant deactivated for longer
+// than the retention period, together with their group memberships, and
+// returns the number of users purged. The removal of each membership and the
+// purge of each user are recorded in the audit log and the outbox in the same
+// transaction, so that the sinks delete purged users downstream. Changes are
+// attributed to the credential in ctx, or to purgeActor.
+func (p *userPurger) PurgeTenant(ctx context.Context, tenant db.Tenant) (int64, error) {
+	cutoff := time.Now().Add(-p.policy.Retention)
+	ctx = withTenant(ctx, tenant, "")
+	if credentialFromContext(ctx) == "" {
+		ctx = context.WithValue(ctx, credentialKey, purgeActor)
+	}
+
+	tx, err := p.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	q := db.New(instrumentDB(tx))
+
+	users, err := q.ListPurgeableUsers(ctx, db.ListPurgeableUsersParams{TenantID: tenant.ID, Cutoff: cutoff})
+	if err != nil {
+		return 0, err
+	}
+	// Anonymized users are only purged again by deleting them
+	if p.policy.Anonymize {
+		users = slices.DeleteFunc(users, func(u db.Employee) bool { return u.PurgedAt.Valid })
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	var audits []auditEntry
+	for _, user := range users {
+		removals, err := removeUserMemberships(ctx, q, tenant.ID, user)
+		if err != nil {
+			return 0, err
+		}
+		audits = append(audits, removals...)
+
+		operation := auditPurge
+		if p.policy.Anonymize {
+			operation = auditAnonymize
+			_, err = q.AnonymizePurgeableUser(ctx, db.AnonymizePurgeableUserParams{TenantID: tenant.ID, ID: user.ID})
+		} else {
+			_, err = q.DeletePurgeableUser(ctx, db.DeletePurgeableUserParams{TenantID: tenant.ID, ID: user.ID})
+		}
+		if err != nil {
+			return 0, err
+		}
+		// Only the id is recorded, the data being erased must not outlive the
+		// user in the audit log or the events
+		audits = append(audits, auditEntry{
+			Operation:    operation,
+			ResourceType: "User",
+			ResourceID:   user.OktaID,
+			Before:       map[string]interface{}{"externalId": user.OktaID},
+		})
+	}
+
+	for _, entry := range audits {
+		if err := recordAudit(ctx, q, entry); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, entry := range audits {
+		provisioningOperationsTotal.WithLabelValues(entry.ResourceType, entry.Operation).Inc()
+	}
+	return int64(len(users)), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const scimBasePath = "/scim/v2"
+
+// resourceLocator builds the absolute URLs reported in meta.location and the
+// Location response header.
+type resourceLocator struct {
+	baseURL        *url.URL
+	trustedProxies []*net.IPNet
+}
+
+// newResourceLocator creates a resourceLocator. baseURL is the externally
+// visible SCIM base URL (e.g. https://scim.example.com/scim/v2); when it is
+// empty the base URL is derived from each request. trustedProxies lists the
+// IPs or CIDRs whose X-Forwarded-Proto and X-Forwarded-Host headers are honored.
+func newResourceLocator(baseURL string, trustedProxies []string) (*resourceLocator, error) {
+	l := &resourceLocator{}
+
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCIM base URL %q: %v", baseURL, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("SCIM base URL %q must be absolute", baseURL)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		l.baseURL = u
+	}
+
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		l.trustedProxies = append(l.trustedProxies, ipNet)
+	}
+
+	return l, nil
+}
+
+// location returns the absolute URL of the resource with the given id under
+// the resource endpoint (e.g. "Users" or "Groups").
+func (l *resourceLocator) location(r *http.Request, endpoint, id string) string {
+	base := l.base(r)
+	// Path holds the id unescaped and RawPath escaped, so that an id
+	// containing "/" stays a single segment
+	base.RawPath = base.EscapedPath() + "/" + endpoint + "/" + url.PathEscape(id)
+	base.Path += "/" + endpoint + "/" + id
+	return base.String()
+}
+
+// endpoint returns the absolute URL of a SCIM endpoint that is not a resource,
+// e.g. ServiceProviderConfig.
+func (l *resourceLocator) endpoint(r *http.Request, endpoint string) string {
+	base := l.base(r)
+	base.Path += "/" + endpoint
+	return base.String()
+}
+
+// base returns the SCIM base URL of the tenant of the request. Tenants
+// selected by path are served under /t/{tenant}/scim/v2.
+func (l *resourceLocator) base(r *http.Request) url.URL {
+	var base url.URL
+	if l.baseURL != nil {
+		base = *l.baseURL
+	} else {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto != "" && l.fromTrustedProxy(r) {
+			scheme = proto
+		}
+		base = url.URL{Scheme: scheme, Host: l.host(r), Path: scimBasePath}
+	}
+
+	if prefix := tenantPathFromContext(r.Context()); prefix != "" {
+		base.Path = strings.TrimSuffix(base.Path, scimBasePath) + prefix + scimBasePath
+	}
+	return base
+}
+
+// host returns the host the client sent the request to, as reported by a
+// trusted proxy when there is one.
+func (l *resourceLocator) host(r *http.Request) string {
+	if forwardedHost := firstHeaderValue(r, "X-Forwarded-Host"); forwardedHost != "" && l.fromTrustedProxy(r) {
+		return forwardedHost
+	}
+	return r.Host
+}
+
+// hostname returns the host the client sent the request to without port,
+// in lower case.
+func (l *resourceLocator) hostname(r *http.Request) string {
+	host := l.host(r)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (l *resourceLocator) fromTrustedProxy(r *http.Request) bool {
+	if len(l.trustedProxies) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range l.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the left-most value of a possibly comma-separated
+// header, which is the one set by the proxy closest to the client.
+func firstHeaderValue(r *http.Request, name string) string {
+	value := r.Header.Get(name)
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
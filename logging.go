@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// accessLogMode controls how much of each request is written to the access log.
+type accessLogMode string
+
+const (
+	// accessLogOff disables the access log.
+	accessLogOff accessLogMode = "off"
+	// accessLogMetadata logs request metadata without the body.
+	accessLogMetadata accessLogMode = "metadata"
+	// accessLogRedactedBody additionally logs the request body with sensitive
+	// attributes redacted.
+	accessLogRedactedBody accessLogMode = "redacted-body"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+
+	defaultMaxLoggedBodyBytes = 4096
+
+	scimCoreSchemaPrefix = "urn:ietf:params:scim:schemas:core:2.0:"
+)
+
+// alwaysRedactedAttributes are never written to the logs, whatever the configuration.
+var alwaysRedactedAttributes = []string{"password"}
+
+// accessLogConfig configures the access log written by loggingMiddleware.
+type accessLogConfig struct {
+	Mode accessLogMode `yaml:"mode"`
+	// RedactAttributes are the SCIM attribute paths redacted from logged
+	// bodies in addition to alwaysRedactedAttributes, e.g. name.givenName.
+	RedactAttributes []string `yaml:"redactAttributes"`
+	// RedactPaths are the parsed redacted attribute paths, each split into the
+	// segments to follow from the top-level object. They are set by compile.
+	RedactPaths [][]string `yaml:"-"`
+	// MaxBodyBytes truncates logged bodies.
+	MaxBodyBytes int `yaml:"maxBodyBytes"`
+}
+
+func (c accessLogConfig) validate() error {
+	switch c.Mode {
+	case accessLogOff, accessLogMetadata, accessLogRedactedBody:
+	default:
+		return fmt.Errorf("invalid accessLog.mode %q: must be off, metadata or redacted-body", c.Mode)
+	}
+	if c.MaxBodyBytes <= 0 {
+		return errors.New("accessLog.maxBodyBytes must be positive")
+	}
+	return nil
+}
+
+// compile returns the configuration with RedactPaths parsed.
+func (c accessLogConfig) compile() accessLogConfig {
+	attributes := append(append([]string(nil), alwaysRedactedAttributes...), c.RedactAttributes...)
+	c.RedactPaths = parseRedactPaths(attributes)
+	return c
+}
+
+// parseRedactPaths splits SCIM attribute paths into segments. Paths may be
+// qualified with their schema URN; attributes of the core schemas live at the
+// top level of a resource while extension attributes are nested under the URN.
+func parseRedactPaths(paths []string) [][]string {
+	var parsed [][]string
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		var segments []string
+		if strings.HasPrefix(strings.ToLower(path), "urn:") {
+			i := strings.LastIndex(path, ":")
+			urn, attribute := path[:i], path[i+1:]
+			if !strings.HasPrefix(strings.ToLower(urn)+":", scimCoreSchemaPrefix) {
+				segments = append(segments, urn)
+			}
+			path = attribute
+		}
+		segments = append(segments, strings.Split(path, ".")...)
+		parsed = append(parsed, segments)
+	}
+	return parsed
+}
+
+// redactBody returns body with the configured attributes redacted, truncated
+// to MaxBodyBytes. Bodies that are not JSON are not logged.
+func (c accessLogConfig) redactBody(body []byte) (redacted string, truncated bool) {
+	if len(body) == 0 {
+		return "", false
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return redactedValue, false
+	}
+	for _, path := range c.RedactPaths {
+		redactPath(document, path)
+	}
+
+	out, err := json.Marshal(document)
+	if err != nil {
+		return redactedValue, false
+	}
+	if len(out) > c.MaxBodyBytes {
+		return string(out[:c.MaxBodyBytes]), true
+	}
+	return string(out), false
+}
+
+// redactPath replaces the attribute at path in v. Attribute names match
+// case-insensitively and multi-valued attributes are redacted in every value.
+func redactPath(v interface{}, path []string) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			redactPath(item, path)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			if !strings.EqualFold(key, path[0]) {
+				continue
+			}
+			if len(path) == 1 {
+				v[key] = redactedValue
+			} else {
+				redactPath(value, path[1:])
+			}
+		}
+	}
+}
+
+var quotedFilterValue = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// redactQuery masks the comparison values of SCIM filters, which typically
+// contain user names and emails.
+func redactQuery(query url.Values) string {
+	if filter := query.Get("filter"); filter != "" {
+		query = cloneValues(query)
+		query.Set("filter", quotedFilterValue.ReplaceAllString(filter, `"`+redactedValue+`"`))
+	}
+	return query.Encode()
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+	return clone
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - int64(b.Len()); int64(len(p)) > remaining {
+		b.Buffer.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// teeReadCloser copies everything read from the request body to w.
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	return n, err
+}
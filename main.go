@@ -1,59 +1,178 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/julienschmidt/httprouter"
 	_ "github.com/lib/pq"
-	"main/db"
+	"okta-scim/db"
 )
 
 func main() {
-	connStr := "user=user password=password dbname=scim sslmode=disable"
-	dbConn, err := sql.Open("postgres", connStr)
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// runServe runs the SCIM server until it receives SIGTERM or SIGINT.
+func runServe(cfg *config) error {
+	shutdownTracing, err := initTracing(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
-	user := os.Getenv("SCIM_USER")
-	password := os.Getenv("SCIM_PASSWORD")
+	dbConn, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
 
-	if password == "" || user == "" {
-		log.Fatal("Requires environment variables SCIM_USER and SCIM_PASSWORD")
+	// Client certificates can be used alongside or instead of basic authentication
+	certCredentials, err := parseCertCredentials(cfg.Auth.ClientCertCredentials)
+	if err != nil {
+		return err
+	}
+	credentialScopes, err := parseCredentialScopes(cfg.Auth.CredentialScopes)
+	if err != nil {
+		return err
 	}
 
-	oktaDomain := os.Getenv("OKTA_DOMAIN")
-	if oktaDomain == "" {
-		log.Fatal("Requires environment variable OKTA_DOMAIN")
+	locator, err := newResourceLocator(cfg.Server.BaseURL, cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	mapping, err := newAttributeMapping(cfg.AttributeMapping)
+	if err != nil {
+		return err
+	}
+
+	registerDBStats(dbConn, "scim")
+	queries := db.New(instrumentDB(dbConn))
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	// Okta is optional, the client is created when first used
+	oktaClient := newLazyOktaClient(cfg.Okta, logger.With("component", "okta"))
+	if !oktaClient.Enabled() {
+		logger.Info("Okta credentials are not configured, running in SCIM-only mode")
 	}
 
-	oktaAPIToken := os.Getenv("OKTA_API_TOKEN")
-	if oktaAPIToken == "" {
-		log.Fatal("Requires environment variable OKTA_API_TOKEN")
+	features := map[string]bool{
+		"okta":            oktaClient.Enabled(),
+		"tracing":         tracingEnabled(),
+		"hardDelete":      cfg.Users.HardDelete,
+		"userPurge":       cfg.Users.Retention > 0,
+		"accessLogBodies": cfg.AccessLog.Mode == accessLogRedactedBody,
+		"metrics":         cfg.Features.Metrics,
+		"auditAPI":        cfg.Features.AuditAPI,
 	}
+	for _, feature := range oktaFeatures {
+		features[feature] = oktaClient.Enabled()
+	}
+
+	idempotency := newIdempotencyKeys(cfg.Idempotency, queries, logger.With("component", "idempotency"))
+	h := NewHandler(cfg.Auth.Username, cfg.Auth.Password, logger, queries, dbConn, oktaClient, locator, cfg.Users, cfg.AccessLog.compile(), cfg.Limits.MaxRequestBodyBytes, newRequestLimiter(cfg.Limits), idempotency, certCredentials, credentialScopes, features, mapping)
+
+	// Shut down gracefully on SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// Purge deactivated users once their retention period has passed
+	purger := newUserPurger(cfg.Users, queries, dbConn, logger.With("component", "purge"))
+	go purger.Run(ctx)
 
-	oktaClient, err := newOktaClient(oktaDomain, oktaAPIToken)
+	// Delete idempotency keys once their responses are no longer replayed
+	go idempotency.Run(ctx)
 
-	queries := db.New(dbConn)
+	// Publish the change events written to the outbox to the sinks
+	outbox, err := newOutboxDispatcher(cfg.Outbox, cfg.SCIMTargets, cfg.LDAPTargets, mapping, queries, dbConn, logger.With("component", "outbox"))
+	if err != nil {
+		return err
+	}
+	go outbox.Run(ctx)
 
-	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-	h := NewHandler(user, password, logger, queries, dbConn, oktaClient)
+	// Deliver provisioning events to the webhook endpoints of the tenants
+	if !cfg.Outbox.publishesWebhooks() {
+		if endpoints, err := queries.CountWebhookEndpoints(ctx); err != nil {
+			logger.Error("Error counting webhook endpoints", "error", err)
+		} else if endpoints > 0 {
+			logger.Warn("Webhook endpoints exist but no webhook outbox sink is configured, no events are delivered to them", "endpoints", endpoints)
+		}
+	}
+	webhooks := newWebhookDispatcher(cfg.Webhooks, queries, &http.Client{}, logger.With("component", "webhooks"))
+	go webhooks.Run(ctx)
+
+	readinessOkta := oktaClient
+	if !cfg.Okta.ReadinessCheck {
+		readinessOkta = nil
+	}
+	health := newHealthChecker(queries, dbConn, readinessOkta, cfg.Okta.ReadinessCacheTTL, features)
 
 	router := httprouter.New()
-	router.GET("/scim/v2/Users/:id", h.GetUser())
-	router.GET("/scim/v2/Users", h.GetUsers())
-	router.POST("/scim/v2/Users", h.CreateUser())
-	router.PUT("/scim/v2/Users/:id", h.UpdateUser())
-	router.DELETE("/scim/v2/Users/:id", h.DeactivateUser())
-
-	router.POST("/scim/v2/Groups", h.CreateGroup())
-	router.GET("/scim/v2/Groups/:id", h.GetGroup())
-	router.GET("/scim/v2/Groups", h.ListGroups())
-	router.PUT("/scim/v2/Groups/:id", h.UpdateGroup())
-	router.DELETE("/scim/v2/Groups/:id", h.DeleteGroup())
-
-	log.Fatal(http.ListenAndServe(":8080", router))
+	route := func(method, path string, handle httprouter.Handle) {
+		router.Handle(method, path, instrumentRoute(path, traceRoute(path, handle)))
+	}
+	// Every tenant is served under /t/{tenant}; the default tenant and
+	// tenants assigned a host are also served without the prefix.
+	for _, prefix := range []string{"", "/t/:tenant"} {
+		route(http.MethodGet, prefix+"/scim/v2/Users/:id", h.GetUser())
+		route(http.MethodGet, prefix+"/scim/v2/Users", h.GetUsers())
+		route(http.MethodPost, prefix+"/scim/v2/Users", h.CreateUser())
+		route(http.MethodPut, prefix+"/scim/v2/Users/:id", h.UpdateUser())
+		route(http.MethodDelete, prefix+"/scim/v2/Users/:id", h.DeactivateUser())
+
+		route(http.MethodPost, prefix+"/scim/v2/Groups", h.CreateGroup())
+		route(http.MethodGet, prefix+"/scim/v2/Groups/:id", h.GetGroup())
+		route(http.MethodGet, prefix+"/scim/v2/Groups", h.ListGroups())
+		route(http.MethodPut, prefix+"/scim/v2/Groups/:id", h.UpdateGroup())
+		route(http.MethodDelete, prefix+"/scim/v2/Groups/:id", h.DeleteGroup())
+
+		route(http.MethodGet, prefix+"/scim/v2/ServiceProviderConfig", h.ServiceProviderConfig())
+
+		if cfg.Features.AuditAPI {
+			route(http.MethodGet, prefix+"/admin/audit", h.ListAuditLog())
+		}
+	}
+
+	route(http.MethodGet, "/admin/tenants", h.ListTenants())
+	route(http.MethodPost, "/admin/tenants", h.CreateTenant())
+	route(http.MethodGet, "/admin/tenants/:name", h.GetTenant())
+	route(http.MethodPut, "/admin/tenants/:name", h.UpdateTenant())
+	route(http.MethodDelete, "/admin/tenants/:name", h.DeleteTenant())
+	route(http.MethodPost, "/admin/tenants/:name/credentials", h.CreateTenantCredential())
+	route(http.MethodPost, "/admin/tenants/:name/credentials/:credential/rotate", h.RotateTenantCredential())
+	route(http.MethodDelete, "/admin/tenants/:name/credentials/:credential", h.RevokeTenantCredential())
+
+	if cfg.Features.Metrics {
+		router.Handler(http.MethodGet, "/metrics", metricsHandler())
+	}
+	router.GET("/healthz", health.Healthz())
+	router.GET("/readyz", health.Readyz())
+	router.GET("/version", health.Version())
+
+	if err := serve(ctx, cfg.Server, router, health, logger); err != nil {
+		logger.Error("Server stopped", "error", err)
+		return err
+	}
+	return nil
+}
+
+// openDatabase opens the connection pool described by config.
+func openDatabase(config databaseConfig) (*sql.DB, error) {
+	dbConn, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	dbConn.SetMaxOpenConns(config.MaxOpenConns)
+	dbConn.SetMaxIdleConns(config.MaxIdleConns)
+	dbConn.SetConnMaxLifetime(config.ConnMaxLifetime)
+	dbConn.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	return dbConn, nil
 }
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+)
+
+// mappingCostLimit bounds the work of evaluating a single mapping expression,
+// so that expressions cannot stall requests or the outbox.
+const mappingCostLimit = 10000
+
+// attributeMappingConfig declares how the attributes of users and groups map
+// to the stored columns, to their SCIM representation and to the fields of
+// the downstream connectors. Each mapping is a CEL expression evaluating to a
+// string, with the string extensions (lowerAscii, split, join, trim, ...),
+// optional values (resource.?title.orValue("")) and the functions
+// concat(list), default(value, fallback) and lookup(table, key[, fallback])
+// of the tables in Lookups. Unset mappings keep their defaults, which map
+// userName to the email column and split the name column into the given and
+// family names.
+type attributeMappingConfig struct {
+	// Lookups are the tables read with lookup(table, key), by name.
+	Lookups map[string]map[string]string `yaml:"lookups"`
+	Users   resourceMappingConfig        `yaml:"users"`
+	Groups  resourceMappingConfig        `yaml:"groups"`
+}
+
+// resourceMappingConfig maps the attributes of a resource type.
+type resourceMappingConfig struct {
+	// Storage maps the stored columns to expressions over resource, the SCIM
+	// resource of a create or replace request.
+	Storage map[string]string `yaml:"storage"`
+	// SCIM maps SCIM attributes to expressions over user or group, the
+	// stored resource, for responses and SCIM targets.
+	SCIM map[string]string `yaml:"scim"`
+	// Fields maps additional connector fields, such as the attributes LDAP
+	// entry templates are executed with, to expressions over user or group.
+	Fields map[string]string `yaml:"fields"`
+}
+
+// The stored columns and SCIM attributes that can be mapped.
+var (
+	userStorageColumns  = []string{"email", "name"}
+	userSCIMAttributes  = []string{"userName", "name.givenName", "name.middleName", "name.familyName", "emails.value"}
+	groupStorageColumns = []string{"name"}
+	groupSCIMAttributes = []string{"displayName"}
+)
+
+// stringSliceType is the native type concat converts its list to.
+var stringSliceType = reflect.TypeOf([]string{})
+
+// mappingFieldName matches the names of connector fields, which LDAP entry
+// templates refer to as {{.name}}.
+var mappingFieldName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// withDefaults returns the configuration with the mappings that are not set
+// replaced by the defaults.
+func (c attributeMappingConfig) withDefaults() attributeMappingConfig {
+	c.Users = c.Users.withDefaults(resourceMappingConfig{
+		Storage: map[string]string{
+			"email": `resource.userName`,
+			"name":  `resource.name.givenName + " " + resource.name.familyName`,
+		},
+		SCIM: map[string]string{
+			"userName":        `user.email`,
+			"name.givenName":  `user.name.trim().split(" ", 2)[0]`,
+			"name.middleName": `""`,
+			"name.familyName": `user.name.trim().split(" ", 2)[?1].orValue("").split(" ").filter(n, n != "").join(" ")`,
+			"emails.value":    `user.email`,
+		},
+	})
+	c.Groups = c.Groups.withDefaults(resourceMappingConfig{
+		Storage: map[string]string{"name": `resource.displayName`},
+		SCIM:    map[string]string{"displayName": `group.name`},
+	})
+	return c
+}
+
+func (c resourceMappingConfig) withDefaults(defaults resourceMappingConfig) resourceMappingConfig {
+	merge := func(set, defaults map[string]string) map[string]string {
+		merged := make(map[string]string, len(defaults)+len(set))
+		for k, v := range defaults {
+			merged[k] = v
+		}
+		for k, v := range set {
+			merged[k] = v
+		}
+		return merged
+	}
+	c.Storage = merge(c.Storage, defaults.Storage)
+	c.SCIM = merge(c.SCIM, defaults.SCIM)
+	c.Fields = merge(c.Fields, defaults.Fields)
+	return c
+}
+
+// validate checks that every mapping names a known column, attribute or a
+// valid field name and compiles to a string expression.
+func (c attributeMappingConfig) validate() error {
+	_, err := newAttributeMapping(c)
+	return err
+}
+
+// attributeMapping evaluates the compiled mappings of users and groups.
+type attributeMapping struct {
+	users  resourceMapping
+	groups resourceMapping
+}
+
+// resourceMapping holds the compiled expressions of a resource type, by
+// column, attribute or field.
+type resourceMapping struct {
+	storage map[string]cel.Program
+	scim    map[string]cel.Program
+	fields  map[string]cel.Program
+}
+
+func newAttributeMapping(config attributeMappingConfig) (*attributeMapping, error) {
+	config = config.withDefaults()
+	users, userErr := compileResourceMapping("attributeMapping.users", config.Users, config.Lookups, "user", userStorageColumns, userSCIMAttributes)
+	groups, groupErr := compileResourceMapping("attributeMapping.groups", config.Groups, config.Lookups, "group", groupStorageColumns, groupSCIMAttributes)
+	if err := errors.Join(userErr, groupErr); err != nil {
+		return nil, err
+	}
+	return &attributeMapping{users: users, groups: groups}, nil
+}
+
+func compileResourceMapping(path string, config resourceMappingConfig, lookups map[string]map[string]string, variable string, columns, attributes []string) (resourceMapping, error) {
+	var errs []error
+	compile := func(section, kind string, exprs map[string]string, known func(string) bool, env *cel.Env) map[string]cel.Program {
+		programs := make(map[string]cel.Program, len(exprs))
+		for _, name := range sortedKeys(exprs) {
+			key := fmt.Sprintf("%s.%s.%s", path, section, name)
+			if !known(name) {
+				errs = append(errs, fmt.Errorf("%s: %s is not a mappable %s", key, name, kind))
+				continue
+			}
+			prg, err := compileMappingExpression(env, exprs[name])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				continue
+			}
+			programs[name] = prg
+		}
+		return programs
+	}
+
+	resourceEnv, err := newMappingEnv("resource", lookups)
+	if err != nil {
+		return resourceMapping{}, err
+	}
+	storedEnv, err := newMappingEnv(variable, lookups)
+	if err != nil {
+		return resourceMapping{}, err
+	}
+	mapping := resourceMapping{
+		storage: compile("storage", "column", config.Storage, func(name string) bool { return slices.Contains(columns, name) }, resourceEnv),
+		scim:    compile("scim", "SCIM attribute", config.SCIM, func(name string) bool { return slices.Contains(attributes, name) }, storedEnv),
+		fields:  compile("fields", "field name", config.Fields, mappingFieldName.MatchString, storedEnv),
+	}
+	return mapping, errors.Join(errs...)
+}
+
+// newMappingEnv returns the CEL environment of mapping expressions over the
+// variable, a map of the attributes of a resource.
+func newMappingEnv(variable string, lookups map[string]map[string]string) (*cel.Env, error) {
+	lookup := func(table, key ref.Val) (string, bool, ref.Val) {
+		values, ok := lookups[string(table.(types.String))]
+		if !ok {
+			return "", false, types.NewErr("lookup table %q is not configured", table.Value())
+		}
+		value, ok := values[string(key.(types.String))]
+		return value, ok, nil
+	}
+
+	return cel.NewEnv(
+		cel.Variable(variable, cel.MapType(cel.StringType, cel.DynType)),
+		cel.OptionalTypes(),
+		ext.Strings(),
+		cel.Function("concat",
+			cel.Overload("concat_list", []*cel.Type{cel.ListType(cel.DynType)}, cel.StringType,
+				cel.UnaryBinding(func(list ref.Val) ref.Val {
+					values, err := list.ConvertToNative(stringSliceType)
+					if err != nil {
+						return types.NewErr("concat: %v", err)
+					}
+					return types.String(strings.Join(values.([]string), ""))
+				}))),
+		cel.Function("default",
+			cel.Overload("default_dyn_dyn", []*cel.Type{cel.DynType, cel.DynType}, cel.DynType,
+				cel.BinaryBinding(func(value, fallback ref.Val) ref.Val {
+					if value == types.NullValue || value == types.String("") {
+						return fallback
+					}
+					return value
+				}))),
+		cel.Function("lookup",
+			cel.Overload("lookup_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.StringType,
+				cel.BinaryBinding(func(table, key ref.Val) ref.Val {
+					value, _, err := lookup(table, key)
+					if err != nil {
+						return err
+					}
+					return types.String(value)
+				})),
+			cel.Overload("lookup_string_string_string", []*cel.Type{cel.StringType, cel.StringType, cel.StringType}, cel.StringType,
+				cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+					value, ok, err := lookup(args[0], args[1])
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return args[2]
+					}
+					return types.String(value)
+				}))),
+	)
+}
+
+// compileMappingExpression compiles an expression that evaluates to a string.
+func compileMappingExpression(env *cel.Env, expr string) (cel.Program, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, errors.New("expression must not be empty")
+	}
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	switch ast.OutputType().Kind() {
+	case types.StringKind, types.DynKind, types.NullTypeKind:
+	default:
+		return nil, fmt.Errorf("expression evaluates to %s, not a string", ast.OutputType())
+	}
+	return env.Program(ast, cel.CostLimit(mappingCostLimit))
+}
+
+// evalMapping evaluates the programs against a resource held in the variable,
+// returning the string each evaluates to. Expressions evaluating to null map
+// to the empty string.
+func evalMapping(programs map[string]cel.Program, variable string, resource map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(programs))
+	for name, prg := range programs {
+		out, _, err := prg.Eval(map[string]interface{}{variable: resource})
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %w", name, err)
+		}
+		switch v := out.(type) {
+		case types.String:
+			values[name] = string(v)
+		case types.Null:
+			values[name] = ""
+		default:
+			return nil, fmt.Errorf("mapping %s evaluates to %s, not a string", name, out.Type().TypeName())
+		}
+	}
+	return values, nil
+}
+
+// userColumns are the stored columns of a user that are mapped from SCIM.
+type userColumns struct {
+	Email string
+	Name  string
+}
+
+// userColumns maps the attributes of a SCIM user in a create or replace
+// request to the stored columns.
+func (m *attributeMapping) userColumns(resource map[string]interface{}) (userColumns, error) {
+	values, err := evalMapping(m.users.storage, "resource", userResourceAttributes(resource))
+	if err != nil {
+		return userColumns{}, err
+	}
+	return userColumns{Email: values["email"], Name: values["name"]}, nil
+}
+
+// groupName maps the attributes of a SCIM group in a create or replace
+// request to the stored name.
+func (m *attributeMapping) groupName(resource map[string]interface{}) (string, error) {
+	values, err := evalMapping(m.groups.storage, "resource", groupResourceAttributes(resource))
+	if err != nil {
+		return "", err
+	}
+	return values["name"], nil
+}
+
+// userSCIM returns the mapped SCIM attributes of a stored user.
+func (m *attributeMapping) userSCIM(user *User) (map[string]string, error) {
+	return evalMapping(m.users.scim, "user", storedUserAttributes(user))
+}
+
+// userFields returns the mapped connector fields of a stored user.
+func (m *attributeMapping) userFields(user *User) (map[string]string, error) {
+	return evalMapping(m.users.fields, "user", storedUserAttributes(user))
+}
+
+// groupSCIM returns the mapped SCIM attributes of a stored group.
+func (m *attributeMapping) groupSCIM(oktaID, name string) (map[string]string, error) {
+	return evalMapping(m.groups.scim, "group", storedGroupAttributes(oktaID, name))
+}
+
+// groupFields returns the mapped connector fields of a stored group.
+func (m *attributeMapping) groupFields(oktaID, name string) (map[string]string, error) {
+	return evalMapping(m.groups.fields, "group", storedGroupAttributes(oktaID, name))
+}
+
+// decodeGroupRequest decodes the group in the body of r into req and returns
+// its attributes.
+func decodeGroupRequest(r *http.Request, req interface{}) (map[string]interface{}, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResource(body, req)
+}
+
+// decodeResource decodes the SCIM resource in body into req and returns its
+// attributes, which the storage mappings are evaluated against.
+func decodeResource(body []byte, req interface{}) (map[string]interface{}, error) {
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+	var resource map[string]interface{}
+	if err := json.Unmarshal(body, &resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// userResourceAttributes returns the attributes of a SCIM user request with
+// the core attributes it omits set to empty values, so that expressions can
+// refer to them without checking for their presence.
+func userResourceAttributes(resource map[string]interface{}) map[string]interface{} {
+	attributes := withEmptyAttributes(resource, "userName", "externalId", "displayName", "locale")
+	name, _ := attributes["name"].(map[string]interface{})
+	attributes["name"] = withEmptyAttributes(name, "givenName", "middleName", "familyName", "formatted")
+	if _, ok := attributes["emails"].([]interface{}); !ok {
+		attributes["emails"] = []interface{}{}
+	}
+	return attributes
+}
+
+// groupResourceAttributes is userResourceAttributes for SCIM groups.
+func groupResourceAttributes(resource map[string]interface{}) map[string]interface{} {
+	return withEmptyAttributes(resource, "displayName", "externalId")
+}
+
+// withEmptyAttributes returns a copy of attributes with the missing or null
+// names set to the empty string.
+func withEmptyAttributes(attributes map[string]interface{}, names ...string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(attributes)+len(names))
+	for k, v := range attributes {
+		copied[k] = v
+	}
+	for _, name := range names {
+		if copied[name] == nil {
+			copied[name] = ""
+		}
+	}
+	return copied
+}
+
+// storedUserAttributes returns the attributes of a stored user expressions
+// over user refer to.
+func storedUserAttributes(user *User) map[string]interface{} {
+	extensions := make(map[string]interface{}, len(user.Extensions))
+	for urn, value := range user.Extensions {
+		var attributes interface{}
+		if json.Unmarshal(value, &attributes) == nil {
+			extensions[urn] = attributes
+		}
+	}
+	return map[string]interface{}{
+		"id":         user.OktaID,
+		"email":      user.Email,
+		"name":       user.Name,
+		"active":     user.Active,
+		"extensions": extensions,
+	}
+}
+
+// storedGroupAttributes returns the attributes of a stored group expressions
+// over group refer to.
+func storedGroupAttributes(oktaID, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   oktaID,
+		"name": name,
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// splitName splits a stored name like convertToSCIMUser did before the
+// mappings were configurable, which the defaults must keep doing.
+func splitName(name string) (givenName, familyName string) {
+	names := strings.Fields(name)
+	if len(names) == 0 {
+		return "", ""
+	}
+	return names[0], strings.Join(names[1:], " ")
+}
+
+func TestDefaultUserMapping(t *testing.T) {
+	mapping, err := newAttributeMapping(attributeMappingConfig{})
+	if err != nil {
+		t.Fatalf("newAttributeMapping: %v", err)
+	}
+
+	for _, name := range []string{
+		"Jane Doe",
+		"Jane",
+		"Jane Mary Doe",
+		"  Jane   Mary  Doe ",
+		"Jean-Luc de La Fontaine",
+		"",
+	} {
+		t.Run(name, func(t *testing.T) {
+			attributes, err := mapping.userSCIM(&User{OktaID: "00u1", Name: name, Email: "jane@example.com"})
+			if err != nil {
+				t.Fatalf("userSCIM: %v", err)
+			}
+			givenName, familyName := splitName(name)
+			want := map[string]string{
+				"userName":        "jane@example.com",
+				"name.givenName":  givenName,
+				"name.middleName": "",
+				"name.familyName": familyName,
+				"emails.value":    "jane@example.com",
+			}
+			for attribute, value := range want {
+				if attributes[attribute] != value {
+					t.Errorf("%s = %q, want %q", attribute, attributes[attribute], value)
+				}
+			}
+		})
+	}
+
+	columns, err := mapping.userColumns(userResourceAttributes(map[string]interface{}{
+		"userName": "jane@example.com",
+		"name":     map[string]interface{}{"givenName": "Jane", "familyName": "Doe"},
+	}))
+	if err != nil {
+		t.Fatalf("userColumns: %v", err)
+	}
+	if columns != (userColumns{Email: "jane@example.com", Name: "Jane Doe"}) {
+		t.Errorf("userColumns = %+v, want the userName and the given and family names", columns)
+	}
+}
+
+func TestMappingFunctions(t *testing.T) {
+	lookups := map[string]map[string]string{
+		"departments": {"eng": "Engineering"},
+	}
+	user := &User{OktaID: "00u1", Name: "Jane Doe", Email: "jane@example.com"}
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{"concat", `concat([user.name, " <", user.email, ">"])`, "Jane Doe <jane@example.com>", ""},
+		{"concat of an empty list", `concat([])`, "", ""},
+		{"concat of a number", `concat([user.name, 1])`, "", "concat"},
+		{"default of a value", `default(user.name, "unknown")`, "Jane Doe", ""},
+		{"default of an empty string", `default("", "unknown")`, "unknown", ""},
+		{"default of null", `default(null, "unknown")`, "unknown", ""},
+		{"default of a missing extension", `default(user.extensions[?"urn:example"].orValue(null), "none")`, "none", ""},
+		{"lookup", `lookup("departments", "eng")`, "Engineering", ""},
+		{"lookup of a missing key", `lookup("departments", "ops")`, "", ""},
+		{"lookup of a missing key with a fallback", `lookup("departments", "ops", "Other")`, "Other", ""},
+		{"lookup of a found key with a fallback", `lookup("departments", "eng", "Other")`, "Engineering", ""},
+		{"lookup in a missing table", `lookup("teams", "eng")`, "", `lookup table "teams" is not configured`},
+		{"lookup in a missing table with a fallback", `lookup("teams", "eng", "Other")`, "", `lookup table "teams" is not configured`},
+		{"null", `null`, "", ""},
+		{"dynamic value that is not a string", `user.active`, "", "not a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := newAttributeMapping(attributeMappingConfig{
+				Lookups: lookups,
+				Users:   resourceMappingConfig{Fields: map[string]string{"value": tt.expr}},
+			})
+			if err != nil {
+				t.Fatalf("newAttributeMapping: %v", err)
+			}
+			fields, err := mapping.userFields(user)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("userFields = %v, %v, want an error containing %q", fields, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("userFields: %v", err)
+			}
+			if fields["value"] != tt.want {
+				t.Errorf("value = %q, want %q", fields["value"], tt.want)
+			}
+		})
+	}
+}
+
+func TestMappingNullAttributes(t *testing.T) {
+	mapping, err := newAttributeMapping(attributeMappingConfig{
+		Users: resourceMappingConfig{Storage: map[string]string{
+			"name": `default(resource.displayName, resource.name.givenName)`,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("newAttributeMapping: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		resource map[string]interface{}
+		want     userColumns
+	}{
+		{"missing attributes", map[string]interface{}{}, userColumns{}},
+		{"null attributes", map[string]interface{}{"userName": nil, "displayName": nil, "name": nil}, userColumns{}},
+		{"null display name", map[string]interface{}{"userName": "jane@example.com", "displayName": nil, "name": map[string]interface{}{"givenName": "Jane"}}, userColumns{Email: "jane@example.com", Name: "Jane"}},
+		{"empty display name", map[string]interface{}{"displayName": "", "name": map[string]interface{}{"givenName": "Jane", "familyName": nil}}, userColumns{Name: "Jane"}},
+		{"display name", map[string]interface{}{"displayName": "Jane D.", "name": map[string]interface{}{"givenName": "Jane"}}, userColumns{Name: "Jane D."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := mapping.userColumns(userResourceAttributes(tt.resource))
+			if err != nil {
+				t.Fatalf("userColumns: %v", err)
+			}
+			if columns != tt.want {
+				t.Errorf("userColumns = %+v, want %+v", columns, tt.want)
+			}
+		})
+	}
+}
+
+func TestMappingValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  attributeMappingConfig
+		wantErr string
+	}{
+		{"number", attributeMappingConfig{Users: resourceMappingConfig{SCIM: map[string]string{"userName": `1 + 1`}}}, "attributeMapping.users.scim.userName: expression evaluates to int, not a string"},
+		{"bool", attributeMappingConfig{Groups: resourceMappingConfig{Storage: map[string]string{"name": `resource.displayName == ""`}}}, "attributeMapping.groups.storage.name: expression evaluates to bool, not a string"},
+		{"list", attributeMappingConfig{Users: resourceMappingConfig{Fields: map[string]string{"names": `user.name.split(" ")`}}}, "not a string"},
+		{"empty expression", attributeMappingConfig{Users: resourceMappingConfig{Fields: map[string]string{"title": ` `}}}, "expression must not be empty"},
+		{"syntax error", attributeMappingConfig{Users: resourceMappingConfig{Fields: map[string]string{"title": `user.name +`}}}, "attributeMapping.users.fields.title"},
+		{"unknown column", attributeMappingConfig{Users: resourceMappingConfig{Storage: map[string]string{"phone": `""`}}}, "phone is not a mappable column"},
+		{"unknown attribute", attributeMappingConfig{Groups: resourceMappingConfig{SCIM: map[string]string{"members": `""`}}}, "members is not a mappable SCIM attribute"},
+		{"invalid field name", attributeMappingConfig{Users: resourceMappingConfig{Fields: map[string]string{"1st": `""`}}}, "1st is not a mappable field name"},
+		{"unknown variable", attributeMappingConfig{Users: resourceMappingConfig{SCIM: map[string]string{"userName": `resource.userName`}}}, "attributeMapping.users.scim.userName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := (attributeMappingConfig{}).validate(); err != nil {
+		t.Errorf("validate of the defaults = %v, want no error", err)
+	}
+}
+
+func TestMappingCostLimit(t *testing.T) {
+	// Every character of the name against every other one
+	mapping, err := newAttributeMapping(attributeMappingConfig{
+		Users: resourceMappingConfig{Fields: map[string]string{
+			"pairs": `user.name.split("").map(a, user.name.split("").map(b, a + b).join("")).join("")`,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("newAttributeMapping: %v", err)
+	}
+
+	if _, err := mapping.userFields(&User{Name: "Jane"}); err != nil {
+		t.Errorf("userFields of a short name: %v, want the expression within the limit", err)
+	}
+	_, err = mapping.userFields(&User{Name: strings.Repeat("x", 200)})
+	if err == nil || !strings.Contains(err.Error(), "cost limit") {
+		t.Errorf("userFields of a long name = %v, want the cost limit exceeded", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"okta-scim/db"
+)
+
+// errGroupCycle is returned when adding a member group would make a group
+// contain itself, directly or through nested groups.
+var errGroupCycle = errors.New("group membership would create a cycle")
+
+// invalidMemberError reports a group member that cannot be resolved.
+type invalidMemberError struct {
+	detail string
+}
+
+func (e *invalidMemberError) Error() string {
+	return e.detail
+}
+
+// resolveMembers splits the requested group members into users and groups.
+// Members without a type are treated as groups when their value is the id of
+// an existing group, and as users otherwise.
+func resolveMembers(ctx context.Context, q *db.Queries, members []SCIMGroupMember) ([]db.Employee, []db.Oktagroup, error) {
+	var userIDs, groupIDs []string
+	for _, member := range members {
+		switch member.Type {
+		case memberTypeUser:
+			userIDs = append(userIDs, member.Value)
+		case memberTypeGroup:
+			groupIDs = append(groupIDs, member.Value)
+		case "":
+			userIDs = append(userIDs, member.Value)
+			groupIDs = append(groupIDs, member.Value)
+		default:
+			return nil, nil, &invalidMemberError{fmt.Sprintf("Unsupported member type %q", member.Type)}
+		}
+	}
+
+	usersByID := make(map[string]db.Employee)
+	if len(userIDs) > 0 {
+		users, err := q.GetUsersByOktaIDs(ctx, db.GetUsersByOktaIDsParams{TenantID: tenantIDFromContext(ctx), OktaIds: userIDs})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, user := range users {
+			usersByID[user.OktaID] = user
+		}
+	}
+
+	groupsByID := make(map[string]db.Oktagroup)
+	if len(groupIDs) > 0 {
+		groups, err := q.GetGroupsByOktaIDs(ctx, db.GetGroupsByOktaIDsParams{TenantID: tenantIDFromContext(ctx), OktaIds: groupIDs})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, group := range groups {
+			groupsByID[group.OktaID.String] = group
+		}
+	}
+
+	var users []db.Employee
+	var groups []db.Oktagroup
+	for _, member := range members {
+		if group, ok := groupsByID[member.Value]; ok && member.Type != memberTypeUser {
+			groups = append(groups, group)
+		} else if user, ok := usersByID[member.Value]; ok && member.Type != memberTypeGroup {
+			users = append(users, user)
+		} else if member.Type == memberTypeGroup {
+			return nil, nil, &invalidMemberError{fmt.Sprintf("Group %q does not exist", member.Value)}
+		} else {
+			return nil, nil, &invalidMemberError{fmt.Sprintf("User %q does not exist", member.Value)}
+		}
+	}
+
+	return users, groups, nil
+}
+
+// addMemberGroup makes member a member of the group with the given id,
+// rejecting memberships that would create a cycle.
+func addMemberGroup(ctx context.Context, q *db.Queries, groupID int32, member db.Oktagroup) error {
+	// The group must not already be nested below the new member (or be the member itself)
+	isDescendant, err := q.IsGroupDescendant(ctx, db.IsGroupDescendantParams{
+		TenantID:     tenantIDFromContext(ctx),
+		AncestorID:   member.ID,
+		DescendantID: groupID,
+	})
+	if err != nil {
+		return err
+	}
+	if isDescendant {
+		return fmt.Errorf("%w: group %q already contains this group", errGroupCycle, member.Name)
+	}
+
+	return q.AddGroupMemberGroup(ctx, db.AddGroupMemberGroupParams{
+		TenantID:      tenantIDFromContext(ctx),
+		GroupID:       groupID,
+		MemberGroupID: member.ID,
+	})
+}
+
+// removeUserMemberships removes a user from the groups it is a direct member
+// of, touching the groups so that incremental syncs see the change, and
+// returns the audit log entries of the removals.
+func removeUserMemberships(ctx context.Context, q *db.Queries, tenantID int32, user db.Employee) ([]auditEntry, error) {
+	memberships, err := q.GetUsersGroups(ctx, db.GetUsersGroupsParams{TenantID: tenantID, EmployeeIds: []int32{user.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var audits []auditEntry
+	for _, m := range memberships {
+		if !m.Direct {
+			continue
+		}
+		group, err := q.GetGroupByOktaID(ctx, db.GetGroupByOktaIDParams{TenantID: tenantID, OktaID: m.OktaID})
+		if err != nil {
+			return nil, err
+		}
+		if err := q.TouchGroup(ctx, db.TouchGroupParams{TenantID: tenantID, ID: group.ID}); err != nil {
+			return nil, err
+		}
+		audits = append(audits, auditEntry{
+			Operation:    auditMemberRemove,
+			ResourceType: "Group",
+			ResourceID:   m.OktaID.String,
+			Before:       auditMember(memberTypeUser, user.OktaID),
+		})
+	}
+	if err := q.DeleteUserMemberships(ctx, db.DeleteUserMembershipsParams{TenantID: tenantID, EmployeeID: user.ID}); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
+
+// writeMembershipError responds with a SCIM invalidValue error for rejected
+// members and an internal server error otherwise.
+func (h *handler) writeMembershipError(w http.ResponseWriter, err error, detail string) {
+	var invalidMember *invalidMemberError
+	if errors.Is(err, errGroupCycle) || errors.As(err, &invalidMember) {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	h.logger.Error(detail, "error", err)
+	http.Error(w, detail, http.StatusInternalServerError)
+}
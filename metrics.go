@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"okta-scim/db"
+)
+
+// metricsRegistry holds every metric exposed on /metrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_http_requests_total",
+		Help: "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scim_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	provisioningOperationsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_provisioning_operations_total",
+		Help: "Provisioning changes applied, by resource type and operation.",
+	}, []string{"resource_type", "operation"})
+
+	dbQueryDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scim_db_query_duration_seconds",
+		Help:    "Database query latency, by sqlc query name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query"})
+
+	oktaRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_okta_api_requests_total",
+		Help: "Okta API calls, by method and status code.",
+	}, []string{"method", "status"})
+
+	outboxEventsPublishedTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_outbox_events_published_total",
+		Help: "Change events published from the outbox, by sink.",
+	}, []string{"sink"})
+
+	outboxPublishFailuresTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_outbox_publish_failures_total",
+		Help: "Failures to publish change events from the outbox, by sink.",
+	}, []string{"sink"})
+
+	webhookDeliveriesTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_webhook_deliveries_total",
+		Help: "Webhook delivery attempts, by result: delivered, retried or failed.",
+	}, []string{"result"})
+
+	scimTargetOperationsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_target_operations_total",
+		Help: "Changes applied to SCIM targets, by target and result: applied or rejected.",
+	}, []string{"target", "result"})
+
+	ldapTargetOperationsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_ldap_target_operations_total",
+		Help: "Changes applied to LDAP targets, by target and result: applied or rejected.",
+	}, []string{"target", "result"})
+
+	dbTxRetriesTotal = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "scim_db_transaction_retries_total",
+		Help: "Transactions retried after a serialization failure or deadlock.",
+	})
+
+	httpRequestsInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "scim_http_requests_in_flight",
+		Help: "SCIM requests being served, when limits.maxInFlight caps them.",
+	})
+
+	maxInFlightRequests = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "scim_http_max_in_flight_requests",
+		Help: "Configured cap on concurrent SCIM requests, 0 when unlimited.",
+	})
+
+	rateLimitRate = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scim_rate_limit_requests_per_second",
+		Help: "Configured rate limit of each credential, by route class, 0 when unlimited.",
+	}, []string{"class"})
+
+	rateLimitBurst = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scim_rate_limit_burst",
+		Help: "Configured burst of each credential, by route class.",
+	}, []string{"class"})
+
+	rateLimitedRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "scim_rate_limited_requests_total",
+		Help: "Requests rejected with 429, by route class and reason (rate or concurrency).",
+	}, []string{"class", "reason"})
+
+	oktaRateLimitRemaining = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "scim_okta_api_rate_limit_remaining",
+		Help: "X-Rate-Limit-Remaining reported by the most recent Okta API response.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// metricsHandler serves the metrics in the Prometheus exposition format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// registerDBStats exposes the connection pool statistics of dbConn.
+func registerDBStats(dbConn *sql.DB, dbName string) {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(dbConn, dbName))
+}
+
+// instrumentRoute records the count and latency of requests served by handle.
+// route is the pattern the handler is registered under, so that resource ids
+// do not end up in label values.
+func instrumentRoute(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		startTime := time.Now()
+		rec := newResponseRecorder(w)
+		handle(rec, r, ps)
+
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(rec.Status())}
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(startTime).Seconds())
+	}
+}
+
+// instrumentedDB wraps a database connection or transaction, records the
+// latency of each query under the name sqlc gave it and traces it.
+type instrumentedDB struct {
+	db db.DBTX
+}
+
+// instrumentDB returns conn wrapped to record and trace queries, for use with db.New.
+func instrumentDB(conn db.DBTX) db.DBTX {
+	return instrumentedDB{db: conn}
+}
+
+func (i instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := i.db.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+func (i instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+func (i instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (i instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	ctx, span := startQuerySpan(ctx, query)
+	row := i.db.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func observeQuery(query string, startTime time.Time) {
+	dbQueryDuration.WithLabelValues(queryName(query)).Observe(time.Since(startTime).Seconds())
+}
+
+// queryName extracts the query name from the "-- name: GetUserByID :one"
+// comment sqlc places at the start of every generated query.
+func queryName(query string) string {
+	const prefix = "-- name: "
+	if !strings.HasPrefix(query, prefix) {
+		return "unknown"
+	}
+	fields := strings.Fields(query[len(prefix):])
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return fields[0]
+}
+
+// oktaTransport counts the calls made to the Okta API and tracks the
+// remaining rate limit reported in the responses.
+type oktaTransport struct {
+	next http.RoundTripper
+}
+
+func (t oktaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		oktaRequestsTotal.WithLabelValues(req.Method, "error").Inc()
+		return nil, err
+	}
+
+	oktaRequestsTotal.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Remaining")); err == nil {
+		oktaRateLimitRemaining.Set(float64(remaining))
+	}
+	return resp, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"okta-scim/db"
+)
+
+// migration is a schema migration embedded in the binary.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations returns the embedded migrations ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, rest, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+
+		content, err := fs.ReadFile(db.Migrations, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.Name = strings.TrimSuffix(rest, ".up.sql")
+			m.Up = string(content)
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.Down = string(content)
+		default:
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %03d must have both an up and a down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// schemaMigrationsVersion is the migration that creates the SchemaMigrations table.
+const schemaMigrationsVersion = 7
+
+// migrator applies the embedded migrations, recording the applied versions
+// in the SchemaMigrations table.
+type migrator struct {
+	dbConn     *sql.DB
+	migrations []migration
+}
+
+func newMigrator(dbConn *sql.DB) (*migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{dbConn: dbConn, migrations: migrations}, nil
+}
+
+// appliedVersions returns the versions recorded as applied. The table only
+// exists from migration 007 onwards, so databases migrated before it was
+// introduced must be baselined.
+func (m *migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	var exists, hasSchema bool
+	if err := m.dbConn.QueryRowContext(ctx,
+		"SELECT to_regclass('schemamigrations') IS NOT NULL, to_regclass('employee') IS NOT NULL",
+	).Scan(&exists, &hasSchema); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	if !exists {
+		if hasSchema {
+			return nil, fmt.Errorf("the database schema predates migration tracking, record the last migration applied with migrate baseline")
+		}
+		return applied, nil
+	}
+
+	rows, err := m.dbConn.QueryContext(ctx, "SELECT version FROM SchemaMigrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Up applies the pending migrations up to and including target, or all of
+// them when target is 0, and returns the migrations applied.
+func (m *migrator) Up(ctx context.Context, target int) ([]migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for _, migration := range m.migrations {
+		if applied[migration.Version] || (target > 0 && migration.Version > target) {
+			continue
+		}
+		if err := m.apply(ctx, migration.Version, migration.Up, true); err != nil {
+			return done, fmt.Errorf("migration %03d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the migrations reverted.
+func (m *migrator) Down(ctx context.Context, steps int) ([]migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if err := m.apply(ctx, migration.Version, migration.Down, false); err != nil {
+			return done, fmt.Errorf("reverting migration %03d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// apply runs a migration script in a transaction and records its version as
+// applied or reverted when the SchemaMigrations table exists.
+func (m *migrator) apply(ctx context.Context, version int, script string, up bool) error {
+	tx, err := m.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass('schemamigrations') IS NOT NULL").Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		record := "DELETE FROM SchemaMigrations WHERE version = $1"
+		if up {
+			record = "INSERT INTO SchemaMigrations (version) VALUES ($1) ON CONFLICT DO NOTHING"
+		}
+		if _, err := tx.ExecContext(ctx, record, version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Baseline records the migrations up to and including version as applied
+// without running them, for databases migrated before versions were tracked.
+func (m *migrator) Baseline(ctx context.Context, version int) error {
+	var tracking migration
+	for _, migration := range m.migrations {
+		if migration.Version == schemaMigrationsVersion {
+			tracking = migration
+		}
+	}
+
+	tx, err := m.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Create the SchemaMigrations table
+	if _, err := tx.ExecContext(ctx, tracking.Up); err != nil {
+		return err
+	}
+	// Migration 007 is idempotent, it is applied again later when version precedes it
+	if _, err := tx.ExecContext(ctx, "DELETE FROM SchemaMigrations WHERE version > $1", version); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO SchemaMigrations (version) SELECT generate_series(1, $1::int) ON CONFLICT DO NOTHING", version,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrationStatus reports whether a migration has been applied.
+type migrationStatus struct {
+	migration
+	Applied bool
+}
+
+// Status lists every migration and whether it has been applied.
+func (m *migrator) Status(ctx context.Context) ([]migrationStatus, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]migrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i] = migrationStatus{migration: migration, Applied: applied[migration.Version]}
+	}
+	return status, nil
+}
@@ -2,22 +2,96 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// errOktaDisabled is returned by Okta-dependent features when no Okta
+// credentials are configured.
+var errOktaDisabled = errors.New("Okta integration is disabled: okta.domain and okta.apiToken are not configured")
+
+// oktaFeatures are the features that call the Okta API, reported as enabled
+// only when Okta credentials are configured.
+var oktaFeatures = []string{"oktaReconciliation", "oktaImport", "oktaGroupPush"}
+
 type oktaClient struct {
-	client *okta.Client
+	client     *okta.Client
+	orgURL     string
+	httpClient *http.Client
 }
 
 func newOktaClient(oktaDomain, apiToken string) (*oktaClient, error) {
 	ctx := context.TODO()
 
-	ctx, client, err := okta.NewClient(ctx, okta.WithOrgUrl(oktaDomain), okta.WithToken(apiToken))
+	httpClient := &http.Client{Transport: otelhttp.NewTransport(oktaTransport{next: http.DefaultTransport})}
+	ctx, client, err := okta.NewClient(ctx, okta.WithOrgUrl(oktaDomain), okta.WithToken(apiToken),
+		okta.WithHttpClientPtr(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Okta client: %v", err)
 	}
 
-	return &oktaClient{client: client}, nil
+	return &oktaClient{client: client, orgURL: strings.TrimSuffix(oktaDomain, "/"), httpClient: httpClient}, nil
+}
+
+// Ping checks that the Okta org can be reached.
+func (c *oktaClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.orgURL+"/.well-known/okta-organization", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// lazyOktaClient creates the Okta client the first time it is needed, so
+// that the service starts in SCIM-only mode without Okta credentials and an
+// Okta misconfiguration only affects the features that use it.
+type lazyOktaClient struct {
+	domain   string
+	apiToken string
+	logger   *slog.Logger
+
+	once   sync.Once
+	client *oktaClient
+	err    error
+}
+
+func newLazyOktaClient(config oktaConfig, logger *slog.Logger) *lazyOktaClient {
+	return &lazyOktaClient{domain: config.Domain, apiToken: config.APIToken, logger: logger}
+}
+
+// Enabled reports whether Okta credentials are configured.
+func (l *lazyOktaClient) Enabled() bool {
+	return l.domain != "" && l.apiToken != ""
+}
+
+// Client returns the Okta client, or errOktaDisabled without credentials.
+// An error creating the client is returned by every call.
+func (l *lazyOktaClient) Client() (*oktaClient, error) {
+	if !l.Enabled() {
+		return nil, errOktaDisabled
+	}
+
+	l.once.Do(func() {
+		l.client, l.err = newOktaClient(l.domain, l.apiToken)
+		if l.err != nil {
+			l.logger.Error("Error creating Okta client", "error", l.err)
+		}
+	})
+	return l.client, l.err
 }
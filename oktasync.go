@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"okta-scim/db"
+)
+
+// oktaPageSize is the number of objects requested per Okta API page.
+const oktaPageSize = 200
+
+// oktaUser is a user as read from Okta, mapped to the attributes we store.
+type oktaUser struct {
+	ID     string
+	Login  string
+	Name   string
+	Active bool
+}
+
+// oktaGroup is a group as read from Okta with the ids of its members.
+type oktaGroup struct {
+	ID        string
+	Name      string
+	MemberIDs []string
+}
+
+// oktaSnapshot is the users, groups and memberships of the Okta org.
+type oktaSnapshot struct {
+	Users  []oktaUser
+	Groups []oktaGroup
+}
+
+// Snapshot reads every user and group of the Okta org, with group memberships.
+func (c *oktaClient) Snapshot(ctx context.Context) (*oktaSnapshot, error) {
+	users, resp, err := c.client.User.ListUsers(ctx, query.NewQueryParams(query.WithLimit(oktaPageSize)))
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.User
+		if resp, err = resp.Next(ctx, &page); err == nil {
+			users = append(users, page...)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Okta users: %w", err)
+	}
+
+	snapshot := &oktaSnapshot{}
+	for _, user := range users {
+		snapshot.Users = append(snapshot.Users, oktaUserFromAPI(user))
+	}
+
+	groups, resp, err := c.client.Group.ListGroups(ctx, query.NewQueryParams(query.WithLimit(oktaPageSize)))
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.Group
+		if resp, err = resp.Next(ctx, &page); err == nil {
+			groups = append(groups, page...)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Okta groups: %w", err)
+	}
+
+	for _, group := range groups {
+		members, resp, err := c.client.Group.ListGroupUsers(ctx, group.Id, query.NewQueryParams(query.WithLimit(oktaPageSize)))
+		for err == nil && resp.HasNextPage() {
+			var page []*okta.User
+			if resp, err = resp.Next(ctx, &page); err == nil {
+				members = append(members, page...)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list members of Okta group %s: %w", group.Id, err)
+		}
+
+		g := oktaGroup{ID: group.Id}
+		if group.Profile != nil {
+			g.Name = group.Profile.Name
+		}
+		for _, member := range members {
+			g.MemberIDs = append(g.MemberIDs, member.Id)
+		}
+		snapshot.Groups = append(snapshot.Groups, g)
+	}
+
+	return snapshot, nil
+}
+
+// oktaUserFromAPI maps an Okta user the way Okta provisions it over SCIM:
+// the login is the userName and the name joins the given and family names.
+func oktaUserFromAPI(user *okta.User) oktaUser {
+	profile := func(key string) string {
+		if user.Profile == nil {
+			return ""
+		}
+		value, _ := (*user.Profile)[key].(string)
+		return value
+	}
+
+	return oktaUser{
+		ID:     user.Id,
+		Login:  profile("login"),
+		Name:   profile("firstName") + " " + profile("lastName"),
+		Active: user.Status != "SUSPENDED" && user.Status != "DEPROVISIONED",
+	}
+}
+
+// syncOptions controls how the local store is brought in line with Okta.
+type syncOptions struct {
+	// DryRun reports the changes without making them.
+	DryRun bool
+	// Prune deactivates users missing from Okta and removes group memberships
+	// Okta does not have. Without it only creations and updates are made.
+	Prune bool
+}
+
+// syncChange describes a change made, or planned, to the local store.
+type syncChange struct {
+	Operation    string
+	ResourceType string
+	ResourceID   string
+	Detail       string
+}
+
+// syncFromOkta brings the users, groups and group memberships of the tenant
+// in ctx stored through q in line with snapshot, recording every change in
+// the audit log. Nested group memberships are left alone as Okta has no
+// nested groups.
+func syncFromOkta(ctx context.Context, q *db.Queries, snapshot *oktaSnapshot, opts syncOptions) ([]syncChange, error) {
+	s := &oktaSync{q: q, tenantID: tenantIDFromContext(ctx), opts: opts}
+	if err := s.syncUsers(ctx, snapshot.Users); err != nil {
+		return s.changes, err
+	}
+	if err := s.syncGroups(ctx, snapshot.Groups); err != nil {
+		return s.changes, err
+	}
+	return s.changes, nil
+}
+
+type oktaSync struct {
+	q        *db.Queries
+	tenantID int32
+	opts     syncOptions
+	changes  []syncChange
+	// users maps Okta ids to the stored users, including users only planned
+	// to be created in a dry run, which have no id.
+	users map[string]db.Employee
+}
+
+func (s *oktaSync) record(ctx context.Context, change syncChange, before, after map[string]interface{}) error {
+	s.changes = append(s.changes, change)
+	if s.opts.DryRun {
+		return nil
+	}
+	return recordAudit(ctx, s.q, auditEntry{
+		Operation:    change.Operation,
+		ResourceType: change.ResourceType,
+		ResourceID:   change.ResourceID,
+		Before:       before,
+		After:        after,
+	})
+}
+
+func (s *oktaSync) syncUsers(ctx context.Context, users []oktaUser) error {
+	stored, err := listAllUsers(ctx, s.q, s.tenantID)
+	if err != nil {
+		return err
+	}
+	s.users = make(map[string]db.Employee, len(stored))
+	for _, user := range stored {
+		s.users[user.OktaID] = user
+	}
+
+	inOkta := make(map[string]bool, len(users))
+	for _, user := range users {
+		inOkta[user.ID] = true
+		current, exists := s.users[user.ID]
+		desired := db.Employee{ID: current.ID, OktaID: user.ID, Name: user.Name, Email: user.Login, Active: user.Active}
+
+		switch {
+		case !exists:
+			if !s.opts.DryRun {
+				created, err := s.q.CreateUser(ctx, db.CreateUserParams{
+					TenantID:   s.tenantID,
+					Name:       user.Name,
+					Email:      user.Login,
+					OktaID:     user.ID,
+					Extensions: json.RawMessage("{}"),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to create user %s: %w", user.ID, err)
+				}
+				if !user.Active {
+					if created, err = s.q.DeactivateUser(ctx, db.DeactivateUserParams{TenantID: s.tenantID, OktaID: user.ID}); err != nil {
+						return fmt.Errorf("failed to deactivate user %s: %w", user.ID, err)
+					}
+				}
+				desired = created
+			}
+			if err := s.record(ctx, syncChange{auditCreate, "User", user.ID, user.Login}, nil, auditUser(desired)); err != nil {
+				return err
+			}
+		case current.Name != user.Name || current.Email != user.Login || current.Active != user.Active:
+			if !s.opts.DryRun {
+				updated, err := s.q.UpdateUser(ctx, db.UpdateUserParams{
+					TenantID:   s.tenantID,
+					OktaID:     user.ID,
+					Name:       user.Name,
+					Email:      user.Login,
+					Active:     user.Active,
+					Extensions: current.Extensions,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to update user %s: %w", user.ID, err)
+				}
+				desired = updated
+			}
+			if err := s.record(ctx, syncChange{auditUpdate, "User", user.ID, user.Login}, auditUser(current), auditUser(desired)); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		s.users[user.ID] = desired
+	}
+
+	if !s.opts.Prune {
+		return nil
+	}
+	for _, user := range stored {
+		if inOkta[user.OktaID] || !user.Active {
+			continue
+		}
+		deactivated := user
+		deactivated.Active = false
+		if !s.opts.DryRun {
+			var err error
+			if deactivated, err = s.q.DeactivateUser(ctx, db.DeactivateUserParams{TenantID: s.tenantID, OktaID: user.OktaID}); err != nil {
+				return fmt.Errorf("failed to deactivate user %s: %w", user.OktaID, err)
+			}
+		}
+		if err := s.record(ctx, syncChange{auditDeactivate, "User", user.OktaID, "not found in Okta"}, auditUser(user), auditUser(deactivated)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *oktaSync) syncGroups(ctx context.Context, groups []oktaGroup) error {
+	stored, err := listAllGroups(ctx, s.q, s.tenantID)
+	if err != nil {
+		return err
+	}
+	byOktaID := make(map[string]db.Oktagroup, len(stored))
+	for _, group := range stored {
+		byOktaID[group.OktaID.String] = group
+	}
+
+	for _, group := range groups {
+		current, exists := byOktaID[group.ID]
+		switch {
+		case !exists:
+			if !s.opts.DryRun {
+				created, err := s.q.CreateGroup(ctx, db.CreateGroupParams{
+					TenantID: s.tenantID,
+					Name:     group.Name,
+					OktaID:   sql.NullString{String: group.ID, Valid: true},
+				})
+				if isUniqueViolation(err) {
+					return fmt.Errorf("cannot create group %s: the name %q is used by another group", group.ID, group.Name)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to create group %s: %w", group.ID, err)
+				}
+				current = created
+			}
+			if err := s.record(ctx, syncChange{auditCreate, "Group", group.ID, group.Name}, nil, auditGroup(group.Name)); err != nil {
+				return err
+			}
+		case current.Name != group.Name:
+			if !s.opts.DryRun {
+				if _, err := s.q.UpdateGroupName(ctx, db.UpdateGroupNameParams{TenantID: s.tenantID, OktaID: current.OktaID, Name: group.Name}); err != nil {
+					return fmt.Errorf("failed to rename group %s: %w", group.ID, err)
+				}
+			}
+			if err := s.record(ctx, syncChange{auditUpdate, "Group", group.ID, group.Name}, auditGroup(current.Name), auditGroup(group.Name)); err != nil {
+				return err
+			}
+		}
+
+		if err := s.syncMembers(ctx, current, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *oktaSync) syncMembers(ctx context.Context, current db.Oktagroup, group oktaGroup) error {
+	members := make(map[string]int32)
+	if current.ID != 0 {
+		rows, err := s.q.GetGroupMembers(ctx, db.GetGroupMembersParams{TenantID: s.tenantID, OktaGroupID: current.ID})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			members[row.OktaID] = row.ID
+		}
+	}
+
+	changed := false
+	inOkta := make(map[string]bool, len(group.MemberIDs))
+	for _, memberID := range group.MemberIDs {
+		inOkta[memberID] = true
+		if _, ok := members[memberID]; ok {
+			continue
+		}
+		user, ok := s.users[memberID]
+		if !ok {
+			// Deprovisioned users are not listed by Okta
+			continue
+		}
+		if !s.opts.DryRun {
+			if err := s.q.AddGroupMember(ctx, db.AddGroupMemberParams{TenantID: s.tenantID, EmployeeID: user.ID, OktaGroupID: current.ID}); err != nil {
+				return fmt.Errorf("failed to add %s to group %s: %w", memberID, group.ID, err)
+			}
+		}
+		if err := s.record(ctx, syncChange{auditMemberAdd, "Group", group.ID, memberID}, nil, auditMember(memberTypeUser, memberID)); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if s.opts.Prune {
+		for memberID, employeeID := range members {
+			if inOkta[memberID] {
+				continue
+			}
+			if !s.opts.DryRun {
+				if err := s.q.RemoveGroupMember(ctx, db.RemoveGroupMemberParams{TenantID: s.tenantID, EmployeeID: employeeID, OktaGroupID: current.ID}); err != nil {
+					return fmt.Errorf("failed to remove %s from group %s: %w", memberID, group.ID, err)
+				}
+			}
+			if err := s.record(ctx, syncChange{auditMemberRemove, "Group", group.ID, memberID}, auditMember(memberTypeUser, memberID), nil); err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+
+	// Membership changes modify the group
+	if changed && !s.opts.DryRun {
+		if err := s.q.TouchGroup(ctx, db.TouchGroupParams{TenantID: s.tenantID, ID: current.ID}); err != nil {
+			return fmt.Errorf("failed to update group %s: %w", group.ID, err)
+		}
+	}
+	return nil
+}
+
+// listAllUsers returns every user of the tenant, active or not.
+func listAllUsers(ctx context.Context, q *db.Queries, tenantID int32) ([]db.Employee, error) {
+	var users []db.Employee
+	for offset := int32(0); ; offset += maxPageSize {
+		page, err := q.ListAllUsers(ctx, db.ListAllUsersParams{TenantID: tenantID, Limit: maxPageSize, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+		if len(page) < maxPageSize {
+			return users, nil
+		}
+	}
+}
+
+// listAllGroups returns every group of the tenant.
+func listAllGroups(ctx context.Context, q *db.Queries, tenantID int32) ([]db.Oktagroup, error) {
+	var groups []db.Oktagroup
+	for offset := int32(0); ; offset += maxPageSize {
+		page, err := q.ListAllGroups(ctx, db.ListAllGroupsParams{TenantID: tenantID, Limit: maxPageSize, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, page...)
+		if len(page) < maxPageSize {
+			return groups, nil
+		}
+	}
+}
+
+// String formats the change for command output.
+func (c syncChange) String() string {
+	return strings.Join([]string{c.Operation, c.ResourceType, c.ResourceID, c.Detail}, "\t")
+}
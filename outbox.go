@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"okta-scim/db"
+)
+
+// Change event types
+const (
+	eventUserCreated        = "user.created"
+	eventUserUpdated        = "user.updated"
+	eventUserDeactivated    = "user.deactivated"
+	eventUserDeleted        = "user.deleted"
+	eventGroupCreated       = "group.created"
+	eventGroupRenamed       = "group.renamed"
+	eventGroupDeleted       = "group.deleted"
+	eventGroupMemberAdded   = "group.member_added"
+	eventGroupMemberRemoved = "group.member_removed"
+)
+
+var changeEventTypes = []string{
+	eventUserCreated, eventUserUpdated, eventUserDeactivated, eventUserDeleted,
+	eventGroupCreated, eventGroupRenamed, eventGroupDeleted, eventGroupMemberAdded, eventGroupMemberRemoved,
+}
+
+// Outbox sink types
+const (
+	sinkWebhook = "webhook"
+	sinkFile    = "file"
+	sinkStdout  = "stdout"
+)
+
+const (
+	// outboxBatchSize is the number of events published to a sink at once.
+	outboxBatchSize = 100
+
+	// outboxPruneInterval is how often delivered events are deleted.
+	outboxPruneInterval = time.Hour
+
+	// maxOutboxSinkBackoff bounds the delay before a sink that failed to
+	// publish is retried.
+	maxOutboxSinkBackoff = time.Minute
+)
+
+// ChangeEvent is the JSON representation of a change published to the outbox
+// sinks. Data holds the attributes of the resource after the change, or
+// before it when the resource was deleted, and of the member for membership
+// events.
+type ChangeEvent struct {
+	ID           string                 `json:"id"`
+	Type         string                 `json:"type"`
+	OccurredAt   time.Time              `json:"occurredAt"`
+	Tenant       string                 `json:"tenant"`
+	Actor        string                 `json:"actor"`
+	RequestID    string                 `json:"requestId"`
+	ResourceType string                 `json:"resourceType"`
+	ResourceID   string                 `json:"resourceId"`
+	Data         map[string]interface{} `json:"data"`
+	Changes      map[string]interface{} `json:"changes,omitempty"`
+}
+
+// changeEventType returns the type of the event emitted for an audited
+// change, reporting false for changes that emit no event.
+func changeEventType(entry auditEntry) (string, bool) {
+	types := map[string]map[string]string{
+		"User": {
+			auditCreate:     eventUserCreated,
+			auditUpdate:     eventUserUpdated,
+			auditDeactivate: eventUserDeactivated,
+			auditDelete:     eventUserDeleted,
+			// Purged users are deleted downstream, anonymized ones included
+			auditPurge:     eventUserDeleted,
+			auditAnonymize: eventUserDeleted,
+		},
+		"Group": {
+			auditCreate:       eventGroupCreated,
+			auditUpdate:       eventGroupRenamed,
+			auditDelete:       eventGroupDeleted,
+			auditMemberAdd:    eventGroupMemberAdded,
+			auditMemberRemove: eventGroupMemberRemoved,
+		},
+	}
+	eventType, ok := types[entry.ResourceType][entry.Operation]
+	return eventType, ok
+}
+
+// writeOutboxEvent writes the event of an audited change to the outbox
+// through q, attributing it to the tenant, credential and request in ctx.
+// Called with the queries of the transaction making the change, the event is
+// published if and only if the change is committed.
+func writeOutboxEvent(ctx context.Context, q *db.Queries, entry auditEntry) error {
+	eventType, ok := changeEventType(entry)
+	if !ok {
+		return nil
+	}
+
+	event := ChangeEvent{
+		ID:           uuid.New().String(),
+		Type:         eventType,
+		OccurredAt:   time.Now().UTC(),
+		Tenant:       tenantFromContext(ctx).Name,
+		Actor:        credentialFromContext(ctx),
+		RequestID:    requestIDFromContext(ctx),
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		Data:         entry.After,
+	}
+	if event.Data == nil {
+		event.Data = entry.Before
+	}
+	if entry.Before != nil && entry.After != nil {
+		event.Changes = auditDiff(entry.Before, entry.After)
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return q.WriteOutboxEvent(ctx, db.WriteOutboxEventParams{
+		TenantID:  tenantIDFromContext(ctx),
+		EventID:   uuid.MustParse(event.ID),
+		EventType: eventType,
+		Payload:   payload,
+	})
+}
+
+// outboxConfig configures the publication of the outbox.
+type outboxConfig struct {
+	// PollInterval is how often the outbox is checked for new events.
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// Retention is how long events published to every sink are kept, and can
+	// be replayed by rewinding a sink.
+	Retention time.Duration `yaml:"retention"`
+	// Sinks are the sinks the events are published to, as
+	// [<name>=]<type>[:<path>] where type is webhook, file, stdout, scim or
+	// ldap, and path is the file NDJSON is appended to or the name of the SCIM
+	// or LDAP target. The name identifies the position of the sink in the
+	// outbox and defaults to the type, or to <type>:<target> for targets.
+	Sinks []string `yaml:"sinks"`
+}
+
+func (c outboxConfig) validate(scimTargets []scimTargetConfig, ldapTargets []ldapTargetConfig) error {
+	var errs []error
+	if c.PollInterval <= 0 || c.Retention <= 0 {
+		errs = append(errs, errors.New("outbox.pollInterval and outbox.retention must be positive"))
+	}
+	_, err := c.sinks(scimTargets, ldapTargets, nil, slog.Default())
+	errs = append(errs, err)
+	return errors.Join(errs...)
+}
+
+// publishesWebhooks reports whether a webhook sink is configured, without
+// which no event is queued for delivery to the webhook endpoints.
+func (c outboxConfig) publishesWebhooks() bool {
+	for _, spec := range c.Sinks {
+		if _, typeAndPath, named := strings.Cut(spec, "="); named {
+			spec = typeAndPath
+		}
+		if spec == sinkWebhook {
+			return true
+		}
+	}
+	return false
+}
+
+// outboxSink publishes events from the outbox. Publish is called in the
+// transaction that advances the position of the sink with q bound to it, and
+// is called again with the same events when it fails or the transaction does
+// not commit, so sinks receive every event at least once.
+type outboxSink interface {
+	Publish(ctx context.Context, q *db.Queries, events []db.Outbox) error
+}
+
+// namedSink is a sink with the name of its position in the outbox.
+type namedSink struct {
+	name string
+	sink outboxSink
+}
+
+// sinks parses the configured sinks, provisioning SCIM and LDAP sinks to the
+// targets with their name with the attributes of mapping.
+func (c outboxConfig) sinks(scimTargets []scimTargetConfig, ldapTargets []ldapTargetConfig, mapping *attributeMapping, logger *slog.Logger) ([]namedSink, error) {
+	var sinks []namedSink
+	var errs []error
+	names := make(map[string]bool)
+	for _, spec := range c.Sinks {
+		typeAndPath := spec
+		name, rest, named := strings.Cut(spec, "=")
+		if named {
+			typeAndPath = rest
+		}
+		sinkType, path, _ := strings.Cut(typeAndPath, ":")
+		if !named {
+			name = sinkType
+			if sinkType == sinkSCIM || sinkType == sinkLDAP {
+				name = typeAndPath
+			}
+		}
+
+		var sink outboxSink
+		switch {
+		case sinkType == sinkWebhook && path == "":
+			sink = webhookSink{}
+		case sinkType == sinkStdout && path == "":
+			sink = ndjsonSink{open: func() (io.WriteCloser, error) { return nopCloser{os.Stdout}, nil }}
+		case sinkType == sinkFile && path != "":
+			sink = newFileSink(path)
+		case sinkType == sinkSCIM && path != "":
+			target, err := findSCIMTarget(scimTargets, path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("outbox sink %q: %w", spec, err))
+				continue
+			}
+			sink = newSCIMTarget(target, mapping, logger)
+		case sinkType == sinkLDAP && path != "":
+			config, err := findLDAPTarget(ldapTargets, path)
+			if err == nil {
+				sink, err = newLDAPTarget(config, mapping, logger)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("outbox sink %q: %w", spec, err))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("invalid outbox sink %q, expected [<name>=]webhook, [<name>=]stdout, [<name>=]file:<path>, [<name>=]scim:<target> or [<name>=]ldap:<target>", spec))
+			continue
+		}
+		if name == "" || names[name] {
+			errs = append(errs, fmt.Errorf("outbox sink %q must have a unique name", spec))
+			continue
+		}
+		names[name] = true
+		sinks = append(sinks, namedSink{name: name, sink: sink})
+	}
+	return sinks, errors.Join(errs...)
+}
+
+// ndjsonSink writes every event as a line of JSON to the writer returned by
+// open, which is closed after each batch.
+type ndjsonSink struct {
+	open func() (io.WriteCloser, error)
+}
+
+func newFileSink(path string) ndjsonSink {
+	return ndjsonSink{open: func() (io.WriteCloser, error) {
+		return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	}}
+}
+
+func (s ndjsonSink) Publish(_ context.Context, _ *db.Queries, events []db.Outbox) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		if err := json.Compact(&buf, event.Payload); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	}
+
+	w, err := s.open()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		w.Close()
+		return err
+	}
+	if f, ok := w.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// outboxDispatcher publishes the events of the outbox in order to each sink,
+// recording the position of every sink in the outbox after each batch.
+// Replicas publish to a sink one at a time.
+type outboxDispatcher struct {
+	config outboxConfig
+	sinks  []namedSink
+	db     *db.Queries
+	dbConn *sql.DB
+	logger *slog.Logger
+
+	// retryAt is when each sink that failed to publish is retried, and
+	// failures the number of its consecutive failures.
+	retryAt  map[string]time.Time
+	failures map[string]int
+}
+
+func newOutboxDispatcher(config outboxConfig, scimTargets []scimTargetConfig, ldapTargets []ldapTargetConfig, mapping *attributeMapping, queries *db.Queries, dbConn *sql.DB, logger *slog.Logger) (*outboxDispatcher, error) {
+	sinks, err := config.sinks(scimTargets, ldapTargets, mapping, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &outboxDispatcher{
+		config:   config,
+		sinks:    sinks,
+		db:       queries,
+		dbConn:   dbConn,
+		logger:   logger,
+		retryAt:  make(map[string]time.Time),
+		failures: make(map[string]int),
+	}, nil
+}
+
+// Run publishes new events every PollInterval and deletes events delivered to
+// every sink past their retention every outboxPruneInterval until ctx is
+// canceled.
+func (d *outboxDispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.config.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(outboxPruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			d.PublishOnce(ctx)
+		case <-prune.C:
+			if deleted, err := d.db.DeleteDeliveredOutboxEvents(ctx, time.Now().Add(-d.config.Retention)); err != nil {
+				d.logger.Error("Error deleting delivered outbox events", "error", err)
+			} else if deleted > 0 {
+				d.logger.Info("Deleted delivered outbox events", "count", deleted)
+			}
+		}
+	}
+}
+
+// PublishOnce publishes the pending events to every sink that is not backing
+// off after a failure, and marks the events every sink published as
+// delivered.
+func (d *outboxDispatcher) PublishOnce(ctx context.Context) {
+	names := make([]string, len(d.sinks))
+	for i, sink := range d.sinks {
+		names[i] = sink.name
+		if time.Now().Before(d.retryAt[sink.name]) {
+			continue
+		}
+
+		if err := d.drain(ctx, sink); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			d.failures[sink.name]++
+			delay := min(d.config.PollInterval<<min(d.failures[sink.name], 16), maxOutboxSinkBackoff)
+			d.retryAt[sink.name] = time.Now().Add(delay)
+			outboxPublishFailuresTotal.WithLabelValues(sink.name).Inc()
+			d.logger.Error("Error publishing outbox events", "sink", sink.name, "retry_in", delay, "error", err)
+			continue
+		}
+		delete(d.failures, sink.name)
+		delete(d.retryAt, sink.name)
+	}
+
+	if _, err := d.db.MarkOutboxDelivered(ctx, names); err != nil && ctx.Err() == nil {
+		d.logger.Error("Error marking outbox events delivered", "error", err)
+	}
+}
+
+// drain publishes the pending events to a sink in batches of outboxBatchSize.
+func (d *outboxDispatcher) drain(ctx context.Context, sink namedSink) error {
+	if err := d.db.EnsureOutboxConsumer(ctx, sink.name); err != nil {
+		return err
+	}
+	for {
+		published, err := d.publishBatch(ctx, sink)
+		if err != nil || published < outboxBatchSize {
+			return err
+		}
+	}
+}
+
+// publishBatch publishes the next batch of events to a sink and advances its
+// position in the same transaction, returning the number of events published.
+// Nothing is published while another replica holds the sink.
+func (d *outboxDispatcher) publishBatch(ctx context.Context, sink namedSink) (int, error) {
+	tx, err := d.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	q := db.New(instrumentDB(tx))
+
+	consumer, err := q.LockOutboxConsumer(ctx, sink.name)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := q.ListOutboxEvents(ctx, db.ListOutboxEventsParams{
+		AfterTxid: consumer.LastTxid,
+		AfterID:   consumer.LastID,
+		BatchSize: outboxBatchSize,
+	})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	if err := sink.sink.Publish(ctx, q, events); err != nil {
+		return 0, err
+	}
+	last := events[len(events)-1]
+	if _, err := q.SetOutboxConsumerPosition(ctx, db.SetOutboxConsumerPositionParams{
+		Name:     sink.name,
+		LastTxid: last.Txid,
+		LastID:   last.ID,
+	}); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	outboxEventsPublishedTotal.WithLabelValues(sink.name).Add(float64(len(events)))
+	return len(events), nil
+}
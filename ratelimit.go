@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/time/rate"
+)
+
+// Route classes, each rate limited separately
+const (
+	routeClassReads  = "reads"
+	routeClassWrites = "writes"
+	// routeClassBulk limits the endpoints requiring scopeBulk, of which
+	// there are none yet.
+	routeClassBulk = "bulk"
+)
+
+var routeClasses = []string{routeClassReads, routeClassWrites, routeClassBulk}
+
+// rateLimit is a token bucket refilled with Rate requests per second and
+// holding up to Burst requests. A zero rate disables the limit.
+type rateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l rateLimit) validate(class string) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("limits.%s.rate and limits.%s.burst must not be negative", class, class)
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		return fmt.Errorf("limits.%s.burst must be positive when limits.%s.rate is set", class, class)
+	}
+	return nil
+}
+
+// routeClass returns the class of a request to an endpoint requiring scope.
+func routeClass(r *http.Request, scope string) string {
+	switch {
+	case scope == scopeBulk:
+		return routeClassBulk
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return routeClassReads
+	default:
+		return routeClassWrites
+	}
+}
+
+// requestLimiter caps the requests served concurrently by the whole service
+// and the rate of requests of each credential by route class.
+type requestLimiter struct {
+	// inFlight holds a token for every request being served, nil when the
+	// number of requests is not capped.
+	inFlight chan struct{}
+	limits   map[string]rateLimit
+
+	mu sync.Mutex
+	// buckets holds the token bucket of each tenant, credential and route
+	// class. It grows with the number of credentials, which is small.
+	buckets map[bucketKey]*rate.Limiter
+}
+
+type bucketKey struct {
+	tenantID   int32
+	credential string
+	class      string
+}
+
+// newRequestLimiter creates a requestLimiter from the configured limits and
+// exposes them in the metrics.
+func newRequestLimiter(config limitsConfig) *requestLimiter {
+	l := &requestLimiter{
+		limits:  make(map[string]rateLimit),
+		buckets: make(map[bucketKey]*rate.Limiter),
+	}
+	if config.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+
+	maxInFlightRequests.Set(float64(config.MaxInFlight))
+	for _, class := range routeClasses {
+		limit := config.rateLimit(class)
+		l.limits[class] = limit
+		rateLimitRate.WithLabelValues(class).Set(limit.Rate)
+		rateLimitBurst.WithLabelValues(class).Set(float64(limit.Burst))
+	}
+	return l
+}
+
+// acquire takes an in-flight slot, reporting false when every slot is taken.
+// The returned function releases the slot.
+func (l *requestLimiter) acquire() (func(), bool) {
+	if l.inFlight == nil {
+		return func() {}, true
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+		httpRequestsInFlight.Inc()
+		return func() {
+			<-l.inFlight
+			httpRequestsInFlight.Dec()
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// allow takes a token from the bucket of key, returning how long to wait
+// before retrying when the bucket is empty.
+func (l *requestLimiter) allow(key bucketKey) (time.Duration, bool) {
+	limit := l.limits[key.class]
+	if limit.Rate == 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	now := time.Now()
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// limitConcurrency rejects requests while the configured number of requests
+// is already being served, before they reach the database.
+func (h *handler) limitConcurrency(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		release, ok := h.limiter.acquire()
+		if !ok {
+			rateLimitedRequestsTotal.WithLabelValues("all", "concurrency").Inc()
+			writeTooManyRequests(w, time.Second, "Too many concurrent requests")
+			return
+		}
+		defer release()
+		handle(w, r, ps)
+	}
+}
+
+// limitRate rejects requests of credentials that exceeded the rate limit of
+// the route class of the endpoint, which requires scope.
+func (h *handler) limitRate(scope string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		class := routeClass(r, scope)
+		key := bucketKey{
+			tenantID:   tenantIDFromContext(r.Context()),
+			credential: credentialFromContext(r.Context()),
+			class:      class,
+		}
+		if retryAfter, ok := h.limiter.allow(key); !ok {
+			rateLimitedRequestsTotal.WithLabelValues(class, "rate").Inc()
+			writeTooManyRequests(w, retryAfter, fmt.Sprintf("Rate limit exceeded for %s", class))
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+// writeTooManyRequests responds with 429 Too Many Requests, asking the client
+// to retry after the given delay rounded up to whole seconds.
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, detail string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	writeSCIMError(w, http.StatusTooManyRequests, "", detail)
+}
+
+// validateLimits checks the concurrency and rate limits.
+func (c limitsConfig) validateLimits() error {
+	var errs []error
+	if c.MaxInFlight < 0 {
+		errs = append(errs, errors.New("limits.maxInFlight must not be negative"))
+	}
+	for _, class := range routeClasses {
+		errs = append(errs, c.rateLimit(class).validate(class))
+	}
+	return errors.Join(errs...)
+}
+
+// rateLimit returns the rate limit of a route class.
+func (c limitsConfig) rateLimit(class string) rateLimit {
+	switch class {
+	case routeClassReads:
+		return c.Reads
+	case routeClassWrites:
+		return c.Writes
+	default:
+		return c.Bulk
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultMaxRequestBodyBytes bounds request bodies unless configured otherwise.
+const defaultMaxRequestBodyBytes = 1 << 20
+
+// limitRequestBody rejects requests whose declared length exceeds the maximum
+// body size and stops reading bodies of unknown length once they reach it.
+func (h *handler) limitRequestBody(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if r.ContentLength > h.maxBody {
+			writeRequestTooLarge(w, h.maxBody)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+		handle(w, r, ps)
+	}
+}
+
+// writeDecodeError responds to a request body that could not be decoded,
+// with a SCIM tooLarge error when the body exceeded the maximum size and a
+// bad request carrying detail otherwise.
+func writeDecodeError(w http.ResponseWriter, err error, detail string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeRequestTooLarge(w, tooLarge.Limit)
+		return
+	}
+	http.Error(w, detail, http.StatusBadRequest)
+}
+
+func writeRequestTooLarge(w http.ResponseWriter, limit int64) {
+	writeSCIMError(w, http.StatusRequestEntityTooLarge, "tooLarge",
+		fmt.Sprintf("Request body exceeds the maximum size of %d bytes", limit))
+}
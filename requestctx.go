@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	credentialKey
+	tenantKey
+	tenantPathKey
+	scopesKey
+)
+
+// maxRequestIDLength bounds client supplied request ids stored in the audit log.
+const maxRequestIDLength = 128
+
+// requestIDFromContext returns the id of the request being served.
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// credentialFromContext returns the name of the credential that authenticated
+// the request being served.
+func credentialFromContext(ctx context.Context) string {
+	credential, _ := ctx.Value(credentialKey).(string)
+	return credential
+}
+
+// requestID assigns every request an id, reusing the X-Request-Id header set
+// by the client or a proxy when present, and echoes it in the response.
+func (h *handler) requestID(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set("X-Request-Id", requestID)
+		handle(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID)), ps)
+	}
+}
@@ -0,0 +1,310 @@
+// Package scimclient is a client of SCIM 2.0 service providers (RFC 7644),
+// managing their users and groups.
+package scimclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxErrorBodyLength bounds the response body read into an Error.
+const maxErrorBodyLength = 4096
+
+// Client sends requests to a SCIM service provider.
+type Client struct {
+	baseURL   string
+	http      *http.Client
+	authorize func(*http.Request)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends the requests with c instead of http.DefaultClient.
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) {
+		client.http = c
+	}
+}
+
+// WithBasicAuth authenticates the requests with a username and password.
+func WithBasicAuth(username, password string) Option {
+	return func(client *Client) {
+		client.authorize = func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+}
+
+// WithBearerToken authenticates the requests with a bearer token.
+func WithBearerToken(token string) Option {
+	return func(client *Client) {
+		client.authorize = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+// New returns a client of the service provider with the base URL baseURL,
+// e.g. https://example.com/scim/v2.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		http:      http.DefaultClient,
+		authorize: func(*http.Request) {},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error response of the service provider.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return fmt.Sprintf("SCIM error %d (%s): %s", e.Status, e.ScimType, e.Detail)
+	}
+	return fmt.Sprintf("SCIM error %d: %s", e.Status, e.Detail)
+}
+
+// StatusCode returns the HTTP status of err if it is an Error, and 0
+// otherwise.
+func StatusCode(err error) int {
+	var scimErr *Error
+	if errors.As(err, &scimErr) {
+		return scimErr.Status
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a 404 Not Found response.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 Conflict response, returned for
+// resources that already exist.
+func IsConflict(err error) bool {
+	return StatusCode(err) == http.StatusConflict
+}
+
+// Eq returns the filter matching resources whose attribute equals value.
+func Eq(attribute, value string) string {
+	quoted, _ := json.Marshal(value)
+	return attribute + " eq " + string(quoted)
+}
+
+// ServiceProviderConfig returns the features supported by the service
+// provider.
+func (c *Client) ServiceProviderConfig(ctx context.Context) (ServiceProviderConfig, error) {
+	var config ServiceProviderConfig
+	err := c.do(ctx, http.MethodGet, "/ServiceProviderConfig", nil, &config)
+	return config, err
+}
+
+// CreateUser creates a user and returns it as stored by the service provider.
+func (c *Client) CreateUser(ctx context.Context, user User) (User, error) {
+	user.Schemas = []string{UserSchema}
+	var created User
+	err := c.do(ctx, http.MethodPost, "/Users", user, &created)
+	return created, err
+}
+
+// GetUser returns the user with the id.
+func (c *Client) GetUser(ctx context.Context, id string) (User, error) {
+	var user User
+	err := c.do(ctx, http.MethodGet, "/Users/"+url.PathEscape(id), nil, &user)
+	return user, err
+}
+
+// ReplaceUser replaces the attributes of the user with the id.
+func (c *Client) ReplaceUser(ctx context.Context, id string, user User) (User, error) {
+	user.Schemas = []string{UserSchema}
+	user.ID = id
+	var replaced User
+	err := c.do(ctx, http.MethodPut, "/Users/"+url.PathEscape(id), user, &replaced)
+	return replaced, err
+}
+
+// PatchUser applies operations to the user with the id.
+func (c *Client) PatchUser(ctx context.Context, id string, operations ...PatchOperation) error {
+	return c.patch(ctx, "/Users/"+url.PathEscape(id), operations)
+}
+
+// DeleteUser deletes the user with the id.
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/Users/"+url.PathEscape(id), nil, nil)
+}
+
+// FindUsers returns the users matching filter, e.g. Eq("userName", name).
+func (c *Client) FindUsers(ctx context.Context, filter string) ([]User, error) {
+	var users []User
+	err := c.find(ctx, "/Users", filter, &users)
+	return users, err
+}
+
+// CreateGroup creates a group and returns it as stored by the service
+// provider.
+func (c *Client) CreateGroup(ctx context.Context, group Group) (Group, error) {
+	group.Schemas = []string{GroupSchema}
+	var created Group
+	err := c.do(ctx, http.MethodPost, "/Groups", group, &created)
+	return created, err
+}
+
+// GetGroup returns the group with the id.
+func (c *Client) GetGroup(ctx context.Context, id string) (Group, error) {
+	var group Group
+	err := c.do(ctx, http.MethodGet, "/Groups/"+url.PathEscape(id), nil, &group)
+	return group, err
+}
+
+// ReplaceGroup replaces the display name and members of the group with the
+// id.
+func (c *Client) ReplaceGroup(ctx context.Context, id string, group Group) (Group, error) {
+	group.Schemas = []string{GroupSchema}
+	group.ID = id
+	var replaced Group
+	err := c.do(ctx, http.MethodPut, "/Groups/"+url.PathEscape(id), group, &replaced)
+	return replaced, err
+}
+
+// PatchGroup applies operations to the group with the id.
+func (c *Client) PatchGroup(ctx context.Context, id string, operations ...PatchOperation) error {
+	return c.patch(ctx, "/Groups/"+url.PathEscape(id), operations)
+}
+
+// DeleteGroup deletes the group with the id.
+func (c *Client) DeleteGroup(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/Groups/"+url.PathEscape(id), nil, nil)
+}
+
+// FindGroups returns the groups matching filter, e.g.
+// Eq("displayName", name).
+func (c *Client) FindGroups(ctx context.Context, filter string) ([]Group, error) {
+	var groups []Group
+	err := c.find(ctx, "/Groups", filter, &groups)
+	return groups, err
+}
+
+func (c *Client) patch(ctx context.Context, path string, operations []PatchOperation) error {
+	body := struct {
+		Schemas    []string         `json:"schemas"`
+		Operations []PatchOperation `json:"Operations"`
+	}{
+		Schemas:    []string{PatchOpSchema},
+		Operations: operations,
+	}
+	return c.do(ctx, http.MethodPatch, path, body, nil)
+}
+
+// find lists the resources of an endpoint matching filter into resources, a
+// pointer to a slice. Besides list responses, service providers that answer
+// with a bare array or resource, or with 404 Not Found when nothing matches,
+// are understood.
+func (c *Client) find(ctx context.Context, path, filter string, resources interface{}) error {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, path+"?filter="+url.QueryEscape(filter), nil, &raw)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] == '[' {
+		return unmarshalResources(raw, resources)
+	}
+
+	var list struct {
+		Schemas   []string          `json:"schemas"`
+		Resources []json.RawMessage `json:"Resources"`
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+	for _, schema := range list.Schemas {
+		if schema == ListResponseSchema {
+			items, err := json.Marshal(list.Resources)
+			if err != nil {
+				return err
+			}
+			return unmarshalResources(items, resources)
+		}
+	}
+	return unmarshalResources(append(append([]byte("["), raw...), ']'), resources)
+}
+
+func unmarshalResources(raw []byte, resources interface{}) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return json.Unmarshal(raw, resources)
+}
+
+// do sends a request with body encoded as JSON, and decodes the response
+// into result unless it is nil. Responses but 2xx are returned as an Error.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	c.authorize(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid response to %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// responseError reads the SCIM error of a response, falling back to the body
+// as the detail for service providers that respond with plain text.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+	scimErr := &Error{Status: resp.StatusCode, Detail: strings.TrimSpace(string(body))}
+
+	var parsed struct {
+		ScimType string `json:"scimType"`
+		Detail   string `json:"detail"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Detail != "" {
+		scimErr.ScimType, scimErr.Detail = parsed.ScimType, parsed.Detail
+	}
+	return scimErr
+}
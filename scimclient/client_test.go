@@ -0,0 +1,276 @@
+package scimclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// fakeProvider serves the routes of a SCIM service provider, answering like
+// this server does: searches by userName with the bare user or an empty list
+// response, unknown resources with a plain text 404 and invalid requests
+// with a SCIM error.
+type fakeProvider struct {
+	users    map[string]User
+	requests []*http.Request
+	bodies   []json.RawMessage
+}
+
+func newFakeProvider(t *testing.T) (*fakeProvider, *Client) {
+	p := &fakeProvider{users: map[string]User{}}
+
+	router := httprouter.New()
+	router.POST("/scim/v2/Users", p.record(p.createUser))
+	router.GET("/scim/v2/Users", p.record(p.findUsers))
+	router.GET("/scim/v2/Users/:id", p.record(p.getUser))
+	router.PUT("/scim/v2/Users/:id", p.record(p.replaceUser))
+	router.PATCH("/scim/v2/Groups/:id", p.record(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	router.DELETE("/scim/v2/Users/:id", p.record(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if _, ok := p.users[ps.ByName("id")]; !ok {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		delete(p.users, ps.ByName("id"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return p, New(server.URL+"/scim/v2/", WithBearerToken("secret"), WithHTTPClient(server.Client()))
+}
+
+func (p *fakeProvider) record(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		p.requests = append(p.requests, r)
+		p.bodies = append(p.bodies, body)
+		handle(w, r, ps)
+	}
+}
+
+func (p *fakeProvider) createUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var user User
+	json.Unmarshal(p.bodies[len(p.bodies)-1], &user)
+	for _, existing := range p.users {
+		if existing.UserName == user.UserName {
+			writeError(w, http.StatusConflict, "uniqueness", "User already exists")
+			return
+		}
+	}
+	user.ID = strconv.Itoa(len(p.users) + 1)
+	p.users[user.ID] = user
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func (p *fakeProvider) findUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	for _, user := range p.users {
+		if r.URL.Query().Get("filter") == Eq("userName", user.UserName) {
+			json.NewEncoder(w).Encode(user)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemas":      []string{ListResponseSchema},
+		"totalResults": 0,
+		"Resources":    []interface{}{},
+	})
+}
+
+func (p *fakeProvider) getUser(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	user, ok := p.users[ps.ByName("id")]
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (p *fakeProvider) replaceUser(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	var user User
+	if err := json.Unmarshal(p.bodies[len(p.bodies)-1], &user); err != nil || user.UserName == "" {
+		writeError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	p.users[ps.ByName("id")] = user
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemas":  []string{ErrorSchema},
+		"scimType": scimType,
+		"detail":   detail,
+		"status":   strconv.Itoa(status),
+	})
+}
+
+func TestCreateAndFindUser(t *testing.T) {
+	p, client := newFakeProvider(t)
+	ctx := context.Background()
+
+	created, err := client.CreateUser(ctx, User{ExternalID: "00u1", UserName: "jane@example.com", Active: true})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if created.ID == "" || created.ExternalID != "00u1" {
+		t.Errorf("CreateUser = %+v, want an id and externalId 00u1", created)
+	}
+	req := p.requests[0]
+	if got := req.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want Bearer secret", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/scim+json" {
+		t.Errorf("Content-Type = %q, want application/scim+json", got)
+	}
+	var body User
+	json.Unmarshal(p.bodies[0], &body)
+	if !reflect.DeepEqual(body.Schemas, []string{UserSchema}) {
+		t.Errorf("schemas = %v, want %v", body.Schemas, []string{UserSchema})
+	}
+
+	// The bare user this server answers a search with is a match
+	found, err := client.FindUsers(ctx, Eq("userName", "jane@example.com"))
+	if err != nil {
+		t.Fatalf("FindUsers: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != created.ID {
+		t.Errorf("FindUsers = %+v, want the created user", found)
+	}
+
+	// As is an empty list response no match
+	found, err = client.FindUsers(ctx, Eq("userName", "john@example.com"))
+	if err != nil {
+		t.Fatalf("FindUsers: %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("FindUsers = %+v, want no users", found)
+	}
+
+	_, err = client.CreateUser(ctx, User{UserName: "jane@example.com"})
+	if !IsConflict(err) {
+		t.Fatalf("CreateUser of an existing user: got %v, want a conflict", err)
+	}
+	var scimErr *Error
+	if !errors.As(err, &scimErr) || scimErr.ScimType != "uniqueness" || scimErr.Detail != "User already exists" {
+		t.Errorf("CreateUser of an existing user: got %#v, want the SCIM error of the response", err)
+	}
+}
+
+func TestFindResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   []string
+	}{
+		{"list response", http.StatusOK, `{"schemas":["` + ListResponseSchema + `"],"Resources":[{"id":"1","userName":"a"},{"id":"2","userName":"b"}]}`, []string{"1", "2"}},
+		{"bare array", http.StatusOK, `[{"id":"1","userName":"a"}]`, []string{"1"}},
+		{"bare resource", http.StatusOK, `{"schemas":["` + UserSchema + `"],"id":"1","userName":"a"}`, []string{"1"}},
+		{"empty body", http.StatusOK, ``, nil},
+		{"not found", http.StatusNotFound, `No users found`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/Users" || r.URL.Query().Get("filter") != `userName eq "a"` {
+					t.Errorf("request %s, want /Users filtered by userName", r.URL)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			users, err := New(server.URL).FindUsers(context.Background(), Eq("userName", "a"))
+			if err != nil {
+				t.Fatalf("FindUsers: %v", err)
+			}
+			var ids []string
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("FindUsers ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceAndDeleteUser(t *testing.T) {
+	p, client := newFakeProvider(t)
+	ctx := context.Background()
+	p.users["a b"] = User{ID: "a b", UserName: "jane@example.com"}
+
+	replaced, err := client.ReplaceUser(ctx, "a b", User{UserName: "jane.doe@example.com", Active: true})
+	if err != nil {
+		t.Fatalf("ReplaceUser: %v", err)
+	}
+	if replaced.ID != "a b" || replaced.UserName != "jane.doe@example.com" {
+		t.Errorf("ReplaceUser = %+v, want the replaced user", replaced)
+	}
+	if got := p.requests[0].URL.EscapedPath(); got != "/scim/v2/Users/a%20b" {
+		t.Errorf("path = %s, want the id escaped", got)
+	}
+
+	_, err = client.ReplaceUser(ctx, "a b", User{})
+	if StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("ReplaceUser of an invalid user: got %v, want 400", err)
+	}
+
+	if err := client.DeleteUser(ctx, "a b"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	_, err = client.GetUser(ctx, "a b")
+	if !IsNotFound(err) {
+		t.Fatalf("GetUser of a deleted user: got %v, want not found", err)
+	}
+	var scimErr *Error
+	if !errors.As(err, &scimErr) || scimErr.Detail != "User not found" {
+		t.Errorf("GetUser of a deleted user: got %#v, want the plain text body as detail", err)
+	}
+	if err := client.DeleteUser(ctx, "a b"); !IsNotFound(err) {
+		t.Errorf("DeleteUser of a deleted user: got %v, want not found", err)
+	}
+}
+
+func TestPatchGroup(t *testing.T) {
+	p, client := newFakeProvider(t)
+
+	err := client.PatchGroup(context.Background(), "1",
+		PatchOperation{Op: PatchAdd, Path: "members", Value: []Member{{Value: "2"}}},
+		PatchOperation{Op: PatchRemove, Path: `members[value eq "3"]`},
+	)
+	if err != nil {
+		t.Fatalf("PatchGroup: %v", err)
+	}
+
+	var body struct {
+		Schemas    []string         `json:"schemas"`
+		Operations []PatchOperation `json:"Operations"`
+	}
+	if err := json.Unmarshal(p.bodies[0], &body); err != nil {
+		t.Fatalf("invalid PATCH body: %v", err)
+	}
+	if !reflect.DeepEqual(body.Schemas, []string{PatchOpSchema}) {
+		t.Errorf("schemas = %v, want %v", body.Schemas, []string{PatchOpSchema})
+	}
+	if len(body.Operations) != 2 || body.Operations[0].Op != PatchAdd || body.Operations[1].Path != `members[value eq "3"]` {
+		t.Errorf("Operations = %+v, want the add and remove operations", body.Operations)
+	}
+}
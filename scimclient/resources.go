@@ -0,0 +1,86 @@
+package scimclient
+
+// SCIM schema URNs
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// User is a SCIM user. ID is assigned by the service provider.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      bool     `json:"active"`
+}
+
+// Name is the name of a user.
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	MiddleName string `json:"middleName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// Email is an email address of a user.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Group is a SCIM group. ID is assigned by the service provider.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+}
+
+// Member is a member of a group, identified by its id at the service
+// provider.
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// Member types
+const (
+	MemberTypeUser  = "User"
+	MemberTypeGroup = "Group"
+)
+
+// PatchOperation is an operation of a PATCH request.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Patch operations
+const (
+	PatchAdd     = "add"
+	PatchRemove  = "remove"
+	PatchReplace = "replace"
+)
+
+// ServiceProviderConfig is the part of the configuration of a service
+// provider that clients adapt to.
+type ServiceProviderConfig struct {
+	Patch  Supported `json:"patch"`
+	Filter Supported `json:"filter"`
+}
+
+// Supported reports whether an optional feature is supported.
+type Supported struct {
+	Supported bool `json:"supported"`
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"okta-scim/db"
+	"okta-scim/scimclient"
+)
+
+// sinkSCIM is the outbox sink type provisioning a SCIM target.
+const sinkSCIM = "scim"
+
+// defaultSCIMTargetTimeout bounds requests to SCIM targets without a timeout.
+const defaultSCIMTargetTimeout = 30 * time.Second
+
+// scimTargetConfig configures a SCIM service provider the users and groups of
+// a tenant are provisioned to, through the scim:<name> outbox sink.
+type scimTargetConfig struct {
+	Name string `yaml:"name"`
+	// URL is the SCIM base URL of the target, e.g. https://app/scim/v2.
+	URL string `yaml:"url"`
+	// Tenant is the tenant provisioned to the target, the default tenant
+	// unless set.
+	Tenant string `yaml:"tenant"`
+	// Username and Password, or BearerToken, authenticate to the target.
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+	BearerToken string        `yaml:"bearerToken"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// validateSCIMTargets checks the configured SCIM targets.
+func validateSCIMTargets(targets []scimTargetConfig) error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, target := range targets {
+		if target.Name == "" || names[target.Name] {
+			errs = append(errs, fmt.Errorf("scimTargets[%d].name must be set and unique", i))
+		}
+		names[target.Name] = true
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("scimTargets[%d].url must be an http or https URL", i))
+		}
+		if (target.Username == "") != (target.Password == "") {
+			errs = append(errs, fmt.Errorf("scimTargets[%d].username and scimTargets[%d].password must be set together", i, i))
+		}
+		if target.Username != "" && target.BearerToken != "" {
+			errs = append(errs, fmt.Errorf("scimTargets[%d] must use either basic authentication or a bearer token", i))
+		}
+		if target.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("scimTargets[%d].timeout must not be negative", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findSCIMTarget returns the configuration of the SCIM target with the name.
+func findSCIMTarget(targets []scimTargetConfig, name string) (scimTargetConfig, error) {
+	i := slices.IndexFunc(targets, func(t scimTargetConfig) bool { return t.Name == name })
+	if i < 0 {
+		return scimTargetConfig{}, fmt.Errorf("SCIM target %s not found in scimTargets", name)
+	}
+	return targets[i], nil
+}
+
+// scimTarget provisions the users and groups of a tenant to a SCIM service
+// provider, mapping the local ids to the ids the target assigned in the
+// ScimTargetResource table. As an outbox sink, it applies the changes of the
+// tenant in order.
+type scimTarget struct {
+	config  scimTargetConfig
+	client  *scimclient.Client
+	mapping *attributeMapping
+	logger  *slog.Logger
+
+	// patch reports whether the target supports PATCH, nil until known.
+	patch *bool
+}
+
+func newSCIMTarget(config scimTargetConfig, mapping *attributeMapping, logger *slog.Logger) *scimTarget {
+	if config.Tenant == "" {
+		config.Tenant = defaultTenantName
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultSCIMTargetTimeout
+	}
+
+	opts := []scimclient.Option{scimclient.WithHTTPClient(&http.Client{Timeout: config.Timeout})}
+	switch {
+	case config.BearerToken != "":
+		opts = append(opts, scimclient.WithBearerToken(config.BearerToken))
+	case config.Username != "":
+		opts = append(opts, scimclient.WithBasicAuth(config.Username, config.Password))
+	}
+
+	return &scimTarget{
+		config:  config,
+		client:  scimclient.New(config.URL, opts...),
+		mapping: mapping,
+		logger:  logger.With("scim_target", config.Name),
+	}
+}
+
+// Publish applies the changes of the tenant of the target to it. Changes the
+// target rejects as invalid are logged and skipped, other failures are
+// retried with the rest of the events.
+func (t *scimTarget) Publish(ctx context.Context, q *db.Queries, events []db.Outbox) error {
+	tenant, err := q.GetTenantByName(ctx, t.config.Tenant)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("tenant %s of SCIM target %s not found", t.config.Tenant, t.config.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	s := t.sync(q, tenant.ID)
+	for _, event := range events {
+		if event.TenantID != tenant.ID {
+			continue
+		}
+		var change ChangeEvent
+		if err := json.Unmarshal(event.Payload, &change); err != nil {
+			return err
+		}
+
+		err := s.apply(ctx, change)
+		if isRejectedBySCIMTarget(err) {
+			scimTargetOperationsTotal.WithLabelValues(t.config.Name, "rejected").Inc()
+			t.logger.Warn("SCIM target rejected change, skipping it",
+				"event_id", change.ID,
+				"event_type", change.Type,
+				"resource_id", change.ResourceID,
+				"error", err,
+			)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply %s of %s to SCIM target %s: %w", change.Type, change.ResourceID, t.config.Name, err)
+		}
+		scimTargetOperationsTotal.WithLabelValues(t.config.Name, "applied").Inc()
+	}
+	return nil
+}
+
+// Resync provisions every user and group of the tenant of the target through
+// q, creating those the target is missing and replacing the others. Users and
+// groups the target rejects are logged and skipped.
+func (t *scimTarget) Resync(ctx context.Context, q *db.Queries) (users, groups int, err error) {
+	tenant, err := q.GetTenantByName(ctx, t.config.Tenant)
+	if err != nil {
+		return 0, 0, fmt.Errorf("tenant %s of SCIM target %s: %w", t.config.Tenant, t.config.Name, err)
+	}
+	s := t.sync(q, tenant.ID)
+
+	stored, err := listAllUsers(ctx, q, tenant.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, user := range stored {
+		_, err := s.syncUser(ctx, user.OktaID)
+		if isRejectedBySCIMTarget(err) {
+			t.skipRejected(memberTypeUser, user.OktaID, err)
+			continue
+		}
+		if err != nil {
+			return users, groups, fmt.Errorf("user %s: %w", user.OktaID, err)
+		}
+		users++
+	}
+
+	storedGroups, err := listAllGroups(ctx, q, tenant.ID)
+	if err != nil {
+		return users, groups, err
+	}
+	for _, group := range storedGroups {
+		_, err := s.syncGroup(ctx, group.OktaID.String)
+		if isRejectedBySCIMTarget(err) {
+			t.skipRejected(memberTypeGroup, group.OktaID.String, err)
+			continue
+		}
+		if err != nil {
+			return users, groups, fmt.Errorf("group %s: %w", group.OktaID.String, err)
+		}
+		groups++
+	}
+	return users, groups, nil
+}
+
+// skipRejected logs and counts a user or group the target rejected during a
+// resynchronization.
+func (t *scimTarget) skipRejected(resourceType, localID string, err error) {
+	scimTargetOperationsTotal.WithLabelValues(t.config.Name, "rejected").Inc()
+	t.logger.Warn("SCIM target rejected "+resourceType+", skipping it", "resource_id", localID, "error", err)
+}
+
+// supportsPatch reports whether the target supports PATCH, asking it once.
+// Targets without a ServiceProviderConfig endpoint are assumed not to.
+func (t *scimTarget) supportsPatch(ctx context.Context) (bool, error) {
+	if t.patch == nil {
+		config, err := t.client.ServiceProviderConfig(ctx)
+		if err != nil && !scimclient.IsNotFound(err) {
+			return false, err
+		}
+		t.patch = &config.Patch.Supported
+	}
+	return *t.patch, nil
+}
+
+// isRejectedBySCIMTarget reports whether err is a response of a target
+// rejecting a change as invalid, which retrying does not fix.
+func isRejectedBySCIMTarget(err error) bool {
+	if errors.Is(err, errSCIMTargetNotAdoptable) {
+		return true
+	}
+	switch scimclient.StatusCode(err) {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// errSCIMTargetNotAdoptable is returned for users and groups that are not
+// mapped yet when the resources matching them at the target may belong to
+// another local user or group.
+var errSCIMTargetNotAdoptable = errors.New("matching resource at the target cannot be adopted")
+
+// adoptableResource returns the id at the target of a user or group that is
+// not mapped yet, among the resources matching it there, or "" when none
+// does. A resource is only adopted when it is the only match and was not
+// provisioned for another local resource, that is its externalId is empty or
+// the local id, so that a local user or group never takes over another's.
+func adoptableResource[T any](resourceType, localID string, found []T, ids func(T) (id, externalID string)) (string, error) {
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		id, externalID := ids(found[0])
+		if externalID != "" && externalID != localID {
+			return "", fmt.Errorf("%w: %s %s has externalId %s", errSCIMTargetNotAdoptable, resourceType, id, externalID)
+		}
+		return id, nil
+	default:
+		return "", fmt.Errorf("%w: %d %ss match", errSCIMTargetNotAdoptable, len(found), resourceType)
+	}
+}
+
+func userIDs(u scimclient.User) (string, string)   { return u.ID, u.ExternalID }
+func groupIDs(g scimclient.Group) (string, string) { return g.ID, g.ExternalID }
+
+func (t *scimTarget) sync(q *db.Queries, tenantID int32) *scimTargetSync {
+	return &scimTargetSync{target: t, q: q, tenantID: tenantID, syncing: make(map[string]bool)}
+}
+
+// scimTargetSync applies the local state of users and groups of a tenant to
+// a target.
+type scimTargetSync struct {
+	target   *scimTarget
+	q        *db.Queries
+	tenantID int32
+	// syncing holds the groups being synchronized, so that nested groups that
+	// contain each other are only created.
+	syncing map[string]bool
+}
+
+// apply brings the resource of a change up to date at the target. Users and
+// groups are replaced with their current local state rather than the state
+// in the event, so that replayed events do no harm.
+func (s *scimTargetSync) apply(ctx context.Context, event ChangeEvent) error {
+	var err error
+	switch event.Type {
+	case eventUserCreated, eventUserUpdated, eventUserDeactivated:
+		_, err = s.syncUser(ctx, event.ResourceID)
+	case eventUserDeleted:
+		userName, _ := event.Data["userName"].(string)
+		err = s.deleteResource(ctx, memberTypeUser, event.ResourceID, scimclient.Eq("userName", userName))
+	case eventGroupCreated, eventGroupRenamed:
+		_, err = s.syncGroup(ctx, event.ResourceID)
+	case eventGroupMemberAdded, eventGroupMemberRemoved:
+		err = s.syncMember(ctx, event)
+	case eventGroupDeleted:
+		displayName, _ := event.Data["displayName"].(string)
+		err = s.deleteResource(ctx, memberTypeGroup, event.ResourceID, scimclient.Eq("displayName", displayName))
+	}
+	return err
+}
+
+// remoteID returns the id of a local resource at the target, or "" when it
+// is not mapped.
+func (s *scimTargetSync) remoteID(ctx context.Context, resourceType, localID string) (string, error) {
+	remoteID, err := s.q.GetScimTargetResource(ctx, db.GetScimTargetResourceParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+	})
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return remoteID, err
+}
+
+func (s *scimTargetSync) mapResource(ctx context.Context, resourceType, localID, remoteID string) error {
+	return s.q.SetScimTargetResource(ctx, db.SetScimTargetResourceParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+		RemoteID:     remoteID,
+	})
+}
+
+// syncUser creates or replaces a user at the target, returning its id there.
+// Users that are not mapped yet are looked up by userName first. Nothing is
+// done for users deleted since, whose deletion follows.
+func (s *scimTargetSync) syncUser(ctx context.Context, localID string) (string, error) {
+	user, err := s.q.GetUserByOktaID(ctx, db.GetUserByOktaIDParams{TenantID: s.tenantID, OktaID: localID})
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	attributes, err := s.target.mapping.userSCIM(userFromEmployee(user))
+	if err != nil {
+		return "", err
+	}
+	resource := scimclient.User{
+		ExternalID: user.OktaID,
+		UserName:   attributes["userName"],
+		Name: &scimclient.Name{
+			GivenName:  attributes["name.givenName"],
+			MiddleName: attributes["name.middleName"],
+			FamilyName: attributes["name.familyName"],
+			Formatted:  user.Name,
+		},
+		DisplayName: user.Name,
+		Emails:      []scimclient.Email{{Value: attributes["emails.value"], Type: "work", Primary: true}},
+		Active:      user.Active,
+	}
+
+	client := s.target.client
+	remoteID, err := s.remoteID(ctx, memberTypeUser, localID)
+	if err != nil {
+		return "", err
+	}
+	if remoteID == "" {
+		found, err := client.FindUsers(ctx, scimclient.Eq("userName", resource.UserName))
+		if err != nil {
+			return "", err
+		}
+		if remoteID, err = adoptableResource(memberTypeUser, localID, found, userIDs); err != nil {
+			return "", err
+		}
+	}
+
+	if remoteID != "" {
+		_, err := client.ReplaceUser(ctx, remoteID, resource)
+		if err == nil {
+			return remoteID, s.mapResource(ctx, memberTypeUser, localID, remoteID)
+		}
+		if !scimclient.IsNotFound(err) {
+			return "", err
+		}
+		// The user was deleted at the target, create it again
+	}
+
+	created, err := client.CreateUser(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, s.mapResource(ctx, memberTypeUser, localID, created.ID)
+}
+
+// syncGroup creates or replaces a group with its members at the target,
+// returning its id there. Members missing at the target are created first.
+// Groups that are not mapped yet are looked up by displayName first. Nothing
+// is done for groups deleted since, whose deletion follows.
+func (s *scimTargetSync) syncGroup(ctx context.Context, localID string) (string, error) {
+	group, err := s.q.GetGroupByOktaID(ctx, db.GetGroupByOktaIDParams{
+		TenantID: s.tenantID,
+		OktaID:   sql.NullString{String: localID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Groups nested in a group being synchronized are only created, their
+	// members are synchronized with their own changes
+	syncMembers := !s.syncing[localID]
+	s.syncing[localID] = true
+	defer delete(s.syncing, localID)
+
+	members := []scimclient.Member{}
+	if syncMembers {
+		if members, err = s.remoteMembers(ctx, group.ID); err != nil {
+			return "", err
+		}
+	}
+	attributes, err := s.target.mapping.groupSCIM(localID, group.Name)
+	if err != nil {
+		return "", err
+	}
+	resource := scimclient.Group{
+		ExternalID:  localID,
+		DisplayName: attributes["displayName"],
+		Members:     members,
+	}
+
+	client := s.target.client
+	remoteID, err := s.remoteID(ctx, memberTypeGroup, localID)
+	if err != nil {
+		return "", err
+	}
+	if remoteID == "" {
+		found, err := client.FindGroups(ctx, scimclient.Eq("displayName", resource.DisplayName))
+		if err != nil {
+			return "", err
+		}
+		if remoteID, err = adoptableResource(memberTypeGroup, localID, found, groupIDs); err != nil {
+			return "", err
+		}
+	}
+
+	if remoteID != "" {
+		if !syncMembers {
+			return remoteID, s.mapResource(ctx, memberTypeGroup, localID, remoteID)
+		}
+		_, err := client.ReplaceGroup(ctx, remoteID, resource)
+		if err == nil {
+			return remoteID, s.mapResource(ctx, memberTypeGroup, localID, remoteID)
+		}
+		if !scimclient.IsNotFound(err) {
+			return "", err
+		}
+		// The group was deleted at the target, create it again
+	}
+
+	created, err := client.CreateGroup(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, s.mapResource(ctx, memberTypeGroup, localID, created.ID)
+}
+
+// remoteMembers returns the members of a local group by their ids at the
+// target, creating those that are not mapped yet. Members deleted since are
+// left out.
+func (s *scimTargetSync) remoteMembers(ctx context.Context, groupID int32) ([]scimclient.Member, error) {
+	users, err := s.q.GetGroupMembers(ctx, db.GetGroupMembersParams{TenantID: s.tenantID, OktaGroupID: groupID})
+	if err != nil {
+		return nil, err
+	}
+	memberGroups, err := s.q.GetGroupMemberGroups(ctx, db.GetGroupMemberGroupsParams{TenantID: s.tenantID, GroupID: groupID})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]scimclient.Member, 0, len(users)+len(memberGroups))
+	for _, user := range users {
+		remoteID, err := s.ensure(ctx, memberTypeUser, user.OktaID)
+		if err != nil {
+			return nil, err
+		}
+		if remoteID == "" {
+			continue
+		}
+		members = append(members, scimclient.Member{Value: remoteID, Display: user.Email, Type: scimclient.MemberTypeUser})
+	}
+	for _, group := range memberGroups {
+		remoteID, err := s.ensure(ctx, memberTypeGroup, group.OktaID.String)
+		if err != nil {
+			return nil, err
+		}
+		if remoteID == "" {
+			continue
+		}
+		members = append(members, scimclient.Member{Value: remoteID, Display: group.Name, Type: scimclient.MemberTypeGroup})
+	}
+	return members, nil
+}
+
+// ensure returns the id of a user or group at the target, provisioning it
+// when it is not mapped yet.
+func (s *scimTargetSync) ensure(ctx context.Context, resourceType, localID string) (string, error) {
+	remoteID, err := s.remoteID(ctx, resourceType, localID)
+	if err != nil || remoteID != "" {
+		return remoteID, err
+	}
+	if resourceType == memberTypeGroup {
+		return s.syncGroup(ctx, localID)
+	}
+	return s.syncUser(ctx, localID)
+}
+
+// syncMember applies a membership change to a group at the target, with a
+// PATCH adding or removing the member when the target supports it and by
+// replacing the group otherwise.
+func (s *scimTargetSync) syncMember(ctx context.Context, event ChangeEvent) error {
+	patch, err := s.target.supportsPatch(ctx)
+	if err != nil {
+		return err
+	}
+	groupID, err := s.remoteID(ctx, memberTypeGroup, event.ResourceID)
+	if err != nil {
+		return err
+	}
+	if !patch || groupID == "" {
+		_, err := s.syncGroup(ctx, event.ResourceID)
+		return err
+	}
+
+	memberType, _ := event.Data["type"].(string)
+	memberID, _ := event.Data["value"].(string)
+	if event.Type == eventGroupMemberRemoved {
+		remoteID, err := s.remoteID(ctx, memberType, memberID)
+		if err != nil || remoteID == "" {
+			return err
+		}
+		err = s.target.client.PatchGroup(ctx, groupID, scimclient.PatchOperation{
+			Op:   scimclient.PatchRemove,
+			Path: fmt.Sprintf("members[value eq %q]", remoteID),
+		})
+		if scimclient.IsNotFound(err) {
+			_, err = s.syncGroup(ctx, event.ResourceID)
+		}
+		return err
+	}
+
+	remoteID, err := s.ensure(ctx, memberType, memberID)
+	if err != nil || remoteID == "" {
+		return err
+	}
+	err = s.target.client.PatchGroup(ctx, groupID, scimclient.PatchOperation{
+		Op:    scimclient.PatchAdd,
+		Path:  "members",
+		Value: []scimclient.Member{{Value: remoteID, Type: memberType}},
+	})
+	if scimclient.IsNotFound(err) {
+		_, err = s.syncGroup(ctx, event.ResourceID)
+	}
+	return err
+}
+
+// deleteResource deletes a user or group at the target and its mapping.
+// Resources that are not mapped are looked up with filter.
+func (s *scimTargetSync) deleteResource(ctx context.Context, resourceType, localID, filter string) error {
+	client := s.target.client
+	remoteID, err := s.remoteID(ctx, resourceType, localID)
+	if err != nil {
+		return err
+	}
+	if remoteID == "" {
+		if resourceType == memberTypeGroup {
+			var found []scimclient.Group
+			if found, err = client.FindGroups(ctx, filter); err != nil {
+				return err
+			}
+			remoteID, err = adoptableResource(resourceType, localID, found, groupIDs)
+		} else {
+			var found []scimclient.User
+			if found, err = client.FindUsers(ctx, filter); err != nil {
+				return err
+			}
+			remoteID, err = adoptableResource(resourceType, localID, found, userIDs)
+		}
+		if err != nil || remoteID == "" {
+			return err
+		}
+	}
+
+	if resourceType == memberTypeGroup {
+		err = client.DeleteGroup(ctx, remoteID)
+	} else {
+		err = client.DeleteUser(ctx, remoteID)
+	}
+	if err != nil && !scimclient.IsNotFound(err) {
+		return err
+	}
+	return s.q.DeleteScimTargetResource(ctx, db.DeleteScimTargetResourceParams{
+		Target:       s.target.config.Name,
+		TenantID:     s.tenantID,
+		ResourceType: resourceType,
+		LocalID:      localID,
+	})
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	_ "github.com/lib/pq"
+
+	"okta-scim/db"
+	"okta-scim/scimclient"
+)
+
+func TestAdoptableResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		found   []scimclient.User
+		want    string
+		wantErr bool
+	}{
+		{"no match", nil, "", false},
+		{"match without externalId", []scimclient.User{{ID: "1"}}, "1", false},
+		{"match provisioned for the local user", []scimclient.User{{ID: "1", ExternalID: "00u1"}}, "1", false},
+		{"match provisioned for another user", []scimclient.User{{ID: "1", ExternalID: "00u2"}}, "", true},
+		{"several matches", []scimclient.User{{ID: "1"}, {ID: "2", ExternalID: "00u1"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adoptableResource(memberTypeUser, "00u1", tt.found, userIDs)
+			if tt.wantErr {
+				if !errors.Is(err, errSCIMTargetNotAdoptable) {
+					t.Fatalf("adoptableResource = %q, %v, want errSCIMTargetNotAdoptable", got, err)
+				}
+				if !isRejectedBySCIMTarget(err) {
+					t.Errorf("isRejectedBySCIMTarget(%v) = false, want unadoptable resources skipped", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("adoptableResource = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRejectedBySCIMTarget(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&scimclient.Error{Status: http.StatusBadRequest}, true},
+		{&scimclient.Error{Status: http.StatusConflict}, true},
+		{&scimclient.Error{Status: http.StatusUnprocessableEntity}, true},
+		{fmt.Errorf("user 00u1: %w", errSCIMTargetNotAdoptable), true},
+		{&scimclient.Error{Status: http.StatusNotFound}, false},
+		{&scimclient.Error{Status: http.StatusTooManyRequests}, false},
+		{&scimclient.Error{Status: http.StatusInternalServerError}, false},
+		{errors.New("connection refused"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isRejectedBySCIMTarget(tt.err); got != tt.want {
+			t.Errorf("isRejectedBySCIMTarget(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+// fakeSCIMTarget serves the users and groups of a SCIM service provider,
+// recording the requests it is sent.
+type fakeSCIMTarget struct {
+	patch    bool
+	users    map[string]scimclient.User
+	groups   map[string]scimclient.Group
+	requests []string
+	lastID   int
+}
+
+func newFakeSCIMTarget(t *testing.T, patch bool) (*fakeSCIMTarget, string) {
+	f := &fakeSCIMTarget{patch: patch, users: map[string]scimclient.User{}, groups: map[string]scimclient.Group{}}
+
+	router := httprouter.New()
+	router.GET("/ServiceProviderConfig", f.record(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		writeJSONResponse(w, http.StatusOK, scimclient.ServiceProviderConfig{Patch: scimclient.Supported{Supported: f.patch}})
+	}))
+	router.GET("/Users", f.record(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var found []scimclient.User
+		for _, user := range f.users {
+			if r.URL.Query().Get("filter") == scimclient.Eq("userName", user.UserName) {
+				found = append(found, user)
+			}
+		}
+		writeFakeList(w, found)
+	}))
+	router.POST("/Users", f.record(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var user scimclient.User
+		json.NewDecoder(r.Body).Decode(&user)
+		user.ID = f.nextID()
+		f.users[user.ID] = user
+		writeJSONResponse(w, http.StatusCreated, user)
+	}))
+	router.PUT("/Users/:id", f.record(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if _, ok := f.users[ps.ByName("id")]; !ok {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		var user scimclient.User
+		json.NewDecoder(r.Body).Decode(&user)
+		user.ID = ps.ByName("id")
+		f.users[user.ID] = user
+		writeJSONResponse(w, http.StatusOK, user)
+	}))
+	router.DELETE("/Users/:id", f.record(func(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+		if _, ok := f.users[ps.ByName("id")]; !ok {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		delete(f.users, ps.ByName("id"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	router.GET("/Groups", f.record(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var found []scimclient.Group
+		for _, group := range f.groups {
+			if r.URL.Query().Get("filter") == scimclient.Eq("displayName", group.DisplayName) {
+				found = append(found, group)
+			}
+		}
+		writeFakeList(w, found)
+	}))
+	router.POST("/Groups", f.record(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var group scimclient.Group
+		json.NewDecoder(r.Body).Decode(&group)
+		group.ID = f.nextID()
+		f.groups[group.ID] = group
+		writeJSONResponse(w, http.StatusCreated, group)
+	}))
+	router.PUT("/Groups/:id", f.record(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if _, ok := f.groups[ps.ByName("id")]; !ok {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		var group scimclient.Group
+		json.NewDecoder(r.Body).Decode(&group)
+		group.ID = ps.ByName("id")
+		f.groups[group.ID] = group
+		writeJSONResponse(w, http.StatusOK, group)
+	}))
+	router.PATCH("/Groups/:id", f.record(f.patchGroup))
+	router.DELETE("/Groups/:id", f.record(func(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+		if _, ok := f.groups[ps.ByName("id")]; !ok {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		delete(f.groups, ps.ByName("id"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+func (f *fakeSCIMTarget) record(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		handle(w, r, ps)
+	}
+}
+
+func (f *fakeSCIMTarget) nextID() string {
+	f.lastID++
+	return strconv.Itoa(f.lastID)
+}
+
+// patchGroup adds members and removes them by the members[value eq "id"]
+// path.
+func (f *fakeSCIMTarget) patchGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	group, ok := f.groups[ps.ByName("id")]
+	if !f.patch {
+		http.Error(w, "PATCH not supported", http.StatusNotImplemented)
+		return
+	}
+	if !ok {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Operations []struct {
+			Op    string              `json:"op"`
+			Path  string              `json:"path"`
+			Value []scimclient.Member `json:"value"`
+		} `json:"Operations"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	for _, op := range req.Operations {
+		switch op.Op {
+		case scimclient.PatchAdd:
+			group.Members = append(group.Members, op.Value...)
+		case scimclient.PatchRemove:
+			members := []scimclient.Member{}
+			for _, member := range group.Members {
+				if op.Path != fmt.Sprintf("members[value eq %q]", member.Value) {
+					members = append(members, member)
+				}
+			}
+			group.Members = members
+		}
+	}
+	f.groups[group.ID] = group
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestsFrom returns the requests recorded from index on.
+func (f *fakeSCIMTarget) requestsFrom(index int) []string {
+	return append([]string(nil), f.requests[index:]...)
+}
+
+func writeFakeList[T any](w http.ResponseWriter, resources []T) {
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{scimclient.ListResponseSchema},
+		"totalResults": len(resources),
+		"Resources":    append([]T{}, resources...),
+	})
+}
+
+// scimTargetTest provisions a tenant of its own to a fake target.
+type scimTargetTest struct {
+	t      *testing.T
+	ctx    context.Context
+	q      *db.Queries
+	tenant db.Tenant
+	fake   *fakeSCIMTarget
+	target *scimTarget
+}
+
+// newSCIMTargetTest migrates the database at TEST_DATABASE_URL and creates a
+// tenant provisioned to a fake target, skipping the test without a database.
+func newSCIMTargetTest(t *testing.T, patch bool) *scimTargetTest {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	dbConn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	m, err := newMigrator(dbConn)
+	if err != nil {
+		t.Fatalf("newMigrator: %v", err)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	q := db.New(dbConn)
+	tenant, err := q.CreateTenant(ctx, db.CreateTenantParams{Name: "scim-target-test-" + uuid.NewString(), SchemaExtensions: []string{}})
+	if err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	t.Cleanup(func() { q.DeleteTenant(ctx, tenant.Name) })
+
+	mapping, err := newAttributeMapping(attributeMappingConfig{})
+	if err != nil {
+		t.Fatalf("newAttributeMapping: %v", err)
+	}
+	fake, url := newFakeSCIMTarget(t, patch)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	target := newSCIMTarget(scimTargetConfig{Name: "test", URL: url, Tenant: tenant.Name}, mapping, logger)
+
+	return &scimTargetTest{t: t, ctx: ctx, q: q, tenant: tenant, fake: fake, target: target}
+}
+
+func (s *scimTargetTest) createUser(oktaID, name, email string) db.Employee {
+	user, err := s.q.CreateUser(s.ctx, db.CreateUserParams{
+		TenantID:   s.tenant.ID,
+		OktaID:     oktaID,
+		Name:       name,
+		Email:      email,
+		Extensions: json.RawMessage(`{}`),
+	})
+	if err != nil {
+		s.t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func (s *scimTargetTest) createGroup(oktaID, name string) db.Oktagroup {
+	group, err := s.q.CreateGroup(s.ctx, db.CreateGroupParams{
+		TenantID: s.tenant.ID,
+		OktaID:   sql.NullString{String: oktaID, Valid: true},
+		Name:     name,
+	})
+	if err != nil {
+		s.t.Fatalf("CreateGroup: %v", err)
+	}
+	return group
+}
+
+// publish publishes change events of the tenant to the target.
+func (s *scimTargetTest) publish(events ...ChangeEvent) {
+	s.t.Helper()
+	rows := make([]db.Outbox, 0, len(events))
+	for _, event := range events {
+		event.ID = uuid.NewString()
+		event.Tenant = s.tenant.Name
+		payload, err := json.Marshal(event)
+		if err != nil {
+			s.t.Fatalf("marshaling event: %v", err)
+		}
+		rows = append(rows, db.Outbox{TenantID: s.tenant.ID, EventType: event.Type, Payload: payload})
+	}
+	if err := s.target.Publish(s.ctx, s.q, rows); err != nil {
+		s.t.Fatalf("Publish: %v", err)
+	}
+}
+
+// remoteIDs returns the mapped ids at the target by resource type and local
+// id.
+func (s *scimTargetTest) remoteIDs() map[string]string {
+	s.t.Helper()
+	resources, err := s.q.ListScimTargetResources(s.ctx, db.ListScimTargetResourcesParams{Target: "test", TenantID: s.tenant.ID})
+	if err != nil {
+		s.t.Fatalf("ListScimTargetResources: %v", err)
+	}
+	ids := make(map[string]string, len(resources))
+	for _, resource := range resources {
+		ids[resource.ResourceType+" "+resource.LocalID] = resource.RemoteID
+	}
+	return ids
+}
+
+func TestSCIMTargetCreateAndReplay(t *testing.T) {
+	s := newSCIMTargetTest(t, true)
+	s.createUser("00u1", "Jane Doe", "jane@example.com")
+
+	created := ChangeEvent{Type: eventUserCreated, ResourceID: "00u1"}
+	s.publish(created)
+	ids := s.remoteIDs()
+	remoteID := ids["User 00u1"]
+	user, ok := s.fake.users[remoteID]
+	if !ok {
+		t.Fatalf("users at the target %v, want 00u1 created and mapped, mappings %v", s.fake.users, ids)
+	}
+	if user.ExternalID != "00u1" || user.UserName != "jane@example.com" || user.Name.GivenName != "Jane" || user.Name.FamilyName != "Doe" {
+		t.Errorf("user at the target = %+v, want the mapped attributes of 00u1", user)
+	}
+
+	// Replayed events replace the mapped user rather than creating another
+	from := len(s.fake.requests)
+	s.publish(created, created)
+	if len(s.fake.users) != 1 {
+		t.Errorf("%d users at the target after a replay, want 1", len(s.fake.users))
+	}
+	want := []string{"PUT /Users/" + remoteID, "PUT /Users/" + remoteID}
+	if got := s.fake.requestsFrom(from); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("requests of the replay = %v, want %v", got, want)
+	}
+}
+
+func TestSCIMTargetRecreate(t *testing.T) {
+	s := newSCIMTargetTest(t, true)
+	s.createUser("00u1", "Jane Doe", "jane@example.com")
+	s.createGroup("00g1", "Engineering")
+	s.publish(ChangeEvent{Type: eventUserCreated, ResourceID: "00u1"}, ChangeEvent{Type: eventGroupCreated, ResourceID: "00g1"})
+	before := s.remoteIDs()
+
+	// Deleted at the target out of band
+	s.fake.users = map[string]scimclient.User{}
+	s.fake.groups = map[string]scimclient.Group{}
+	s.publish(ChangeEvent{Type: eventUserUpdated, ResourceID: "00u1"}, ChangeEvent{Type: eventGroupRenamed, ResourceID: "00g1"})
+
+	after := s.remoteIDs()
+	for _, key := range []string{"User 00u1", "Group 00g1"} {
+		if after[key] == "" || after[key] == before[key] {
+			t.Errorf("%s mapped to %q after recreating, want a new id other than %q", key, after[key], before[key])
+		}
+	}
+	if _, ok := s.fake.users[after["User 00u1"]]; !ok {
+		t.Error("user not created again at the target")
+	}
+	if _, ok := s.fake.groups[after["Group 00g1"]]; !ok {
+		t.Error("group not created again at the target")
+	}
+}
+
+func TestSCIMTargetAdoption(t *testing.T) {
+	s := newSCIMTargetTest(t, true)
+	s.fake.users["10"] = scimclient.User{ID: "10", UserName: "jane@example.com"}
+	s.fake.users["11"] = scimclient.User{ID: "11", ExternalID: "00u9", UserName: "john@example.com"}
+	s.fake.groups["20"] = scimclient.Group{ID: "20", DisplayName: "Sales"}
+	s.fake.groups["21"] = scimclient.Group{ID: "21", DisplayName: "Sales"}
+	s.createUser("00u1", "Jane Doe", "jane@example.com")
+	s.createUser("00u2", "John Doe", "john@example.com")
+	s.createGroup("00g1", "Sales")
+
+	// Rejected resources are skipped rather than failing the batch
+	s.publish(
+		ChangeEvent{Type: eventUserCreated, ResourceID: "00u1"},
+		ChangeEvent{Type: eventUserCreated, ResourceID: "00u2"},
+		ChangeEvent{Type: eventGroupCreated, ResourceID: "00g1"},
+	)
+
+	ids := s.remoteIDs()
+	if ids["User 00u1"] != "10" || s.fake.users["10"].ExternalID != "00u1" {
+		t.Errorf("00u1 mapped to %q as %+v, want the matching user 10 adopted", ids["User 00u1"], s.fake.users["10"])
+	}
+	if id, ok := ids["User 00u2"]; ok {
+		t.Errorf("00u2 mapped to %s, want the user provisioned for 00u9 left alone", id)
+	}
+	if s.fake.users["11"].ExternalID != "00u9" || len(s.fake.users) != 2 {
+		t.Errorf("users at the target = %+v, want user 11 unchanged and none created", s.fake.users)
+	}
+	if id, ok := ids["Group 00g1"]; ok || len(s.fake.groups) != 2 {
+		t.Errorf("00g1 mapped to %q with groups %+v at the target, want ambiguous matches left alone", id, s.fake.groups)
+	}
+}
+
+func TestSCIMTargetMembers(t *testing.T) {
+	for _, patch := range []bool{true, false} {
+		t.Run(fmt.Sprintf("patch %t", patch), func(t *testing.T) {
+			s := newSCIMTargetTest(t, patch)
+			user := s.createUser("00u1", "Jane Doe", "jane@example.com")
+			group := s.createGroup("00g1", "Engineering")
+			s.publish(ChangeEvent{Type: eventGroupCreated, ResourceID: "00g1"})
+			groupID := s.remoteIDs()["Group 00g1"]
+
+			membership := db.AddGroupMemberParams{TenantID: s.tenant.ID, EmployeeID: user.ID, OktaGroupID: group.ID}
+			if err := s.q.AddGroupMember(s.ctx, membership); err != nil {
+				t.Fatalf("AddGroupMember: %v", err)
+			}
+			from := len(s.fake.requests)
+			s.publish(ChangeEvent{Type: eventGroupMemberAdded, ResourceID: "00g1", Data: auditMember(memberTypeUser, "00u1")})
+
+			// Members missing at the target are created first
+			userID := s.remoteIDs()["User 00u1"]
+			if userID == "" {
+				t.Fatal("member not created at the target")
+			}
+			if members := s.fake.groups[groupID].Members; len(members) != 1 || members[0].Value != userID {
+				t.Errorf("members at the target = %+v, want %s", members, userID)
+			}
+			wantMethod := "PUT /Groups/"
+			if patch {
+				wantMethod = "PATCH /Groups/"
+			}
+			if got := s.fake.requestsFrom(from); !containsRequest(got, wantMethod+groupID) {
+				t.Errorf("requests adding a member = %v, want %s%s", got, wantMethod, groupID)
+			}
+
+			if err := s.q.RemoveGroupMember(s.ctx, db.RemoveGroupMemberParams(membership)); err != nil {
+				t.Fatalf("RemoveGroupMember: %v", err)
+			}
+			from = len(s.fake.requests)
+			s.publish(ChangeEvent{Type: eventGroupMemberRemoved, ResourceID: "00g1", Data: auditMember(memberTypeUser, "00u1")})
+			if members := s.fake.groups[groupID].Members; len(members) != 0 {
+				t.Errorf("members at the target = %+v, want none", members)
+			}
+			if got := s.fake.requestsFrom(from); !containsRequest(got, wantMethod+groupID) {
+				t.Errorf("requests removing a member = %v, want %s%s", got, wantMethod, groupID)
+			}
+		})
+	}
+}
+
+func containsRequest(requests []string, request string) bool {
+	for _, r := range requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSCIMTargetDelete(t *testing.T) {
+	s := newSCIMTargetTest(t, true)
+	s.createUser("00u1", "Jane Doe", "jane@example.com")
+	s.publish(ChangeEvent{Type: eventUserCreated, ResourceID: "00u1"})
+	if _, err := s.q.DeleteUser(s.ctx, db.DeleteUserParams{TenantID: s.tenant.ID, OktaID: "00u1"}); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	// Events of deleted users apply nothing until their deletion
+	s.publish(
+		ChangeEvent{Type: eventUserUpdated, ResourceID: "00u1"},
+		ChangeEvent{Type: eventUserDeleted, ResourceID: "00u1", Data: map[string]interface{}{"userName": "jane@example.com"}},
+	)
+	if len(s.fake.users) != 0 {
+		t.Errorf("users at the target = %+v, want the deleted user deleted", s.fake.users)
+	}
+	if ids := s.remoteIDs(); len(ids) != 0 {
+		t.Errorf("mappings = %v, want the mapping deleted", ids)
+	}
+
+	// Unmapped users are only deleted when they can be adopted
+	s.fake.users["10"] = scimclient.User{ID: "10", UserName: "john@example.com"}
+	s.fake.users["11"] = scimclient.User{ID: "11", ExternalID: "00u9", UserName: "joan@example.com"}
+	s.publish(
+		ChangeEvent{Type: eventUserDeleted, ResourceID: "00u2", Data: map[string]interface{}{"userName": "john@example.com"}},
+		ChangeEvent{Type: eventUserDeleted, ResourceID: "00u3", Data: map[string]interface{}{"userName": "joan@example.com"}},
+	)
+	if _, ok := s.fake.users["10"]; ok {
+		t.Error("unmapped user without externalId not deleted")
+	}
+	if _, ok := s.fake.users["11"]; !ok {
+		t.Error("user provisioned for another local user deleted")
+	}
+}
+
+func TestSCIMTargetResync(t *testing.T) {
+	s := newSCIMTargetTest(t, false)
+	s.fake.users["10"] = scimclient.User{ID: "10", UserName: "jane@example.com"}
+	jane := s.createUser("00u1", "Jane Doe", "jane@example.com")
+	s.createUser("00u2", "John Doe", "john@example.com")
+	group := s.createGroup("00g1", "Engineering")
+	if err := s.q.AddGroupMember(s.ctx, db.AddGroupMemberParams{TenantID: s.tenant.ID, EmployeeID: jane.ID, OktaGroupID: group.ID}); err != nil {
+		t.Fatalf("AddGroupMember: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		users, groups, err := s.target.Resync(s.ctx, s.q)
+		if err != nil {
+			t.Fatalf("Resync: %v", err)
+		}
+		if users != 2 || groups != 1 {
+			t.Errorf("Resync = %d users, %d groups, want 2 and 1", users, groups)
+		}
+		if len(s.fake.users) != 2 || len(s.fake.groups) != 1 {
+			t.Errorf("%d users and %d groups at the target after resync %d, want 2 and 1", len(s.fake.users), len(s.fake.groups), i+1)
+		}
+	}
+
+	ids := s.remoteIDs()
+	if ids["User 00u1"] != "10" {
+		t.Errorf("00u1 mapped to %q, want the matching user 10 adopted", ids["User 00u1"])
+	}
+	if members := s.fake.groups[ids["Group 00g1"]].Members; len(members) != 1 || members[0].Value != "10" {
+		t.Errorf("members at the target = %+v, want user 10", members)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Scopes granted to credentials, each allowing a set of endpoints
+const (
+	scopeUsersRead   = "users:read"
+	scopeUsersWrite  = "users:write"
+	scopeGroupsRead  = "groups:read"
+	scopeGroupsWrite = "groups:write"
+	// scopeBulk is reserved for a /Bulk endpoint, no endpoint requires it
+	// yet.
+	scopeBulk  = "bulk"
+	scopeAdmin = "admin"
+
+	// scopeAuthenticated marks endpoints open to every authenticated
+	// credential, such as ServiceProviderConfig.
+	scopeAuthenticated = ""
+)
+
+// allScopes are the scopes of credentials that are not restricted, such as the
+// configured username and password unless auth.credentialScopes lists it.
+var allScopes = []string{scopeUsersRead, scopeUsersWrite, scopeGroupsRead, scopeGroupsWrite, scopeBulk, scopeAdmin}
+
+// defaultCredentialScopes are granted to credentials created without scopes:
+// what a provisioning client such as Okta needs, without bulk and admin.
+var defaultCredentialScopes = []string{scopeUsersRead, scopeUsersWrite, scopeGroupsRead, scopeGroupsWrite}
+
+// parseScopes validates scopes, returning them sorted without duplicates.
+func parseScopes(scopes []string) ([]string, error) {
+	parsed := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(allScopes, scope) {
+			return nil, fmt.Errorf("invalid scope %q: must be one of %s", scope, strings.Join(allScopes, ", "))
+		}
+		parsed = append(parsed, scope)
+	}
+	slices.Sort(parsed)
+	return slices.Compact(parsed), nil
+}
+
+// parseCredentialScopes parses <credential>=<scope>[+<scope>...] rules
+// restricting the scopes of the configured and client certificate
+// credentials, e.g.
+//
+//	reporting=users:read+groups:read
+//
+// Credentials without a rule are granted every scope.
+func parseCredentialScopes(rules []string) (map[string][]string, error) {
+	credentialScopes := make(map[string][]string)
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		credential, scopes, ok := strings.Cut(rule, "=")
+		if !ok || credential == "" || scopes == "" {
+			return nil, fmt.Errorf("invalid credential scopes rule %q: must be <credential>=<scope>[+<scope>...]", rule)
+		}
+		parsed, err := parseScopes(strings.Split(scopes, "+"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential scopes rule %q: %w", rule, err)
+		}
+		credentialScopes[credential] = parsed
+	}
+	return credentialScopes, nil
+}
+
+// configuredScopes returns the scopes of a configured or client certificate
+// credential.
+func (h *handler) configuredScopes(credential string) []string {
+	if scopes, ok := h.credentialScopes[credential]; ok {
+		return scopes
+	}
+	return allScopes
+}
+
+// scopesFromContext returns the scopes of the credential that authenticated
+// the request being served.
+func scopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey).([]string)
+	return scopes
+}
+
+// authorize rejects requests whose credential was not granted scope with
+// 403 Forbidden.
+func (h *handler) authorize(scope string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if scope != scopeAuthenticated && !slices.Contains(scopesFromContext(r.Context()), scope) {
+			writeSCIMError(w, http.StatusForbidden, "", fmt.Sprintf("The credential %s is not granted the %s scope", credentialFromContext(r.Context()), scope))
+			return
+		}
+		handle(w, r, ps)
+	}
+}
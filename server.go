@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serverConfig configures the HTTP server.
+type serverConfig struct {
+	Addr              string        `yaml:"addr"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	// DrainDelay is how long the server keeps serving after readiness starts
+	// failing, giving load balancers time to stop routing to it.
+	DrainDelay time.Duration `yaml:"drainDelay"`
+	// ShutdownTimeout bounds how long in-flight requests may take to complete
+	// once the server stops accepting connections.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// TLSCertFile and TLSKeyFile enable TLS. The files are reloaded when they
+	// change on disk, checked every TLSReloadInterval.
+	TLSCertFile       string        `yaml:"tlsCertFile"`
+	TLSKeyFile        string        `yaml:"tlsKeyFile"`
+	TLSReloadInterval time.Duration `yaml:"tlsReloadInterval"`
+	// ClientCAFile enables mutual TLS, verifying client certificates against
+	// the PEM bundle. Clients without a certificate are still accepted, to
+	// authenticate otherwise, unless ClientCertRequired is set.
+	ClientCAFile       string `yaml:"clientCAFile"`
+	ClientCertRequired bool   `yaml:"clientCertRequired"`
+	// H2C serves HTTP/2 without TLS.
+	H2C bool `yaml:"h2c"`
+	// BaseURL is the externally visible SCIM base URL used for meta.location,
+	// e.g. https://scim.example.com/scim/v2. When unset it is derived from
+	// each request, honoring X-Forwarded-* headers from TrustedProxies.
+	BaseURL        string   `yaml:"baseURL"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+func (c serverConfig) validate() error {
+	var errs []error
+	for name, d := range map[string]time.Duration{
+		"readTimeout":       c.ReadTimeout,
+		"readHeaderTimeout": c.ReadHeaderTimeout,
+		"writeTimeout":      c.WriteTimeout,
+		"idleTimeout":       c.IdleTimeout,
+		"drainDelay":        c.DrainDelay,
+		"shutdownTimeout":   c.ShutdownTimeout,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("server.%s must not be negative", name))
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("server.tlsCertFile and server.tlsKeyFile must be set together"))
+	}
+	if c.TLSEnabled() && c.H2C {
+		errs = append(errs, errors.New("server.h2c cannot be enabled together with TLS, which negotiates HTTP/2 itself"))
+	}
+	if c.ClientCAFile != "" && !c.TLSEnabled() {
+		errs = append(errs, errors.New("server.clientCAFile requires server.tlsCertFile and server.tlsKeyFile"))
+	}
+	if c.ClientCertRequired && c.ClientCAFile == "" {
+		errs = append(errs, errors.New("server.clientCertRequired requires server.clientCAFile"))
+	}
+	if c.TLSEnabled() && c.TLSReloadInterval <= 0 {
+		errs = append(errs, errors.New("server.tlsReloadInterval must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// TLSEnabled reports whether the server serves TLS.
+func (c serverConfig) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// certReloader serves the certificate from disk, loading it again whenever
+// the certificate or key file changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate every interval when its files have changed,
+// until ctx is canceled. A certificate that fails to load is logged and the
+// previous one kept.
+func (r *certReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if reloaded, err := r.reload(); err != nil {
+			r.logger.Error("Error reloading TLS certificate", "error", err)
+		} else if reloaded {
+			r.logger.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+		}
+	}
+}
+
+// reload loads the certificate if either file was modified since the last load.
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", file)
+	}
+	return pool, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// serve runs an HTTP server for handler until ctx is canceled, then fails
+// readiness, waits for the drain delay and shuts down gracefully, letting
+// in-flight requests complete within the shutdown timeout.
+func serve(ctx context.Context, config serverConfig, handler http.Handler, health *healthChecker, logger *slog.Logger) error {
+	if config.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: config.IdleTimeout})
+	}
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	if config.TLSEnabled() {
+		reloader, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile, logger)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		go reloader.Watch(ctx, config.TLSReloadInterval)
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		if config.ClientCAFile != "" {
+			clientCAs, err := loadCertPool(config.ClientCAFile)
+			if err != nil {
+				return err
+			}
+			server.TLSConfig.ClientCAs = clientCAs
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			if config.ClientCertRequired {
+				server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("Listening", "addr", config.Addr, "tls", config.TLSEnabled(), "h2c", config.H2C)
+		if config.TLSEnabled() {
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down", "drain_delay", config.DrainDelay, "timeout", config.ShutdownTimeout)
+	health.SetDraining()
+	time.Sleep(config.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	serviceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	// featuresExtensionSchema reports the optional features of this service.
+	featuresExtensionSchema = "urn:okta-scim:params:scim:schemas:extension:features:2.0:ServiceProviderConfig"
+)
+
+type SCIMSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type SCIMBulkConfig struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type SCIMFilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type SCIMAuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+type SCIMServiceProviderConfig struct {
+	Schemas               []string                   `json:"schemas"`
+	Patch                 SCIMSupported              `json:"patch"`
+	Bulk                  SCIMBulkConfig             `json:"bulk"`
+	Filter                SCIMFilterConfig           `json:"filter"`
+	ChangePassword        SCIMSupported              `json:"changePassword"`
+	Sort                  SCIMSupported              `json:"sort"`
+	ETag                  SCIMSupported              `json:"etag"`
+	AuthenticationSchemes []SCIMAuthenticationScheme `json:"authenticationSchemes"`
+	Features              map[string]bool            `json:"urn:okta-scim:params:scim:schemas:extension:features:2.0:ServiceProviderConfig"`
+	Meta                  SCIMMeta                   `json:"meta"`
+}
+
+// ServiceProviderConfig describes the SCIM features supported by the service
+// and the optional features that are enabled.
+func (h *handler) ServiceProviderConfig() httprouter.Handle {
+	return h.applyMiddlewares(scopeAuthenticated, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		// Stored credentials can always authenticate with basic authentication
+		schemes := []SCIMAuthenticationScheme{{
+			Type:        "httpbasic",
+			Name:        "HTTP Basic",
+			Description: "Authentication with a username and password",
+			Primary:     true,
+		}}
+		if h.certCredentials != nil {
+			schemes = append(schemes, SCIMAuthenticationScheme{
+				Type:        "tlsclientcert",
+				Name:        "TLS client certificate",
+				Description: "Authentication with a client certificate issued by a trusted CA",
+			})
+		}
+
+		config := SCIMServiceProviderConfig{
+			Schemas:               []string{serviceProviderConfigSchema, featuresExtensionSchema},
+			Filter:                SCIMFilterConfig{Supported: true, MaxResults: maxPageSize},
+			AuthenticationSchemes: schemes,
+			Features:              h.features,
+			Meta: SCIMMeta{
+				ResourceType: "ServiceProviderConfig",
+				Location:     h.locator.endpoint(r, "ServiceProviderConfig"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+	})
+}
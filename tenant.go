@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"okta-scim/db"
+)
+
+const (
+	// defaultTenantID is the tenant that owns the data of deployments predating
+	// tenants. It is served under /scim/v2 on hosts not assigned to another
+	// tenant, and its credentials can manage the other tenants.
+	defaultTenantID   = 1
+	defaultTenantName = "default"
+)
+
+// tenantNamePattern restricts tenant names to DNS labels so that they can be
+// used in paths and host names.
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// scimExtensionPattern matches the URNs of SCIM schema extensions.
+var scimExtensionPattern = regexp.MustCompile(`^urn:[A-Za-z0-9][A-Za-z0-9-]{0,31}:[^\s"]+$`)
+
+// tenantFromContext returns the tenant the request being served belongs to.
+func tenantFromContext(ctx context.Context) db.Tenant {
+	tenant, _ := ctx.Value(tenantKey).(db.Tenant)
+	return tenant
+}
+
+// tenantIDFromContext returns the id of the tenant the request being served
+// belongs to, or 0, which matches no data, when there is none.
+func tenantIDFromContext(ctx context.Context) int32 {
+	return tenantFromContext(ctx).ID
+}
+
+// withTenant returns a context scoped to tenant. pathPrefix is the prefix of
+// the tenant endpoints when the tenant was selected by path, e.g. /t/acme.
+func withTenant(ctx context.Context, tenant db.Tenant, pathPrefix string) context.Context {
+	ctx = context.WithValue(ctx, tenantKey, tenant)
+	return context.WithValue(ctx, tenantPathKey, pathPrefix)
+}
+
+// tenantPathFromContext returns the path prefix of the tenant endpoints.
+func tenantPathFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(tenantPathKey).(string)
+	return prefix
+}
+
+// resolveTenant selects the tenant of the request from the tenant path
+// parameter of /t/{tenant}/... routes, or else from the host the request was
+// sent to, falling back to the default tenant.
+func (h *handler) resolveTenant(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var tenant db.Tenant
+		var err error
+		var pathPrefix string
+
+		if name := ps.ByName("tenant"); name != "" {
+			tenant, err = h.db.GetTenantByName(r.Context(), name)
+			pathPrefix = "/t/" + name
+		} else {
+			tenant, err = h.db.GetTenantByHost(r.Context(), nullString(h.locator.hostname(r)))
+			if err == sql.ErrNoRows {
+				tenant, err = h.db.GetTenantByName(r.Context(), defaultTenantName)
+			}
+		}
+		if err == sql.ErrNoRows {
+			writeSCIMError(w, http.StatusNotFound, "", "Tenant not found")
+			return
+		}
+		if err != nil {
+			h.logger.Error("Error resolving tenant", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		handle(w, r.WithContext(withTenant(r.Context(), tenant, pathPrefix)), ps)
+	}
+}
+
+// requireDefaultTenant restricts an endpoint to the credentials of the
+// default tenant.
+func (h *handler) requireDefaultTenant(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if tenantIDFromContext(r.Context()) != defaultTenantID {
+			writeSCIMError(w, http.StatusForbidden, "", "Tenants are managed with the credentials of the default tenant")
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+// TenantResource is the representation of a tenant in the admin API.
+type TenantResource struct {
+	Name             string    `json:"name"`
+	Host             string    `json:"host,omitempty"`
+	SchemaExtensions []string  `json:"schemaExtensions"`
+	Created          time.Time `json:"created"`
+}
+
+// tenantRequest is the body of requests creating or updating a tenant.
+type tenantRequest struct {
+	Name             string   `json:"name"`
+	Host             string   `json:"host"`
+	SchemaExtensions []string `json:"schemaExtensions"`
+}
+
+func (req *tenantRequest) validate() error {
+	if !tenantNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("invalid tenant name %q: use up to 63 lowercase letters, digits and hyphens", req.Name)
+	}
+	req.Host = strings.ToLower(req.Host)
+	if req.Host != "" && (strings.ContainsAny(req.Host, "/:@ ") || net.ParseIP(req.Host) != nil) {
+		return fmt.Errorf("invalid host %q: use a host name without port", req.Host)
+	}
+	if req.SchemaExtensions == nil {
+		req.SchemaExtensions = []string{}
+	}
+	for _, extension := range req.SchemaExtensions {
+		if !scimExtensionPattern.MatchString(extension) {
+			return fmt.Errorf("invalid schema extension %q: use the URN of the extension", extension)
+		}
+	}
+	return nil
+}
+
+func tenantResource(t db.Tenant) TenantResource {
+	return TenantResource{
+		Name:             t.Name,
+		Host:             t.Host.String,
+		SchemaExtensions: t.SchemaExtensions,
+		Created:          t.CreatedAt.UTC(),
+	}
+}
+
+// auditTenant returns the audited attributes of a tenant.
+func auditTenant(t db.Tenant) map[string]interface{} {
+	return map[string]interface{}{
+		"name":             t.Name,
+		"host":             t.Host.String,
+		"schemaExtensions": t.SchemaExtensions,
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ListTenants lists the tenants.
+func (h *handler) ListTenants() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		tenants, err := h.db.ListTenants(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to retrieve tenants", http.StatusInternalServerError)
+			return
+		}
+
+		resources := make([]TenantResource, len(tenants))
+		for i, tenant := range tenants {
+			resources[i] = tenantResource(tenant)
+		}
+		writeJSONResponse(w, http.StatusOK, struct {
+			Tenants []TenantResource `json:"tenants"`
+		}{resources})
+	}))
+}
+
+// GetTenant returns a tenant.
+func (h *handler) GetTenant() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		h.withTenantByName(w, r, ps.ByName("name"), func(tenant db.Tenant) {
+			writeJSONResponse(w, http.StatusOK, tenantResource(tenant))
+		})
+	}))
+}
+
+// CreateTenant creates a tenant. Its users and groups are provisioned under
+// /t/{name}/scim/v2, or /scim/v2 on its host, with the tenant's credentials.
+func (h *handler) CreateTenant() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var req tenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDecodeError(w, err, err.Error())
+			return
+		}
+		if err := req.validate(); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+
+		var tenant db.Tenant
+		err := h.withTx(r.Context(), func(q *txQueries) error {
+			var err error
+			tenant, err = q.CreateTenant(r.Context(), db.CreateTenantParams{
+				Name:             req.Name,
+				Host:             nullString(req.Host),
+				SchemaExtensions: req.SchemaExtensions,
+			})
+			if err != nil {
+				return err
+			}
+
+			q.audit(auditEntry{
+				Operation:    auditCreate,
+				ResourceType: "Tenant",
+				ResourceID:   tenant.Name,
+				After:        auditTenant(tenant),
+			})
+			return nil
+		})
+		if isUniqueViolation(err) {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", "A tenant with this name or host already exists")
+			return
+		}
+		if err != nil {
+			h.logger.Error("Error creating tenant", "error", err)
+			http.Error(w, "Error creating tenant", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSONResponse(w, http.StatusCreated, tenantResource(tenant))
+	}))
+}
+
+// UpdateTenant replaces the host and schema extensions of a tenant.
+func (h *handler) UpdateTenant() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req tenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDecodeError(w, err, err.Error())
+			return
+		}
+		req.Name = ps.ByName("name")
+		if err := req.validate(); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+
+		var tenant db.Tenant
+		err := h.withTx(r.Context(), func(q *txQueries) error {
+			before, err := q.GetTenantByName(r.Context(), req.Name)
+			if err != nil {
+				return err
+			}
+			tenant, err = q.UpdateTenant(r.Context(), db.UpdateTenantParams{
+				Name:             req.Name,
+				Host:             nullString(req.Host),
+				SchemaExtensions: req.SchemaExtensions,
+			})
+			if err != nil {
+				return err
+			}
+
+			q.audit(auditEntry{
+				Operation:    auditUpdate,
+				ResourceType: "Tenant",
+				ResourceID:   tenant.Name,
+				Before:       auditTenant(before),
+				After:        auditTenant(tenant),
+			})
+			return nil
+		})
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		case isUniqueViolation(err):
+			writeSCIMError(w, http.StatusConflict, "uniqueness", "Another tenant uses this host")
+			return
+		case err != nil:
+			h.logger.Error("Error updating tenant", "error", err)
+			http.Error(w, "Error updating tenant", http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, tenantResource(tenant))
+	}))
+}
+
+// DeleteTenant deletes a tenant with its users, groups and credentials. Its
+// audit log is kept.
+func (h *handler) DeleteTenant() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name := ps.ByName("name")
+		if name == defaultTenantName {
+			writeSCIMError(w, http.StatusBadRequest, "mutability", "The default tenant cannot be deleted")
+			return
+		}
+
+		err := h.withTx(r.Context(), func(q *txQueries) error {
+			tenant, err := q.GetTenantByName(r.Context(), name)
+			if err != nil {
+				return err
+			}
+			if _, err := q.DeleteTenant(r.Context(), name); err != nil {
+				return err
+			}
+
+			q.audit(auditEntry{
+				Operation:    auditDelete,
+				ResourceType: "Tenant",
+				ResourceID:   name,
+				Before:       auditTenant(tenant),
+			})
+			return nil
+		})
+		if err == sql.ErrNoRows {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.logger.Error("Error deleting tenant", "error", err)
+			http.Error(w, "Error deleting tenant", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// credentialRequest is the body of requests creating a tenant credential.
+// Scopes defaults to defaultCredentialScopes.
+type credentialRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CredentialSecret is returned once when a credential is created or rotated.
+type CredentialSecret struct {
+	Tenant string   `json:"tenant"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+	Secret string   `json:"secret"`
+}
+
+// CreateTenantCredential creates a basic authentication credential for a
+// tenant and returns its secret, which is not stored.
+func (h *handler) CreateTenantCredential() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req credentialRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDecodeError(w, err, err.Error())
+			return
+		}
+		if req.Name == "" {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", "name is required")
+			return
+		}
+		scopes := defaultCredentialScopes
+		if req.Scopes != nil {
+			var err error
+			if scopes, err = parseScopes(req.Scopes); err != nil {
+				writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		}
+
+		h.withTenantByName(w, r, ps.ByName("name"), func(tenant db.Tenant) {
+			secret, err := generateSecret()
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			err = h.withTx(r.Context(), func(q *txQueries) error {
+				if _, err := q.CreateCredential(r.Context(), db.CreateCredentialParams{
+					TenantID:   tenant.ID,
+					Name:       req.Name,
+					SecretHash: hashSecret(secret),
+					Scopes:     scopes,
+				}); err != nil {
+					return err
+				}
+
+				q.audit(auditEntry{
+					Operation:    auditCreate,
+					ResourceType: "Credential",
+					ResourceID:   tenant.Name + "/" + req.Name,
+					After:        map[string]interface{}{"scopes": scopes},
+				})
+				return nil
+			})
+			if isUniqueViolation(err) {
+				writeSCIMError(w, http.StatusConflict, "uniqueness", "A credential with this name already exists")
+				return
+			}
+			if err != nil {
+				h.logger.Error("Error creating credential", "error", err)
+				http.Error(w, "Error creating credential", http.StatusInternalServerError)
+				return
+			}
+
+			writeJSONResponse(w, http.StatusCreated, CredentialSecret{Tenant: tenant.Name, Name: req.Name, Scopes: scopes, Secret: secret})
+		})
+	}))
+}
+
+// RotateTenantCredential replaces the secret of a tenant credential.
+func (h *handler) RotateTenantCredential() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		h.withTenantByName(w, r, ps.ByName("name"), func(tenant db.Tenant) {
+			name := ps.ByName("credential")
+			secret, err := generateSecret()
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			err = h.withTx(r.Context(), func(q *txQueries) error {
+				rotated, err := q.RotateCredential(r.Context(), db.RotateCredentialParams{
+					TenantID:   tenant.ID,
+					Name:       name,
+					SecretHash: hashSecret(secret),
+				})
+				if err != nil {
+					return err
+				}
+				if rotated == 0 {
+					return sql.ErrNoRows
+				}
+
+				q.audit(auditEntry{
+					Operation:    auditUpdate,
+					ResourceType: "Credential",
+					ResourceID:   tenant.Name + "/" + name,
+				})
+				return nil
+			})
+			if err == sql.ErrNoRows {
+				http.Error(w, "Credential not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				h.logger.Error("Error rotating credential", "error", err)
+				http.Error(w, "Error rotating credential", http.StatusInternalServerError)
+				return
+			}
+			writeJSONResponse(w, http.StatusOK, CredentialSecret{Tenant: tenant.Name, Name: name, Secret: secret})
+		})
+	}))
+}
+
+// RevokeTenantCredential revokes a tenant credential.
+func (h *handler) RevokeTenantCredential() httprouter.Handle {
+	return h.applyMiddlewares(scopeAdmin, h.requireDefaultTenant(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		h.withTenantByName(w, r, ps.ByName("name"), func(tenant db.Tenant) {
+			name := ps.ByName("credential")
+			err := h.withTx(r.Context(), func(q *txQueries) error {
+				revoked, err := q.RevokeCredential(r.Context(), db.RevokeCredentialParams{TenantID: tenant.ID, Name: name})
+				if err != nil {
+					return err
+				}
+				if revoked == 0 {
+					return sql.ErrNoRows
+				}
+
+				q.audit(auditEntry{
+					Operation:    auditDelete,
+					ResourceType: "Credential",
+					ResourceID:   tenant.Name + "/" + name,
+				})
+				return nil
+			})
+			if err == sql.ErrNoRows {
+				http.Error(w, "Credential not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				h.logger.Error("Error revoking credential", "error", err)
+				http.Error(w, "Error revoking credential", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}))
+}
+
+// withTenantByName calls handle with the tenant named name, responding with
+// 404 Not Found when it does not exist.
+func (h *handler) withTenantByName(w http.ResponseWriter, r *http.Request, name string, handle func(db.Tenant)) {
+	tenant, err := h.db.GetTenantByName(r.Context(), name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	handle(tenant)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/lib/pq"
+	"okta-scim/db"
+)
+
+// PostgreSQL errors of transactions that conflicted with concurrent ones and
+// succeed when retried
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// maxTxRetries bounds how often a conflicting transaction is retried.
+const maxTxRetries = 4
+
+// txQueries are the queries of a transaction. Audit log entries are recorded
+// in the transaction too, so that they are kept only with the changes they
+// describe.
+type txQueries struct {
+	*db.Queries
+	audits []auditEntry
+}
+
+// audit adds an entry to the audit log when the transaction commits.
+func (q *txQueries) audit(entry auditEntry) {
+	q.audits = append(q.audits, entry)
+}
+
+// withTx runs fn in a serializable transaction with queries bound to it and
+// commits it. Transactions conflicting with concurrent ones are rolled back
+// and retried with exponential backoff, so fn must not have effects outside
+// of the transaction. Errors returned by fn are returned unchanged.
+func (h *handler) withTx(ctx context.Context, fn func(q *txQueries) error) error {
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = 10 * time.Millisecond
+	policy.MaxInterval = 500 * time.Millisecond
+
+	var audits []auditEntry
+	err := backoff.Retry(func() error {
+		var err error
+		audits, err = h.attemptTx(ctx, fn)
+		if isTxConflict(err) {
+			dbTxRetriesTotal.Inc()
+			return err
+		}
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		return nil
+	}, backoff.WithContext(backoff.WithMaxRetries(policy, maxTxRetries), ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range audits {
+		provisioningOperationsTotal.WithLabelValues(entry.ResourceType, entry.Operation).Inc()
+	}
+	return nil
+}
+
+func (h *handler) attemptTx(ctx context.Context, fn func(q *txQueries) error) ([]auditEntry, error) {
+	tx, err := h.dbConn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q := &txQueries{Queries: db.New(instrumentDB(tx))}
+	if err := fn(q); err != nil {
+		return nil, err
+	}
+
+	// A failed statement aborts the transaction, so an audit log entry that
+	// cannot be recorded fails the change
+	for _, entry := range q.audits {
+		if err := recordAudit(ctx, q.Queries, entry); err != nil {
+			return nil, err
+		}
+	}
+	return q.audits, tx.Commit()
+}
+
+// isTxConflict reports whether err is a serialization failure or deadlock.
+func isTxConflict(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+}
@@ -0,0 +1,66 @@
+// Package webhook sends events to webhook endpoints, signed with the secret
+// of the endpoint so that receivers can verify their origin.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorLength bounds the response body kept as the error of a failed
+// delivery.
+const maxErrorLength = 1024
+
+// Event is an event sent to an endpoint.
+type Event struct {
+	ID      string
+	Type    string
+	Payload []byte
+}
+
+// Sign returns the signature of a payload sent at timestamp, the hex encoded
+// HMAC-SHA256 of "<timestamp>.<payload>" keyed with the secret of the
+// endpoint.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts the event signed with secret to the endpoint at url. Any response
+// but 2xx is returned as an error with the start of the response body.
+func Send(ctx context.Context, client *http.Client, url, secret string, event Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(event.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "okta-scim-webhooks")
+	req.Header.Set("X-Webhook-Id", event.ID)
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(secret, timestamp, event.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return fmt.Errorf("endpoint responded with %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`1700000000.{"id":"1"}`))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("secret", 1700000000, []byte(`{"id":"1"}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", 1700000000, []byte(`{"id":"1"}`)) == want {
+		t.Error("Sign with another secret returned the same signature")
+	}
+	if Sign("secret", 1700000001, []byte(`{"id":"1"}`)) == want {
+		t.Error("Sign at another timestamp returned the same signature")
+	}
+}
+
+func TestSend(t *testing.T) {
+	event := Event{ID: "0b4f7a8e", Type: "user.created", Payload: []byte(`{"type":"user.created"}`)}
+
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	before := time.Now().Unix()
+	if err := Send(context.Background(), server.Client(), server.URL+"/hooks", "secret", event); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if received.Method != http.MethodPost || received.URL.Path != "/hooks" {
+		t.Errorf("request %s %s, want POST /hooks", received.Method, received.URL.Path)
+	}
+	if string(body) != string(event.Payload) {
+		t.Errorf("body = %s, want %s", body, event.Payload)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":    "application/json",
+		"X-Webhook-Id":    event.ID,
+		"X-Webhook-Event": event.Type,
+	} {
+		if got := received.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// Receivers verify the signature over the timestamp and the body
+	timestamp, err := strconv.ParseInt(received.Header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Fatalf("X-Webhook-Timestamp = %q, want the time of sending", received.Header.Get("X-Webhook-Timestamp"))
+	}
+	if got, want := received.Header.Get("X-Webhook-Signature"), Sign("secret", timestamp, body); got != want {
+		t.Errorf("X-Webhook-Signature = %s, want %s", got, want)
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	event := Event{ID: "1", Type: "group.deleted", Payload: []byte(`{}`)}
+
+	t.Run("error response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, strings.Repeat("x", 2*maxErrorLength), http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		err := Send(context.Background(), server.Client(), server.URL, "secret", event)
+		if err == nil || !strings.Contains(err.Error(), "503 Service Unavailable") {
+			t.Fatalf("Send = %v, want the status of the response", err)
+		}
+		if len(err.Error()) > 2*maxErrorLength {
+			t.Errorf("Send error of %d bytes, want the body truncated", len(err.Error()))
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		}))
+		defer server.Close()
+
+		if err := Send(context.Background(), server.Client(), server.URL, "secret", event); err == nil {
+			t.Error("Send succeeded, want responses but 2xx to fail")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		}))
+		defer server.Close()
+		defer close(done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := Send(ctx, server.Client(), server.URL, "secret", event); err == nil {
+			t.Error("Send succeeded, want the attempt to time out")
+		}
+	})
+}
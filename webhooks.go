@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"okta-scim/db"
+	"okta-scim/webhook"
+)
+
+const (
+	// webhookBatchSize is the number of deliveries claimed at once.
+	webhookBatchSize = 50
+
+	// webhookPruneInterval is how often delivered events are deleted.
+	webhookPruneInterval = time.Hour
+)
+
+// webhookConfig configures the delivery of provisioning events to the
+// webhook endpoints of the tenants.
+type webhookConfig struct {
+	// PollInterval is how often the queue is checked for due deliveries.
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// Timeout bounds each delivery attempt.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts is the number of attempts after which a delivery is moved
+	// to the dead letters.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// MinBackoff and MaxBackoff bound the exponentially growing delay between
+	// attempts.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// Retention is how long delivered events are kept.
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (c webhookConfig) validate() error {
+	var errs []error
+	if c.PollInterval <= 0 || c.Timeout <= 0 {
+		errs = append(errs, errors.New("webhooks.pollInterval and webhooks.timeout must be positive"))
+	}
+	if c.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhooks.maxAttempts must be positive"))
+	}
+	if c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		errs = append(errs, errors.New("webhooks.minBackoff must be positive and not above webhooks.maxBackoff"))
+	}
+	if c.Retention <= 0 {
+		errs = append(errs, errors.New("webhooks.retention must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// backoff returns the delay before the next attempt of a delivery that failed
+// attempts times.
+func (c webhookConfig) backoff(attempts int) time.Duration {
+	delay := c.MinBackoff
+	for i := 1; i < attempts && delay < c.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxBackoff)
+}
+
+// webhookSink publishes events from the outbox by queuing them for delivery
+// to the webhook endpoints of their tenant subscribed to their type. The
+// deliveries are queued in the transaction that advances the position of the
+// sink, so that every event is queued once.
+type webhookSink struct{}
+
+func (webhookSink) Publish(ctx context.Context, q *db.Queries, events []db.Outbox) error {
+	for _, event := range events {
+		if err := q.EnqueueWebhookEvent(ctx, db.EnqueueWebhookEventParams{
+			TenantID:  event.TenantID,
+			EventID:   event.EventID,
+			EventType: event.EventType,
+			Payload:   event.Payload,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookDispatcher delivers the queued events to the webhook endpoints,
+// retrying failed deliveries with exponential backoff. Events are delivered
+// at least once: a delivery interrupted by a restart is attempted again.
+type webhookDispatcher struct {
+	config webhookConfig
+	db     *db.Queries
+	client *http.Client
+	logger *slog.Logger
+}
+
+func newWebhookDispatcher(config webhookConfig, queries *db.Queries, client *http.Client, logger *slog.Logger) *webhookDispatcher {
+	return &webhookDispatcher{
+		config: config,
+		db:     queries,
+		client: client,
+		logger: logger,
+	}
+}
+
+// Run delivers the due events every PollInterval and deletes delivered events
+// past their retention every webhookPruneInterval until ctx is canceled.
+func (d *webhookDispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.config.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(webhookPruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+				d.logger.Error("Error delivering webhook events", "error", err)
+			}
+		case <-prune.C:
+			if deleted, err := d.db.DeleteDeliveredWebhookDeliveries(ctx, time.Now().Add(-d.config.Retention)); err != nil {
+				d.logger.Error("Error deleting delivered webhook events", "error", err)
+			} else if deleted > 0 {
+				d.logger.Info("Deleted delivered webhook events", "count", deleted)
+			}
+		}
+	}
+}
+
+// DeliverDue attempts every delivery that is due, in batches of
+// webhookBatchSize delivered concurrently.
+func (d *webhookDispatcher) DeliverDue(ctx context.Context) error {
+	for {
+		// Lease the deliveries until every attempt of the batch timed out
+		deliveries, err := d.db.ClaimWebhookDeliveries(ctx, db.ClaimWebhookDeliveriesParams{
+			LeaseUntil: time.Now().Add(2 * d.config.Timeout),
+			BatchSize:  webhookBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery db.ClaimWebhookDeliveriesRow) {
+				defer wg.Done()
+				d.attempt(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// attempt sends a delivery and records the outcome, scheduling a retry or
+// moving the delivery to the dead letters when it failed.
+func (d *webhookDispatcher) attempt(ctx context.Context, delivery db.ClaimWebhookDeliveriesRow) {
+	logger := d.logger.With("endpoint", delivery.Endpoint, "event_id", delivery.EventID, "event_type", delivery.EventType)
+
+	sendErr := d.send(ctx, delivery)
+	if sendErr != nil && ctx.Err() != nil {
+		// Interrupted by shutdown, the delivery is claimed again once its
+		// lease ends
+		return
+	}
+
+	// Record the outcome even if shutdown starts meanwhile
+	ctx = context.WithoutCancel(ctx)
+	attempts := int(delivery.Attempts) + 1
+	var err error
+	switch {
+	case sendErr == nil:
+		webhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+		err = d.db.MarkWebhookDelivered(ctx, delivery.ID)
+	case attempts >= d.config.MaxAttempts:
+		webhookDeliveriesTotal.WithLabelValues("failed").Inc()
+		logger.Error("Webhook delivery failed, moved to dead letters", "attempts", attempts, "error", sendErr)
+		err = d.db.FailWebhookDelivery(ctx, db.FailWebhookDeliveryParams{
+			ID:        delivery.ID,
+			LastError: sql.NullString{String: sendErr.Error(), Valid: true},
+		})
+	default:
+		webhookDeliveriesTotal.WithLabelValues("retried").Inc()
+		retryAt := time.Now().Add(d.config.backoff(attempts))
+		logger.Warn("Webhook delivery failed, retrying", "attempts", attempts, "retry_at", retryAt, "error", sendErr)
+		err = d.db.RetryWebhookDelivery(ctx, db.RetryWebhookDeliveryParams{
+			ID:            delivery.ID,
+			NextAttemptAt: retryAt,
+			LastError:     sql.NullString{String: sendErr.Error(), Valid: true},
+		})
+	}
+	if err != nil {
+		logger.Error("Error recording webhook delivery", "error", err)
+	}
+}
+
+// send posts the signed event to the endpoint, failing the attempt after
+// Timeout.
+func (d *webhookDispatcher) send(ctx context.Context, delivery db.ClaimWebhookDeliveriesRow) error {
+	ctx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	return webhook.Send(ctx, d.client, delivery.Url, delivery.Secret, webhook.Event{
+		ID:      delivery.EventID.String(),
+		Type:    delivery.EventType,
+		Payload: delivery.Payload,
+	})
+}